@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/flxp49/notion-watchlistarr/internal/app"
 	"github.com/flxp49/notion-watchlistarr/internal/config"
+	"github.com/flxp49/notion-watchlistarr/internal/notify"
 	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
 	"github.com/flxp49/notion-watchlistarr/internal/radarr"
 	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+	"github.com/flxp49/notion-watchlistarr/internal/store"
 	"github.com/flxp49/notion-watchlistarr/server"
 	"github.com/joho/godotenv"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 func main() {
+	// "healthcheck" probes /healthz of the running instance, used by the Docker HEALTHCHECK
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck())
+	}
 	logFile := &lumberjack.Logger{
 		Filename:   "notionwatchlistarrsync.log",
 		MaxSize:    10,   // Max size in MB
@@ -49,10 +63,52 @@ func main() {
 		Logger.Error("Both Radarr and Sonarr cannot be disabled")
 		os.Exit(1)
 	}
+	if !app.ValidDuplicatePolicy(cfg.DuplicatePolicy) {
+		Logger.Error("DUPLICATE_POLICY must be all or canonical", "DuplicatePolicy", cfg.DuplicatePolicy)
+		os.Exit(1)
+	}
+	if !notion.ValidStatusType(cfg.DownloadStatusType) {
+		Logger.Error("DOWNLOAD_STATUS_TYPE must be select or status", "DownloadStatusType", cfg.DownloadStatusType)
+		os.Exit(1)
+	}
+	statuses, err := notion.LoadStatusDefinitions(cfg.StatusConfig)
+	if err == nil {
+		err = notion.UseStatuses(statuses, cfg.ExtraStatuses)
+	}
+	if err != nil {
+		Logger.Error("Invalid status definitions", "Error", err)
+		os.Exit(1)
+	}
+	Notifier, err := notify.NewNotifier(cfg.NotifyURLs, cfg.NotifyTemplate, Logger)
+	if err != nil {
+		Logger.Error("Invalid notification settings", "Error", err)
+		os.Exit(1)
+	}
+	Notifier.DryRun = cfg.DryRun
 
 	R := radarr.InitRadarrClient(cfg.RadarrKey, cfg.RadarrHost)
 	S := sonarr.InitSonarrClient(cfg.SonarrKey, cfg.SonarrHost)
 	N := notion.InitNotionClient(cfg.NotionSecret, cfg.NotionDBID)
+	N.StatusType = cfg.DownloadStatusType
+	if cfg.DryRun {
+		// writes to Notion/Radarr/Sonarr are logged instead of performed
+		N.DryRun = app.LogPlannedAction(Logger, "notion")
+		R.DryRun = app.LogPlannedAction(Logger, "radarr")
+		S.DryRun = app.LogPlannedAction(Logger, "sonarr")
+		Logger.Warn("DryRun", "Status", "Enabled, nothing is written to Notion/Radarr/Sonarr")
+	}
+	webhookSettings := app.WebhookSettings{PublicURL: cfg.PublicURL, Username: cfg.WebhookUsername, Password: cfg.WebhookPassword, Token: cfg.WebhookToken}
+
+	// "doctor" runs read-only checks against the services and exits
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		doctor := app.NewApp(N, R, S, Logger, time.Duration(cfg.PollInternvalSec), time.Duration(cfg.WatchlistSyncIntervalHr), cfg.RadarrInit, cfg.SonarrInit)
+		doctor.Webhook = webhookSettings
+		doctor.DuplicatePolicy = cfg.DuplicatePolicy
+		if !app.WriteDoctorReport(os.Stdout, doctor.Doctor()) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	// To manage Root Paths and Quality Profiles and update Notion DB with it.
 	Rpid := make(map[string]string)
@@ -79,6 +135,24 @@ Start:
 			goto Start
 		}
 	}
+	N.UseProfiles(Qpid, Rpid)
+	// "report [table|json|notion]" prints the watchlist / library drift and exits, it needs the profiles fetched above
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		format := "table"
+		if len(os.Args) > 2 {
+			format = os.Args[2]
+		}
+		reporter := app.NewApp(N, R, S, Logger, time.Duration(cfg.PollInternvalSec), time.Duration(cfg.WatchlistSyncIntervalHr), cfg.RadarrInit, cfg.SonarrInit)
+		reporter.ReportStuckDays = cfg.ReportStuckDays
+		reporter.ReportPageID = cfg.NotionReportPageID
+		os.Exit(runReport(reporter, format))
+	}
+	// a status type Download Status is made in Notion, the updates fail without its options
+	err = N.CheckStatusProperty()
+	if err != nil {
+		Logger.Error("Download Status property not ready", "Error", err)
+		os.Exit(1)
+	}
 	// Add properties to the DB
 	err = N.AddDBProperties(Qpid, Rpid)
 	if err != nil {
@@ -86,18 +160,108 @@ Start:
 		goto Start
 	}
 	Logger.Info("Database updated with new properties")
+	if cfg.NotionCalendarDBID != "" {
+		err = N.AddCalendarProperties(cfg.NotionCalendarDBID)
+		if err != nil {
+			Logger.Error("Failed to add properties to calendar DB", "Error", err)
+			os.Exit(1)
+		}
+	}
 
 	app := app.NewApp(N, R, S, Logger, time.Duration(cfg.PollInternvalSec), time.Duration(cfg.WatchlistSyncIntervalHr), cfg.RadarrInit, cfg.SonarrInit)
-	app.RunApp()
+	app.Webhook = webhookSettings
+	app.PollSchedule = cfg.PollSchedule
+	app.SyncSchedule = cfg.SyncSchedule
+	app.Scheduler.Jitter = time.Duration(cfg.ScheduleJitterSec) * time.Second
+	app.DryRun = cfg.DryRun
+	app.DuplicatePolicy = cfg.DuplicatePolicy
+	app.CalendarDBID = cfg.NotionCalendarDBID
+	app.CalendarDays = cfg.CalendarDays
+	app.CalendarSchedule = cfg.CalendarSchedule
+	app.Notifier = Notifier
+	// Tracked titles survive restarts
+	St, err := store.Open(filepath.Join(cfg.DataDir, "state.db"))
+	if err != nil {
+		Logger.Error("Failed to open state store", "Error", err)
+		os.Exit(1)
+	}
+	err = app.UseStore(St)
+	if err != nil {
+		Logger.Error("Failed to load state store", "Error", err)
+		os.Exit(1)
+	}
+	app.RegisterWebhooks()
 
-	Server := server.NewServer(cfg.Port, N, R, S, Logger, cfg.RadarrInit, cfg.SonarrInit)
-	err = Server.Start()
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeoutSec) * time.Second
+	// SIGINT/SIGTERM stop the jobs and the server, in-flight work is finished first
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = app.RunApp(ctx)
+	if err != nil {
+		Logger.Error("Failed to schedule jobs", "Error", err)
+		os.Exit(1)
+	}
+
+	// Webhook events are persisted until processed so restarts and outages don't lose them
+	Q, err := queue.NewQueue(filepath.Join(cfg.DataDir, "webhook-queue.json"), cfg.WebhookMaxAttempts, Logger)
+	if err != nil {
+		Logger.Error("Failed to load webhook queue", "Error", err)
+		os.Exit(1)
+	}
+	webhookAuth := server.WebhookAuth{Username: cfg.WebhookUsername, Password: cfg.WebhookPassword, Token: cfg.WebhookToken}
+	Server := server.NewServer(cfg.BindAddress, cfg.Port, webhookAuth, cfg.AllowUnauthenticatedWebhook, N, R, S, Q, app.Tracker, app, Logger, cfg.RadarrInit, cfg.SonarrInit)
+	Server.ShutdownTimeout = shutdownTimeout
+	Server.DuplicatePolicy = cfg.DuplicatePolicy
+	Server.Notifier = Notifier
+	err = Server.Start(ctx)
+	if err != nil {
+		Logger.Error("Server failed", "Error", err)
+	}
+	// stop the jobs too if the server failed on its own
+	stop()
+	if !app.Wait(shutdownTimeout) {
+		Logger.Warn("Jobs still running, exiting anyway")
+	}
+	if !Notifier.Close(shutdownTimeout) {
+		Logger.Warn("Notifications still pending, exiting anyway")
+	}
+	St.Close()
+	Logger.Info("Stopped")
+	logFile.Close()
 	if err != nil {
-		Logger.Error("Server failed to listen", "Error", err)
 		os.Exit(1)
 	}
 }
 
+func runReport(reporter *app.App, format string) int {
+	report, err := reporter.Report(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	switch format {
+	case "table":
+		err = app.WriteReport(os.Stdout, report)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	case "notion":
+		var url string
+		url, err = reporter.PublishReport(report)
+		if err == nil {
+			fmt.Println(url)
+		}
+	default:
+		err = fmt.Errorf("unknown report format %q, expected table, json or notion", format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
 func waitForService(radarrInit bool, radarrHost string, sonarrInit bool, sonarrHost string) bool {
 	status := false
 	client := http.Client{}
@@ -119,3 +283,24 @@ func waitForService(radarrInit bool, radarrHost string, sonarrInit bool, sonarrH
 	}
 	return status
 }
+
+func healthcheck() int {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "7879"
+	}
+	host := os.Getenv("BIND_ADDRESS")
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
@@ -6,4 +6,9 @@ require github.com/joho/godotenv v1.5.1
 
 require github.com/caarlos0/env/v11 v11.1.0
 
-require gopkg.in/natefinch/lumberjack.v2 v2.2.1
+require (
+	go.etcd.io/bbolt v1.3.10
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+)
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,231 @@
+package activity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event sources
+const (
+	SourceWebhook = "webhook"
+	SourcePoll    = "poll"
+	SourceSync    = "sync"
+	SourceManual  = "manual"
+)
+
+// Title is the last known state of a watchlist title
+type Title struct {
+	PageID         string    `json:"pageId"`
+	ImdbID         string    `json:"imdbId"`
+	Title          string    `json:"title,omitempty"`
+	MediaType      string    `json:"mediaType"`
+	Status         string    `json:"status"`
+	RadarrID       int       `json:"radarrId,omitempty"`
+	SonarrID       int       `json:"sonarrId,omitempty"`
+	TmdbID         int       `json:"tmdbId,omitempty"`
+	TvdbID         int       `json:"tvdbId,omitempty"`
+	LastTransition time.Time `json:"lastTransition"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// Event is an entry of the recent activity log
+type Event struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Service string    `json:"service"`
+	Type    string    `json:"type"`
+	ImdbID  string    `json:"imdbId,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// TitleStore persists the tracked titles across restarts
+type TitleStore interface {
+	LoadTitles() ([]Title, error)
+	SaveTitle(title Title) error
+	DeleteTitle(pageID string) error
+}
+
+// Tracker keeps the titles seen by the poll, sync and webhook handlers and a ring buffer of recent events
+type Tracker struct {
+	mu     sync.RWMutex
+	titles map[string]Title
+	store  TitleStore
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewTracker keeps the last eventCapacity events
+func NewTracker(eventCapacity int) *Tracker {
+	return &Tracker{titles: make(map[string]Title), events: make([]Event, eventCapacity)}
+}
+
+// Persist loads the titles of the store and writes every later change through to it
+func (t *Tracker) Persist(store TitleStore) error {
+	titles, err := store.LoadTitles()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, title := range titles {
+		t.titles[title.PageID] = title
+	}
+	t.store = store
+	return nil
+}
+
+// save writes the title to the store, a failure is recorded as an event. Caller holds mu
+func (t *Tracker) save(title Title) {
+	if t.store == nil {
+		return
+	}
+	err := t.store.SaveTitle(title)
+	if err != nil {
+		t.record(Event{Service: "store", Type: "save", ImdbID: title.ImdbID, Title: title.Title, Error: err.Error()})
+	}
+}
+
+// UpdateTitle merges the update into the known state of the page, zero fields are left untouched.
+//
+// LastTransition is moved only when the status changes.
+func (t *Tracker) UpdateTitle(update Title) {
+	if update.PageID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	title := t.titles[update.PageID]
+	title.PageID = update.PageID
+	if update.ImdbID != "" {
+		title.ImdbID = update.ImdbID
+	}
+	if update.Title != "" {
+		title.Title = update.Title
+	}
+	if update.MediaType != "" {
+		title.MediaType = update.MediaType
+	}
+	if update.RadarrID != 0 {
+		title.RadarrID = update.RadarrID
+	}
+	if update.SonarrID != 0 {
+		title.SonarrID = update.SonarrID
+	}
+	if update.TmdbID != 0 {
+		title.TmdbID = update.TmdbID
+	}
+	if update.TvdbID != 0 {
+		title.TvdbID = update.TvdbID
+	}
+	if update.Status != "" && update.Status != title.Status {
+		title.Status = update.Status
+		title.LastTransition = now
+	}
+	title.UpdatedAt = now
+	t.titles[update.PageID] = title
+	t.save(title)
+}
+
+// Forget drops the page, ex: when it no longer exists in Notion
+func (t *Tracker) Forget(pageID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	title, exists := t.titles[pageID]
+	if !exists {
+		return
+	}
+	delete(t.titles, pageID)
+	if t.store != nil {
+		err := t.store.DeleteTitle(pageID)
+		if err != nil {
+			t.record(Event{Service: "store", Type: "delete", ImdbID: title.ImdbID, Title: title.Title, Error: err.Error()})
+		}
+	}
+}
+
+// Title returns the known state of the page
+func (t *Tracker) Title(pageID string) (Title, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	title, exists := t.titles[pageID]
+	return title, exists
+}
+
+// TitlesByImdb returns the known pages of the title, most recently updated first
+func (t *Tracker) TitlesByImdb(imdbID string) []Title {
+	t.mu.RLock()
+	var titles []Title
+	for _, title := range t.titles {
+		if title.ImdbID == imdbID && imdbID != "" {
+			titles = append(titles, title)
+		}
+	}
+	t.mu.RUnlock()
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].UpdatedAt.Equal(titles[j].UpdatedAt) {
+			return titles[i].PageID < titles[j].PageID
+		}
+		return titles[i].UpdatedAt.After(titles[j].UpdatedAt)
+	})
+	return titles
+}
+
+// Titles returns every known title, most recently changed first
+func (t *Tracker) Titles() []Title {
+	t.mu.RLock()
+	titles := make([]Title, 0, len(t.titles))
+	for _, title := range t.titles {
+		titles = append(titles, title)
+	}
+	t.mu.RUnlock()
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].LastTransition.Equal(titles[j].LastTransition) {
+			return titles[i].PageID < titles[j].PageID
+		}
+		return titles[i].LastTransition.After(titles[j].LastTransition)
+	})
+	return titles
+}
+
+// Record adds an event to the ring buffer, overwriting the oldest one when full
+func (t *Tracker) Record(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(e)
+}
+
+// record adds the event, caller holds mu
+func (t *Tracker) record(e Event) {
+	if len(t.events) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	t.events[t.next] = e
+	t.next = (t.next + 1) % len(t.events)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Events returns the recorded events, newest first
+func (t *Tracker) Events() []Event {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := t.next
+	if t.full {
+		n = len(t.events)
+	}
+	events := make([]Event, 0, n)
+	for i := 1; i <= n; i++ {
+		events = append(events, t.events[(t.next-i+len(t.events))%len(t.events)])
+	}
+	return events
+}
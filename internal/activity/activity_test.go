@@ -0,0 +1,30 @@
+package activity
+
+import "testing"
+
+func TestUpdateTitleMerges(t *testing.T) {
+	tr := NewTracker(10)
+	tr.UpdateTitle(Title{PageID: "p1", ImdbID: "tt1", Title: "Movie", Status: "Queued", RadarrID: 4})
+	first, _ := tr.Title("p1")
+	tr.UpdateTitle(Title{PageID: "p1", Status: "Queued"})
+	same, _ := tr.Title("p1")
+	if !same.LastTransition.Equal(first.LastTransition) {
+		t.Fatal("LastTransition moved without a status change")
+	}
+	tr.UpdateTitle(Title{PageID: "p1", Status: "Downloaded"})
+	got, _ := tr.Title("p1")
+	if got.Status != "Downloaded" || got.RadarrID != 4 || got.Title != "Movie" {
+		t.Fatalf("unexpected merge result %+v", got)
+	}
+}
+
+func TestEventsRingBuffer(t *testing.T) {
+	tr := NewTracker(3)
+	for _, d := range []string{"a", "b", "c", "d"} {
+		tr.Record(Event{Detail: d})
+	}
+	events := tr.Events()
+	if len(events) != 3 || events[0].Detail != "d" || events[2].Detail != "b" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
@@ -1,12 +1,29 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notify"
 	"github.com/flxp49/notion-watchlistarr/internal/notion"
 	"github.com/flxp49/notion-watchlistarr/internal/radarr"
 	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+	"github.com/flxp49/notion-watchlistarr/internal/store"
+)
+
+// Background job names
+const (
+	JobRadarrPoll = "radarr-poll"
+	JobRadarrSync = "radarr-sync"
+	JobSonarrPoll = "sonarr-poll"
+	JobSonarrSync = "sonarr-sync"
 )
 
 type App struct {
@@ -17,166 +34,283 @@ type App struct {
 	SyncInterval time.Duration
 	RadarrInit   bool
 	SonarrInit   bool
+	Webhook      WebhookSettings
+	Tracker      *activity.Tracker
+	// cron expression, time windows or interval, PollInterval/SyncInterval are used when empty. See ParseSchedule
+	PollSchedule string
+	SyncSchedule string
+	Scheduler    *Scheduler
+	// the clients log their writes instead of performing them, see LogPlannedAction
+	DryRun bool
+	// default age (days) of the Queued/Downloading titles reported as stuck, see Report
+	ReportStuckDays int
+	// notion page the reports are published under
+	ReportPageID string
+	// how pages sharing an IMDb ID are updated, DuplicatesUpdateAll || DuplicatesCanonical
+	DuplicatePolicy string
+	// notion DB the upcoming releases are written to, the calendar job is off when empty
+	CalendarDBID string
+	// days ahead (and back, for releases not downloaded yet) the calendar covers
+	CalendarDays int
+	// cron expression, time windows or interval of the calendar job, every 6 hours when empty
+	CalendarSchedule string
+	// receives the title errors, nil sends nothing
+	Notifier *notify.Notifier
+	// held while a poll run (or a direct request) processes titles
+	radarrPollMu sync.Mutex
+	sonarrPollMu sync.Mutex
+	// held while a sync run (timer or on-demand) reconciles titles
+	radarrSyncMu sync.Mutex
+	sonarrSyncMu sync.Mutex
+	// held while a calendar run writes the calendar DB
+	calendarMu sync.Mutex
 }
 
 func NewApp(N *notion.NotionClient, R *radarr.RadarrClient, S *sonarr.SonarrClient, Logger *slog.Logger, PollInterval time.Duration, SyncInterval time.Duration, RadarrInit bool, SonarrInit bool) *App {
 	return &App{
-		RadarrMedia:  NewRadarrMedia(N, R),
-		SonarrMedia:  NewSonarrMedia(N, S),
-		Logger:       Logger,
-		PollInterval: PollInterval,
-		SyncInterval: SyncInterval,
-		RadarrInit:   RadarrInit,
-		SonarrInit:   SonarrInit,
+		RadarrMedia:     NewRadarrMedia(N, R),
+		SonarrMedia:     NewSonarrMedia(N, S),
+		Logger:          Logger,
+		PollInterval:    PollInterval,
+		SyncInterval:    SyncInterval,
+		RadarrInit:      RadarrInit,
+		SonarrInit:      SonarrInit,
+		Tracker:         activity.NewTracker(200),
+		Scheduler:       NewScheduler(0, Logger),
+		ReportStuckDays: 7,
+		DuplicatePolicy: DuplicatesUpdateAll,
+		CalendarDays:    30,
 	}
 }
 
-func (A *App) RunApp() {
-	if A.RadarrInit {
-		go A.RadarrPollDB()
-		go A.RadarrSyncWatchlist()
+// UseStore loads the tracked titles from the store and persists them there
+//
+// In dry run the store is left untouched, planned writes would otherwise be taken for applied ones
+func (A *App) UseStore(st *store.Store) error {
+	if A.DryRun {
+		return nil
 	}
-	if A.SonarrInit {
-		go A.SonarrPollDB()
-		go A.SonarrSyncWatchlist()
+	err := A.Tracker.Persist(st)
+	if err != nil {
+		return errors.Join(errors.New("failed to load tracked titles"), err)
 	}
+	return nil
 }
 
-// Polls DB for titles from watchlist to download
-func (A *App) RadarrPollDB() {
-	for {
-		A.Logger.Info("RadarrPollDB", "Status", "Fetching titles from database")
-		notionPages, err := A.RadarrMedia.PollTitles()
+// schedule parses the spec, or falls back to the fixed interval
+func schedule(spec string, interval time.Duration) (Schedule, error) {
+	if spec == "" {
+		return Every(interval), nil
+	}
+	return ParseSchedule(spec)
+}
+
+// RunApp schedules the poll and sync jobs of each enabled service until ctx is cancelled
+func (A *App) RunApp(ctx context.Context) error {
+	poll, err := schedule(A.PollSchedule, A.PollInterval*time.Second)
+	if err != nil {
+		return errors.Join(errors.New("invalid poll schedule"), err)
+	}
+	syncSchedule, err := schedule(A.SyncSchedule, A.SyncInterval*time.Hour)
+	if err != nil {
+		return errors.Join(errors.New("invalid sync schedule"), err)
+	}
+	if A.RadarrInit {
+		A.Scheduler.Add(JobRadarrPoll, poll, A.RadarrPollDB)
+		A.Scheduler.Add(JobRadarrSync, syncSchedule, func(ctx context.Context) error { _, err := A.RadarrSync(ctx); return err })
+	}
+	if A.SonarrInit {
+		A.Scheduler.Add(JobSonarrPoll, poll, A.SonarrPollDB)
+		A.Scheduler.Add(JobSonarrSync, syncSchedule, func(ctx context.Context) error { _, err := A.SonarrSync(ctx); return err })
+	}
+	if A.CalendarDBID != "" {
+		calendar, err := schedule(A.CalendarSchedule, 6*time.Hour)
 		if err != nil {
-			A.Logger.Error("RadarrPollDB", "Failed to query watchlist DB", err)
-			time.Sleep(5 * time.Second)
-			continue
+			return errors.Join(errors.New("invalid calendar schedule"), err)
 		}
-		A.Logger.Info("RadarrPollDB", "Status", "Fetched titles from DB", "No of titles fetched", len(notionPages.Results))
-		for _, notionPage := range notionPages.Results {
-			if !notionPage.Properties.Download.Checkbox {
-				A.Logger.Warn("RadarrPollDB", "Notion filter fail, fetched", notionPage.Properties)
-				continue
-			}
-			LookupData, LibraryData, err := A.RadarrMedia.ProcessTitles(notionPage)
-			if err != nil {
-				A.Logger.Error("RadarrPollDB", "Failed to process movie in Radarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-				A.RadarrMedia.N.UpdateDownloadStatus("movie", notionPage.Pgid, false, "Error", "", "", "")
-				continue
-			}
-			if len(LibraryData) != 0 {
-				err = A.RadarrMedia.HandleExistingTitle(LibraryData, notionPage)
-				if err != nil {
-					A.Logger.Error("RadarrPollDB", "Failed to handle existing movie in Radarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-					A.RadarrMedia.N.UpdateDownloadStatus("movie", notionPage.Pgid, false, "Error", "", "", "")
-					continue
-				}
-				continue
-			}
-			err = A.RadarrMedia.AddTitle(LookupData, notionPage)
-			if err != nil {
-				A.Logger.Error("RadarrPollDB", "Failed to add movie to Radarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-				A.RadarrMedia.N.UpdateDownloadStatus("movie", notionPage.Pgid, false, "Error", "", "", "")
+		A.Scheduler.Add(JobCalendar, calendar, func(ctx context.Context) error { _, err := A.CalendarSync(ctx); return err })
+	}
+	A.Scheduler.Start(ctx)
+	return nil
+}
+
+// Wait waits for the running jobs to stop after the RunApp context is cancelled, returns false on timeout
+func (A *App) Wait(timeout time.Duration) bool {
+	return A.Scheduler.Wait(timeout)
+}
+
+// Trigger runs the job now instead of waiting for its next run, returns false if the job is unknown or disabled
+func (A *App) Trigger(job string) bool {
+	return A.Scheduler.Trigger(job)
+}
+
+// NextRuns returns the next run time of each job
+func (A *App) NextRuns() map[string]time.Time {
+	return A.Scheduler.NextRuns()
+}
+
+// RetryTitle ticks Download on the page again and triggers the poll job so the title is picked up right away
+func (A *App) RetryTitle(pageID string) error {
+	title, exists := A.Tracker.Title(pageID)
+	if !exists {
+		return errors.New("title not tracked")
+	}
+	job, service, enabled := JobRadarrPoll, "radarr", A.RadarrInit
+	if title.MediaType == constant.MediaTypeTV {
+		job, service, enabled = JobSonarrPoll, "sonarr", A.SonarrInit
+	}
+	if !enabled {
+		return fmt.Errorf("%s is not enabled", service)
+	}
+	err := A.RadarrMedia.N.SetDownload(pageID, true)
+	if err != nil {
+		return errors.Join(errors.New("failed to tick download in watchlist"), err)
+	}
+	A.Tracker.Record(activity.Event{Source: activity.SourceManual, Service: service, Type: "retry", ImdbID: title.ImdbID, Title: title.Title})
+	A.Trigger(job)
+	return nil
+}
+
+// pageImdbID returns the IMDb ID of the watchlist page, empty if not set
+func pageImdbID(notionPage notion.Result) string {
+	if len(notionPage.Properties.Imdbid.Rich_text) == 0 {
+		return ""
+	}
+	return notionPage.Properties.Imdbid.Rich_text[0].Plain_text
+}
+
+// recordError marks the title as errored and adds the failure to the activity log, the Error notification is sent when the title wasn't errored yet
+func (A *App) recordError(source string, service string, mediaType string, pageID string, imdbID string, err error) {
+	if pageID != "" {
+		title, _ := A.Tracker.Title(pageID)
+		if title.Status != constant.MediaStatusError {
+			name := title.Title
+			if name == "" {
+				name = imdbID
 			}
+			A.Notifier.Notify(notify.Message{Event: notify.EventError, Title: name, MediaType: mediaType, ImdbID: imdbID, URL: notion.PageURL(pageID), Error: err.Error()})
 		}
-		time.Sleep(A.PollInterval * time.Second)
+		A.Tracker.UpdateTitle(activity.Title{PageID: pageID, ImdbID: imdbID, MediaType: mediaType, Status: constant.MediaStatusError})
 	}
+	A.Tracker.Record(activity.Event{Source: source, Service: service, Type: "error", ImdbID: imdbID, Error: err.Error()})
 }
-func (A *App) SonarrPollDB() {
-	for {
-		A.Logger.Info("SonarrPollDB", "Status", "Fetching titles from database")
-		notionPages, err := A.SonarrMedia.PollTitles()
+
+// RadarrProcessPage adds the movie of a watchlist page to Radarr (or handles it if it is already in the library) and returns the status set
+func (A *App) RadarrProcessPage(notionPage notion.Result) (string, error) {
+	LookupData, LibraryData, err := A.RadarrMedia.ProcessTitles(notionPage)
+	if err != nil {
+		return "", errors.Join(errors.New("failed to process movie in radarr"), err)
+	}
+	title := activity.Title{PageID: notionPage.Pgid, ImdbID: pageImdbID(notionPage), Title: LookupData.Title, MediaType: constant.MediaTypeMovie, TmdbID: LookupData.TmdbID}
+	if len(LibraryData) != 0 {
+		title.RadarrID = LibraryData[0].ID
+		title.Status, err = A.RadarrMedia.HandleExistingTitle(LibraryData, notionPage)
 		if err != nil {
-			A.Logger.Error("SonarrPollDB", "Failed to query watchlist DB", err)
-			time.Sleep(5 * time.Second)
-			continue
+			return "", errors.Join(errors.New("failed to handle existing movie in radarr"), err)
 		}
-		A.Logger.Info("SonarrPollDB", "Status", "Fetched titles from DB", "No of titles fetched", len(notionPages.Results))
-		for _, notionPage := range notionPages.Results {
-			if !notionPage.Properties.Download.Checkbox {
-				A.Logger.Warn("SonarrPollDB", "Notion filter fail, fetched", notionPage.Properties)
-				continue
-			}
-			LookupData, LibraryData, err := A.SonarrMedia.ProcessTitles(notionPage)
-			if err != nil {
-				A.Logger.Error("SonarrPollDB", "Failed to process movie in Sonarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-				A.SonarrMedia.N.UpdateDownloadStatus("series", notionPage.Pgid, false, "Error", "", "", "")
-				continue
-			}
-			if len(LibraryData) != 0 {
-				err = A.SonarrMedia.HandleExistingTitle(LibraryData, notionPage)
-				if err != nil {
-					A.Logger.Error("SonarrPollDB", "Failed to handle existing movie in Sonarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-					A.SonarrMedia.N.UpdateDownloadStatus("series", notionPage.Pgid, false, "Error", "", "", "")
-					continue
-				}
-				continue
-			}
-			err = A.SonarrMedia.AddTitle(LookupData, notionPage)
-			if err != nil {
-				A.Logger.Error("SonarrPollDB", "Failed to add movie to Sonarr", notionPage.Properties.Imdbid.Rich_text[0].Plain_text, "Error", err)
-				A.SonarrMedia.N.UpdateDownloadStatus("series", notionPage.Pgid, false, "Error", "", "", "")
-			}
+	} else {
+		title.Status, err = A.RadarrMedia.AddTitle(LookupData, notionPage)
+		if err != nil {
+			return "", err
 		}
-		time.Sleep(A.PollInterval * time.Second)
 	}
+	A.Tracker.UpdateTitle(title)
+	return title.Status, nil
 }
 
-// Sync Radarr library with watchlist
-func (A *App) RadarrSyncWatchlist() {
-	for {
-		A.Logger.Info("RadarrSyncWatchlist", "Status", "Fetching titles from Radarr")
-		radarrLibrary, err := A.RadarrMedia.FetchRadarrLibrary()
+// SonarrProcessPage adds the series of a watchlist page to Sonarr (or handles it if it is already in the library) and returns the status set
+func (A *App) SonarrProcessPage(notionPage notion.Result) (string, error) {
+	LookupData, LibraryData, err := A.SonarrMedia.ProcessTitles(notionPage)
+	if err != nil {
+		return "", errors.Join(errors.New("failed to process series in sonarr"), err)
+	}
+	title := activity.Title{PageID: notionPage.Pgid, ImdbID: pageImdbID(notionPage), Title: LookupData.Title, MediaType: constant.MediaTypeTV, TvdbID: LookupData.TvdbID}
+	if len(LibraryData) != 0 {
+		title.SonarrID = LibraryData[0].ID
+		title.Status, err = A.SonarrMedia.HandleExistingTitle(LibraryData, notionPage)
 		if err != nil {
-			time.Sleep(5 * time.Second)
-			continue
+			return "", errors.Join(errors.New("failed to handle existing series in sonarr"), err)
 		}
-		A.Logger.Info("RadarrSyncWatchlist", "Status", "Fetched titles from DB", "No of titles fetched", len(radarrLibrary))
-		for _, radarrMovie := range radarrLibrary {
-			watchlistMovie, err := A.RadarrMedia.N.QueryDBImdb(radarrMovie.ImdbID)
-			if err != nil {
-				A.Logger.Error("RadarrSyncWatchlist", "Failed to query movie from notion watchlist", err)
-				continue
-			}
-			if len(watchlistMovie.Results) == 0 {
-				continue
-			}
-			err = A.RadarrMedia.ProcessLibraryTitle(watchlistMovie, radarrMovie)
-			if err != nil {
-				A.Logger.Error("RadarrSyncWatchlist", "Failed to process movie", err)
-				continue
-			}
+	} else {
+		err = A.SonarrMedia.AddTitle(LookupData, notionPage)
+		if err != nil {
+			return "", err
 		}
-		A.Logger.Info("RadarrSyncWatchlist", "Status", "Finished")
-		time.Sleep(A.SyncInterval * time.Hour)
+		title.Status = constant.MediaStatusQueued
 	}
+	A.Tracker.UpdateTitle(title)
+	return title.Status, nil
 }
 
-func (A *App) SonarrSyncWatchlist() {
-	for {
-		A.Logger.Info("SonarrSyncWatchlist", "Status", "Fetching titles from Sonarr")
-		sonarrLibrary, err := A.SonarrMedia.FetchSonarrLibrary()
+// Polls DB for titles from watchlist to download
+// stops before the next title once ctx is cancelled
+func (A *App) RadarrPollDB(ctx context.Context) error {
+	A.Logger.Info("RadarrPollDB", "Status", "Fetching titles from database")
+	start := time.Now()
+	A.radarrPollMu.Lock()
+	defer A.radarrPollMu.Unlock()
+	notionPages, err := A.RadarrMedia.PollTitles()
+	if err != nil {
+		A.Logger.Error("RadarrPollDB", "Failed to query watchlist DB", err)
+		A.recordError(activity.SourcePoll, "radarr", constant.MediaTypeMovie, "", "", err)
+		return err
+	}
+	A.Logger.Info("RadarrPollDB", "Status", "Fetched titles from DB", "No of titles fetched", len(notionPages.Results))
+	for _, notionPage := range notionPages.Results {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !notionPage.Properties.Download.Checkbox {
+			A.Logger.Warn("RadarrPollDB", "Notion filter fail, fetched", notionPage.Properties)
+			continue
+		}
+		metrics.TitlesProcessed.Inc("radarr", "poll")
+		imdbID := pageImdbID(notionPage)
+		status, err := A.RadarrProcessPage(notionPage)
 		if err != nil {
-			time.Sleep(5 * time.Second)
+			A.Logger.Error("RadarrPollDB", "Failed to process movie", imdbID, "Error", err)
+			A.RadarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusError, "", "", "")
+			A.recordError(activity.SourcePoll, "radarr", constant.MediaTypeMovie, notionPage.Pgid, imdbID, err)
 			continue
 		}
-		A.Logger.Info("SonarrSyncWatchlist", "Status", "Fetched titles from DB", "No of titles fetched", len(sonarrLibrary))
-		for _, sonarrSeries := range sonarrLibrary {
-			watchlistSeries, err := A.SonarrMedia.N.QueryDBImdb(sonarrSeries.ImdbID)
-			if err != nil {
-				A.Logger.Error("SonarrSyncWatchlist", "Failed to query series from notion watchlist", err)
-				continue
-			}
-			if len(watchlistSeries.Results) == 0 {
-				continue
-			}
-			err = A.SonarrMedia.ProcessLibraryTitle(watchlistSeries, sonarrSeries)
-			if err != nil {
-				A.Logger.Error("SonarrSyncWatchlist", "Failed to process series", err)
-				continue
-			}
+		A.Tracker.Record(activity.Event{Source: activity.SourcePoll, Service: "radarr", Type: "processed", ImdbID: imdbID, Status: status})
+	}
+	metrics.PollDuration.Observe(time.Since(start).Seconds(), "radarr")
+	return nil
+}
+
+// stops before the next title once ctx is cancelled
+func (A *App) SonarrPollDB(ctx context.Context) error {
+	A.Logger.Info("SonarrPollDB", "Status", "Fetching titles from database")
+	start := time.Now()
+	A.sonarrPollMu.Lock()
+	defer A.sonarrPollMu.Unlock()
+	notionPages, err := A.SonarrMedia.PollTitles()
+	if err != nil {
+		A.Logger.Error("SonarrPollDB", "Failed to query watchlist DB", err)
+		A.recordError(activity.SourcePoll, "sonarr", constant.MediaTypeTV, "", "", err)
+		return err
+	}
+	A.Logger.Info("SonarrPollDB", "Status", "Fetched titles from DB", "No of titles fetched", len(notionPages.Results))
+	for _, notionPage := range notionPages.Results {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !notionPage.Properties.Download.Checkbox {
+			A.Logger.Warn("SonarrPollDB", "Notion filter fail, fetched", notionPage.Properties)
+			continue
+		}
+		metrics.TitlesProcessed.Inc("sonarr", "poll")
+		imdbID := pageImdbID(notionPage)
+		status, err := A.SonarrProcessPage(notionPage)
+		if err != nil {
+			A.Logger.Error("SonarrPollDB", "Failed to process series", imdbID, "Error", err)
+			A.SonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusError, "", "", "")
+			A.recordError(activity.SourcePoll, "sonarr", constant.MediaTypeTV, notionPage.Pgid, imdbID, err)
+			continue
 		}
-		A.Logger.Info("SonarrSyncWatchlist", "Status", "Finished")
-		time.Sleep(A.SyncInterval * time.Hour)
+		A.Tracker.Record(activity.Event{Source: activity.SourcePoll, Service: "sonarr", Type: "processed", ImdbID: imdbID, Status: status})
 	}
+	metrics.PollDuration.Observe(time.Since(start).Seconds(), "sonarr")
+	return nil
 }
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// JobCalendar is the job keeping the calendar DB up to date
+const JobCalendar = "calendar"
+
+// CalendarSummary is the result of a calendar run
+//
+// Entries - upcoming releases of the watchlist titles, Pruned - rows archived (released and downloaded, or no longer upcoming)
+type CalendarSummary struct {
+	Entries   int      `json:"entries"`
+	Created   int      `json:"created"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	Pruned    int      `json:"pruned"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+	Duration  string   `json:"duration"`
+}
+
+func (summary *CalendarSummary) fail(key string, err error) {
+	summary.Failed++
+	summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %s", key, err))
+}
+
+// calendarPlan is what a calendar run writes
+type calendarPlan struct {
+	create    []notion.CalendarEntry
+	update    []notion.CalendarRow
+	prune     []notion.CalendarRow
+	unchanged int
+}
+
+// calendarDay is the day of a Radarr release date, the calendar shows it as an all day event
+func calendarDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// calendarMovieEntries returns the cinema, digital and physical releases of the watchlist movies between from and to.
+// Releases that passed before now are left out once the movie is downloaded
+func calendarMovieEntries(movies []radarr.GetMovieResponse, watchlist map[string][]notion.Result, now time.Time, from time.Time, to time.Time) []notion.CalendarEntry {
+	var entries []notion.CalendarEntry
+	for _, movie := range movies {
+		pages, found := watchlist[movie.ImdbID]
+		if !found {
+			continue
+		}
+		for _, release := range []struct {
+			kind  string
+			label string
+			date  time.Time
+		}{
+			{"cinema", "In Cinemas", movie.InCinemas},
+			{"digital", "Digital", movie.DigitalRelease},
+			{"physical", "Physical", movie.PhysicalRelease},
+		} {
+			if release.date.IsZero() || release.date.Before(from) || release.date.After(to) || (movie.HasFile && release.date.Before(now)) {
+				continue
+			}
+			entries = append(entries, notion.CalendarEntry{
+				Key:    fmt.Sprintf("movie:%d:%s", movie.TmdbID, release.kind),
+				Title:  fmt.Sprintf("%s (%s)", movie.Title, release.label),
+				Date:   calendarDay(release.date),
+				AllDay: true,
+				Type:   constant.MediaTypeMovie,
+				PageID: CanonicalPage(pages).Pgid,
+			})
+		}
+	}
+	return entries
+}
+
+// calendarEpisodeEntries returns the episodes of the watchlist series airing between from and to.
+// Episodes that aired before now are left out once downloaded
+func calendarEpisodeEntries(episodes []sonarr.CalendarEpisode, watchlist map[string][]notion.Result, now time.Time, from time.Time, to time.Time) []notion.CalendarEntry {
+	var entries []notion.CalendarEntry
+	for _, episode := range episodes {
+		pages, found := watchlist[episode.Series.ImdbID]
+		if !found || episode.AirDateUtc.IsZero() || episode.AirDateUtc.Before(from) || episode.AirDateUtc.After(to) || (episode.HasFile && episode.AirDateUtc.Before(now)) {
+			continue
+		}
+		title := episode.Series.Title
+		if episode.Title != "" && episode.Title != "TBA" {
+			title = fmt.Sprintf("%s: %s", episode.Series.Title, episode.Title)
+		}
+		entries = append(entries, notion.CalendarEntry{
+			Key:     "episode:" + strconv.Itoa(episode.ID),
+			Title:   title,
+			Date:    episode.AirDateUtc.UTC(),
+			Episode: fmt.Sprintf("S%02dE%02d", episode.SeasonNumber, episode.EpisodeNumber),
+			Type:    constant.MediaTypeTV,
+			PageID:  CanonicalPage(pages).Pgid,
+		})
+	}
+	return entries
+}
+
+// planCalendar matches the rows with the entries by key. Rows without an entry are pruned unless they are dated after to
+// or have no key (ex: added by hand), as are the extra rows of a repeated key
+func planCalendar(rows []notion.CalendarRow, entries []notion.CalendarEntry, to time.Time) calendarPlan {
+	var plan calendarPlan
+	wanted := make(map[string]notion.CalendarEntry, len(entries))
+	for _, entry := range entries {
+		wanted[entry.Key] = entry
+	}
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		entry, found := wanted[row.Key]
+		switch {
+		case found && !seen[row.Key]:
+			seen[row.Key] = true
+			if row.CalendarEntry.Equal(entry) {
+				plan.unchanged++
+				continue
+			}
+			plan.update = append(plan.update, notion.CalendarRow{ID: row.ID, CalendarEntry: entry})
+		case found || (row.Key != "" && !row.Date.After(to)):
+			plan.prune = append(plan.prune, row)
+		}
+	}
+	for _, entry := range entries {
+		if !seen[entry.Key] {
+			seen[entry.Key] = true
+			plan.create = append(plan.create, entry)
+		}
+	}
+	sort.Slice(plan.create, func(i, j int) bool { return plan.create[i].Date.Before(plan.create[j].Date) })
+	return plan
+}
+
+// calendarEntries fetches the upcoming releases of the enabled services, limited to the watchlist titles
+func (A *App) calendarEntries(now time.Time, from time.Time, to time.Time) ([]notion.CalendarEntry, error) {
+	var entries []notion.CalendarEntry
+	if A.RadarrInit {
+		watchlist, err := A.RadarrMedia.N.QueryWatchlist(constant.MediaTypeMovie)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to fetch movies from notion watchlist"), err)
+		}
+		movies, err := A.RadarrMedia.R.GetCalendar(from, to)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to fetch radarr calendar"), err)
+		}
+		entries = append(entries, calendarMovieEntries(movies, watchlistByImdb(watchlist), now, from, to)...)
+	}
+	if A.SonarrInit {
+		watchlist, err := A.SonarrMedia.N.QueryWatchlist(constant.MediaTypeTV)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to fetch series from notion watchlist"), err)
+		}
+		episodes, err := A.SonarrMedia.S.GetCalendar(from, to)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to fetch sonarr calendar"), err)
+		}
+		entries = append(entries, calendarEpisodeEntries(episodes, watchlistByImdb(watchlist), now, from, to)...)
+	}
+	return entries, nil
+}
+
+// Releases returns the releases of the watchlist titles between from and to, downloaded ones included
+func (A *App) Releases(from time.Time, to time.Time) ([]notion.CalendarEntry, error) {
+	entries, err := A.calendarEntries(from, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+	return entries, nil
+}
+
+// CalendarSync upserts the releases of the watchlist titles in the next CalendarDays days into the calendar DB, runs never overlap.
+// Past releases stay (for up to CalendarDays) until they are downloaded
+func (A *App) CalendarSync(ctx context.Context) (CalendarSummary, error) {
+	A.calendarMu.Lock()
+	defer A.calendarMu.Unlock()
+	start := time.Now()
+	summary := CalendarSummary{}
+	from, to := start.AddDate(0, 0, -A.CalendarDays), start.AddDate(0, 0, A.CalendarDays)
+	entries, err := A.calendarEntries(start, from, to)
+	if err != nil {
+		A.recordError(activity.SourceSync, "calendar", "", "", "", err)
+		return summary, err
+	}
+	summary.Entries = len(entries)
+	N := A.RadarrMedia.N
+	rows, err := N.QueryCalendar(A.CalendarDBID)
+	if err != nil {
+		return summary, errors.Join(errors.New("failed to fetch calendar rows"), err)
+	}
+	titleProp, err := N.CalendarTitleProperty(A.CalendarDBID)
+	if err != nil {
+		return summary, errors.Join(errors.New("failed to fetch calendar DB schema"), err)
+	}
+	plan := planCalendar(rows, entries, to)
+	summary.Unchanged = plan.unchanged
+	for _, entry := range plan.create {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		err := N.CreateCalendarRow(A.CalendarDBID, titleProp, entry)
+		if err != nil {
+			summary.fail(entry.Key, err)
+			continue
+		}
+		summary.Created++
+	}
+	for _, row := range plan.update {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		err := N.UpdateCalendarRow(row.ID, titleProp, row.CalendarEntry)
+		if err != nil {
+			summary.fail(row.Key, err)
+			continue
+		}
+		summary.Updated++
+	}
+	for _, row := range plan.prune {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		err := N.DeleteCalendarRow(row.ID)
+		if err != nil {
+			summary.fail(row.Key, err)
+			continue
+		}
+		summary.Pruned++
+	}
+	A.Logger.Info("CalendarSync", "Status", "Finished", "Entries", summary.Entries, "Created", summary.Created, "Updated", summary.Updated, "Pruned", summary.Pruned, "Failed", summary.Failed)
+	A.Tracker.Record(activity.Event{Source: activity.SourceSync, Service: "calendar", Type: "finished", Detail: fmt.Sprintf("%d releases, %d created, %d updated, %d pruned", summary.Entries, summary.Created, summary.Updated, summary.Pruned)})
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+	return summary, nil
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+func TestCalendarEntries(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	from, to := now.AddDate(0, 0, -30), now.AddDate(0, 0, 30)
+	watchlist := watchlistByImdb([]notion.Result{watchlistPage(t, "p1", "tt1", false, "", "", ""), watchlistPage(t, "p2", "tt2", false, "", "", "")})
+
+	movies := []radarr.GetMovieResponse{
+		{Title: "Dune", ImdbID: "tt1", TmdbID: 1, InCinemas: now.AddDate(0, 0, -5), DigitalRelease: now.AddDate(0, 0, 3), PhysicalRelease: now.AddDate(0, 0, 60)},
+		{Title: "Not watched", ImdbID: "tt9", TmdbID: 9, DigitalRelease: now.AddDate(0, 0, 3)},
+	}
+	entries := calendarMovieEntries(movies, watchlist, now, from, to)
+	if len(entries) != 2 || entries[0].Key != "movie:1:cinema" || entries[1].Title != "Dune (Digital)" || entries[1].PageID != "p1" || !entries[1].AllDay {
+		t.Fatalf("expected the cinema and digital releases, got %+v", entries)
+	}
+	// downloaded, the passed cinema release is dropped
+	movies[0].HasFile = true
+	if entries := calendarMovieEntries(movies, watchlist, now, from, to); len(entries) != 1 || entries[0].Key != "movie:1:digital" {
+		t.Fatalf("expected the digital release, got %+v", entries)
+	}
+
+	episode := sonarr.CalendarEpisode{ID: 7, SeasonNumber: 2, EpisodeNumber: 1, Title: "Hello", AirDateUtc: now.Add(time.Hour)}
+	episode.Series.Title = "Severance"
+	episode.Series.ImdbID = "tt2"
+	entries = calendarEpisodeEntries([]sonarr.CalendarEpisode{episode}, watchlist, now, from, to)
+	if len(entries) != 1 || entries[0].Key != "episode:7" || entries[0].Title != "Severance: Hello" || entries[0].Episode != "S02E01" || entries[0].PageID != "p2" {
+		t.Fatalf("unexpected episode entries %+v", entries)
+	}
+}
+
+func TestPlanCalendar(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	to := now.AddDate(0, 0, 30)
+	entry := func(key string, date time.Time) notion.CalendarEntry {
+		return notion.CalendarEntry{Key: key, Title: key, Date: date, Type: "Movie", PageID: "p1"}
+	}
+	moved := entry("moved", now.AddDate(0, 0, 2))
+	entries := []notion.CalendarEntry{entry("same", now), moved, entry("new", now.AddDate(0, 0, 1))}
+	rows := []notion.CalendarRow{
+		{ID: "r1", CalendarEntry: entry("same", now)},
+		{ID: "r2", CalendarEntry: entry("moved", now)},
+		{ID: "r3", CalendarEntry: entry("same", now)},
+		{ID: "r4", CalendarEntry: entry("downloaded", now.AddDate(0, 0, -1))},
+		{ID: "r5", CalendarEntry: entry("", now)},
+		{ID: "r6", CalendarEntry: entry("later", to.AddDate(0, 0, 1))},
+	}
+	plan := planCalendar(rows, entries, to)
+	if plan.unchanged != 1 {
+		t.Fatalf("expected 1 unchanged row, got %d", plan.unchanged)
+	}
+	if len(plan.update) != 1 || plan.update[0].ID != "r2" || !plan.update[0].Date.Equal(moved.Date) {
+		t.Fatalf("expected r2 to be moved, got %+v", plan.update)
+	}
+	if len(plan.create) != 1 || plan.create[0].Key != "new" {
+		t.Fatalf("expected the new entry to be created, got %+v", plan.create)
+	}
+	// the repeated key and the passed release, the row without key and the one past the window are kept
+	if len(plan.prune) != 2 || plan.prune[0].ID != "r3" || plan.prune[1].ID != "r4" {
+		t.Fatalf("expected r3 and r4 to be pruned, got %+v", plan.prune)
+	}
+}
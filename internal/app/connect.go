@@ -0,0 +1,284 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// Name of the Connect entry managed by the app in Radarr/Sonarr
+const WebhookConnectionName = "Notion Watchlistarr"
+
+// *arr masks secret fields in API responses, a masked value can't be compared
+const maskedField = "********"
+
+// WebhookSettings describes the Connect entry the *arr should have
+//
+// PublicURL - base URL Radarr/Sonarr use to reach the app. Ex: http://notionwatchlistarr:7879
+type WebhookSettings struct {
+	PublicURL string
+	Username  string
+	Password  string
+	Token     string
+}
+
+func (w WebhookSettings) Enabled() bool {
+	return w.PublicURL != ""
+}
+
+// url builds the webhook url for the given endpoint, the token (if any) is passed as a query param
+func (w WebhookSettings) url(endpoint string) string {
+	u := strings.TrimRight(w.PublicURL, "/") + "/" + endpoint
+	if w.Token != "" {
+		u += "?token=" + url.QueryEscape(w.Token)
+	}
+	return u
+}
+
+type webhookField struct {
+	name  string
+	value interface{}
+}
+
+func (w WebhookSettings) fields(endpoint string) []webhookField {
+	return []webhookField{
+		{"url", w.url(endpoint)},
+		{"method", 1}, // POST
+		{"username", w.Username},
+		{"password", w.Password},
+	}
+}
+
+// fieldDrift compares the configured value of a Connect field with the desired one
+func fieldDrift(name string, current interface{}, desired interface{}) string {
+	c := fmt.Sprint(current)
+	if current == nil {
+		c = ""
+	}
+	d := fmt.Sprint(desired)
+	if c == d || c == maskedField {
+		return ""
+	}
+	if name == "password" {
+		return "password differs"
+	}
+	return fmt.Sprintf("%s is %q, expected %q", name, c, d)
+}
+
+func flagDrift(name string, current bool, desired bool) string {
+	if current == desired {
+		return ""
+	}
+	return fmt.Sprintf("%s is %t, expected %t", name, current, desired)
+}
+
+func collectDrift(drift ...string) []string {
+	var d []string
+	for _, v := range drift {
+		if v != "" {
+			d = append(d, v)
+		}
+	}
+	return d
+}
+
+func (radarrMedia RadarrMedia) desiredWebhook(settings WebhookSettings, current radarr.Notification) radarr.Notification {
+	n := current
+	n.Name = WebhookConnectionName
+	n.Implementation = "Webhook"
+	n.ConfigContract = "WebhookSettings"
+	if n.Tags == nil {
+		n.Tags = []int{}
+	}
+	for _, f := range settings.fields("radarr") {
+		found := false
+		for i := range n.Fields {
+			if n.Fields[i].Name == f.name {
+				n.Fields[i].Value = f.value
+				found = true
+			}
+		}
+		if !found {
+			n.Fields = append(n.Fields, radarr.NotificationField{Name: f.name, Value: f.value})
+		}
+	}
+	n.OnGrab = true
+	n.OnDownload = true
+	n.OnUpgrade = true
+	n.OnMovieAdded = true
+	n.OnMovieDelete = true
+	n.OnMovieFileDelete = true
+	n.OnRename = true
+	n.OnHealthIssue = true
+	n.OnHealthRestored = true
+	n.OnManualInteractionRequired = true
+	return n
+}
+
+func (radarrMedia RadarrMedia) webhookDrift(settings WebhookSettings, current radarr.Notification) []string {
+	var drift []string
+	for _, f := range settings.fields("radarr") {
+		drift = append(drift, fieldDrift(f.name, current.Field(f.name), f.value))
+	}
+	drift = append(drift,
+		flagDrift("onGrab", current.OnGrab, true),
+		flagDrift("onDownload", current.OnDownload, true),
+		flagDrift("onUpgrade", current.OnUpgrade, true),
+		flagDrift("onMovieAdded", current.OnMovieAdded, true),
+		flagDrift("onMovieDelete", current.OnMovieDelete, true),
+		flagDrift("onMovieFileDelete", current.OnMovieFileDelete, true),
+		flagDrift("onRename", current.OnRename, true),
+		flagDrift("onHealthIssue", current.OnHealthIssue, true),
+		flagDrift("onHealthRestored", current.OnHealthRestored, true),
+		flagDrift("onManualInteractionRequired", current.OnManualInteractionRequired, true),
+	)
+	return collectDrift(drift...)
+}
+
+// EnsureWebhook compares the Radarr Connect entry with the desired settings and returns the drift.
+//
+// apply - create/update the entry when it drifted
+func (radarrMedia RadarrMedia) EnsureWebhook(settings WebhookSettings, apply bool) ([]string, error) {
+	notifications, err := radarrMedia.R.GetNotifications()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to fetch radarr connections"), err)
+	}
+	for _, n := range notifications {
+		if n.Name != WebhookConnectionName {
+			continue
+		}
+		if n.Implementation != "Webhook" {
+			return nil, fmt.Errorf("radarr connection %q exists but is not a Webhook", WebhookConnectionName)
+		}
+		drift := radarrMedia.webhookDrift(settings, n)
+		if len(drift) != 0 && apply {
+			err = radarrMedia.R.UpdateNotification(radarrMedia.desiredWebhook(settings, n))
+			if err != nil {
+				return drift, errors.Join(errors.New("failed to update radarr connection"), err)
+			}
+		}
+		return drift, nil
+	}
+	drift := []string{"connection missing"}
+	if apply {
+		err = radarrMedia.R.AddNotification(radarrMedia.desiredWebhook(settings, radarr.Notification{}))
+		if err != nil {
+			return drift, errors.Join(errors.New("failed to create radarr connection"), err)
+		}
+	}
+	return drift, nil
+}
+
+func (sonarrMedia SonarrMedia) desiredWebhook(settings WebhookSettings, current sonarr.Notification) sonarr.Notification {
+	n := current
+	n.Name = WebhookConnectionName
+	n.Implementation = "Webhook"
+	n.ConfigContract = "WebhookSettings"
+	if n.Tags == nil {
+		n.Tags = []int{}
+	}
+	for _, f := range settings.fields("sonarr") {
+		found := false
+		for i := range n.Fields {
+			if n.Fields[i].Name == f.name {
+				n.Fields[i].Value = f.value
+				found = true
+			}
+		}
+		if !found {
+			n.Fields = append(n.Fields, sonarr.NotificationField{Name: f.name, Value: f.value})
+		}
+	}
+	n.OnGrab = true
+	n.OnDownload = true
+	n.OnUpgrade = true
+	n.OnSeriesAdd = true
+	n.OnSeriesDelete = true
+	n.OnEpisodeFileDelete = true
+	n.OnRename = true
+	n.OnHealthIssue = true
+	n.OnHealthRestored = true
+	n.OnManualInteractionRequired = true
+	return n
+}
+
+func (sonarrMedia SonarrMedia) webhookDrift(settings WebhookSettings, current sonarr.Notification) []string {
+	var drift []string
+	for _, f := range settings.fields("sonarr") {
+		drift = append(drift, fieldDrift(f.name, current.Field(f.name), f.value))
+	}
+	drift = append(drift,
+		flagDrift("onGrab", current.OnGrab, true),
+		flagDrift("onDownload", current.OnDownload, true),
+		flagDrift("onUpgrade", current.OnUpgrade, true),
+		flagDrift("onSeriesAdd", current.OnSeriesAdd, true),
+		flagDrift("onSeriesDelete", current.OnSeriesDelete, true),
+		flagDrift("onEpisodeFileDelete", current.OnEpisodeFileDelete, true),
+		flagDrift("onRename", current.OnRename, true),
+		flagDrift("onHealthIssue", current.OnHealthIssue, true),
+		flagDrift("onHealthRestored", current.OnHealthRestored, true),
+		flagDrift("onManualInteractionRequired", current.OnManualInteractionRequired, true),
+	)
+	return collectDrift(drift...)
+}
+
+// EnsureWebhook compares the Sonarr Connect entry with the desired settings and returns the drift.
+//
+// apply - create/update the entry when it drifted
+func (sonarrMedia SonarrMedia) EnsureWebhook(settings WebhookSettings, apply bool) ([]string, error) {
+	notifications, err := sonarrMedia.S.GetNotifications()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to fetch sonarr connections"), err)
+	}
+	for _, n := range notifications {
+		if n.Name != WebhookConnectionName {
+			continue
+		}
+		if n.Implementation != "Webhook" {
+			return nil, fmt.Errorf("sonarr connection %q exists but is not a Webhook", WebhookConnectionName)
+		}
+		drift := sonarrMedia.webhookDrift(settings, n)
+		if len(drift) != 0 && apply {
+			err = sonarrMedia.S.UpdateNotification(sonarrMedia.desiredWebhook(settings, n))
+			if err != nil {
+				return drift, errors.Join(errors.New("failed to update sonarr connection"), err)
+			}
+		}
+		return drift, nil
+	}
+	drift := []string{"connection missing"}
+	if apply {
+		err = sonarrMedia.S.AddNotification(sonarrMedia.desiredWebhook(settings, sonarr.Notification{}))
+		if err != nil {
+			return drift, errors.Join(errors.New("failed to create sonarr connection"), err)
+		}
+	}
+	return drift, nil
+}
+
+// RegisterWebhooks creates or updates the Connect entry on each enabled *arr
+func (A *App) RegisterWebhooks() {
+	if !A.Webhook.Enabled() {
+		return
+	}
+	if A.RadarrInit {
+		drift, err := A.RadarrMedia.EnsureWebhook(A.Webhook, true)
+		if err != nil {
+			A.Logger.Error("RegisterWebhooks", "Failed to register radarr webhook", err)
+		} else if len(drift) != 0 {
+			A.Logger.Info("RegisterWebhooks", "Status", "Radarr webhook registered", "changes", drift)
+		}
+	}
+	if A.SonarrInit {
+		drift, err := A.SonarrMedia.EnsureWebhook(A.Webhook, true)
+		if err != nil {
+			A.Logger.Error("RegisterWebhooks", "Failed to register sonarr webhook", err)
+		} else if len(drift) != 0 {
+			A.Logger.Info("RegisterWebhooks", "Status", "Sonarr webhook registered", "changes", drift)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// fakeArr serves the Connect entries of a Radarr/Sonarr, secrets are masked in the responses like the *arr do
+type fakeArr struct {
+	mu            sync.Mutex
+	notifications []map[string]interface{}
+	writes        []string
+}
+
+func newFakeArr(t *testing.T) (*fakeArr, string) {
+	f := &fakeArr{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v3/notification" {
+			masked := make([]map[string]interface{}, 0, len(f.notifications))
+			for _, n := range f.notifications {
+				data, _ := json.Marshal(n)
+				var copied map[string]interface{}
+				json.Unmarshal(data, &copied)
+				for _, field := range copied["fields"].([]interface{}) {
+					field := field.(map[string]interface{})
+					if field["name"] == "password" && field["value"] != nil && field["value"] != "" {
+						field["value"] = maskedField
+					}
+				}
+				masked = append(masked, copied)
+			}
+			json.NewEncoder(w).Encode(masked)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var n map[string]interface{}
+		if err := json.Unmarshal(body, &n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/notification":
+			n["id"] = float64(len(f.notifications) + 1)
+			f.notifications = append(f.notifications, n)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/api/v3/notification/"):
+			id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/v3/notification/"))
+			if id < 1 || id > len(f.notifications) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// a masked password sent back keeps the stored one
+			for _, field := range n["fields"].([]interface{}) {
+				field := field.(map[string]interface{})
+				if field["name"] == "password" && field["value"] == maskedField {
+					field["value"] = f.field(id-1, "password")
+				}
+			}
+			f.notifications[id-1] = n
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeArr) field(i int, name string) interface{} {
+	for _, field := range f.notifications[i]["fields"].([]interface{}) {
+		field := field.(map[string]interface{})
+		if field["name"] == name {
+			return field["value"]
+		}
+	}
+	return nil
+}
+
+func (f *fakeArr) state() ([]string, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.writes...), len(f.notifications)
+}
+
+func TestEnsureRadarrWebhook(t *testing.T) {
+	arr, host := newFakeArr(t)
+	radarrMedia := NewRadarrMedia(nil, radarr.InitRadarrClient("key", host))
+	settings := WebhookSettings{PublicURL: "http://watchlistarr:7879/", Username: "user", Password: "secret", Token: "t0ken"}
+
+	// doctor only reports
+	check := (&App{Webhook: settings}).webhookCheck("Radarr webhook", radarrMedia.EnsureWebhook)
+	if check.OK || check.Detail != "drift: connection missing" {
+		t.Fatalf("unexpected check %+v", check)
+	}
+	if writes, _ := arr.state(); len(writes) != 0 {
+		t.Fatalf("the check wrote %v", writes)
+	}
+
+	drift, err := radarrMedia.EnsureWebhook(settings, true)
+	if err != nil || len(drift) != 1 {
+		t.Fatalf("expected the connection to be created, got %v %v", drift, err)
+	}
+	if writes, count := arr.state(); len(writes) != 1 || writes[0] != "POST /api/v3/notification" || count != 1 {
+		t.Fatalf("expected a single create, got %v", writes)
+	}
+	created := arr.notifications[0]
+	if created["name"] != WebhookConnectionName || created["onMovieFileDelete"] != true || arr.field(0, "url") != "http://watchlistarr:7879/radarr?token=t0ken" || arr.field(0, "password") != "secret" {
+		t.Fatalf("unexpected connection %v", created)
+	}
+
+	// nothing to do the second time, the masked password isn't drift
+	drift, err = radarrMedia.EnsureWebhook(settings, true)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v %v", drift, err)
+	}
+	if check := (&App{Webhook: settings}).webhookCheck("Radarr webhook", radarrMedia.EnsureWebhook); !check.OK {
+		t.Fatalf("unexpected check %+v", check)
+	}
+
+	// edited in Radarr: the event is turned off and the token changed on our side
+	arr.mu.Lock()
+	arr.notifications[0]["onGrab"] = false
+	arr.mu.Unlock()
+	settings.Token = "rotated"
+	drift, err = radarrMedia.EnsureWebhook(settings, false)
+	if err != nil || strings.Join(drift, "; ") != `url is "http://watchlistarr:7879/radarr?token=t0ken", expected "http://watchlistarr:7879/radarr?token=rotated"; onGrab is false, expected true` {
+		t.Fatalf("unexpected drift %v %v", drift, err)
+	}
+	_, err = radarrMedia.EnsureWebhook(settings, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes, count := arr.state()
+	if len(writes) != 2 || writes[1] != "PUT /api/v3/notification/1" || count != 1 {
+		t.Fatalf("expected the connection to be updated in place, got %v", writes)
+	}
+	if arr.notifications[0]["onGrab"] != true || arr.field(0, "password") != "secret" {
+		t.Fatalf("unexpected connection %v", arr.notifications[0])
+	}
+	if drift, _ := radarrMedia.EnsureWebhook(settings, true); len(drift) != 0 {
+		t.Fatalf("expected no drift after the update, got %v", drift)
+	}
+	if writes, _ := arr.state(); len(writes) != 2 {
+		t.Fatalf("expected no more writes, got %v", writes)
+	}
+}
+
+func TestEnsureSonarrWebhook(t *testing.T) {
+	arr, host := newFakeArr(t)
+	sonarrMedia := NewSonarrMedia(nil, sonarr.InitSonarrClient("key", host))
+	settings := WebhookSettings{PublicURL: "http://watchlistarr:7879"}
+	for i := 0; i < 2; i++ {
+		_, err := sonarrMedia.EnsureWebhook(settings, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writes, count := arr.state(); len(writes) != 1 || count != 1 || arr.field(0, "url") != "http://watchlistarr:7879/sonarr" || arr.notifications[0]["onSeriesAdd"] != true {
+		t.Fatalf("expected a single create, got %v %v", writes, arr.notifications)
+	}
+
+	// a connection of another kind under our name is left alone
+	arr.mu.Lock()
+	arr.notifications[0]["implementation"] = "Discord"
+	arr.mu.Unlock()
+	if _, err := sonarrMedia.EnsureWebhook(settings, true); err == nil {
+		t.Fatal("expected an error for a non webhook connection")
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+type DoctorCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Doctor runs read-only checks against the configured services
+func (A *App) Doctor() []DoctorCheck {
+	var checks []DoctorCheck
+	if A.RadarrInit {
+		checks = append(checks, A.webhookCheck("Radarr webhook", A.RadarrMedia.EnsureWebhook))
+	}
+	if A.SonarrInit {
+		checks = append(checks, A.webhookCheck("Sonarr webhook", A.SonarrMedia.EnsureWebhook))
+	}
+	if A.RadarrMedia.N.StatusType == notion.StatusTypeStatus {
+		checks = append(checks, statusPropertyCheck(A.RadarrMedia.N))
+	}
+	checks = append(checks, A.duplicatesCheck())
+	return checks
+}
+
+func statusPropertyCheck(N *notion.NotionClient) DoctorCheck {
+	name := "Download Status property"
+	err := N.CheckStatusProperty()
+	if err != nil {
+		return DoctorCheck{Name: name, Detail: err.Error()}
+	}
+	return DoctorCheck{Name: name, OK: true, Detail: "status options in place"}
+}
+
+func (A *App) webhookCheck(name string, ensure func(WebhookSettings, bool) ([]string, error)) DoctorCheck {
+	if !A.Webhook.Enabled() {
+		return DoctorCheck{Name: name, OK: true, Detail: "skipped, PUBLIC_URL not set"}
+	}
+	drift, err := ensure(A.Webhook, false)
+	if err != nil {
+		return DoctorCheck{Name: name, Detail: err.Error()}
+	}
+	if len(drift) != 0 {
+		return DoctorCheck{Name: name, Detail: "drift: " + strings.Join(drift, "; ")}
+	}
+	return DoctorCheck{Name: name, OK: true, Detail: "up to date"}
+}
+
+// WriteDoctorReport prints the checks and reports whether all of them passed
+func WriteDoctorReport(w io.Writer, checks []DoctorCheck) bool {
+	ok := true
+	for _, c := range checks {
+		status := "OK  "
+		if !c.OK {
+			status = "FAIL"
+			ok = false
+		}
+		fmt.Fprintf(w, "[%s] %s: %s\n", status, c.Name, c.Detail)
+	}
+	return ok
+}
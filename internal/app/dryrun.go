@@ -0,0 +1,10 @@
+package app
+
+import "log/slog"
+
+// LogPlannedAction returns the DryRun hook of a Notion/Radarr/Sonarr client, each write is logged with the payload that would have been sent
+func LogPlannedAction(Logger *slog.Logger, service string) func(method string, endpoint string, payload []byte) {
+	return func(method string, endpoint string, payload []byte) {
+		Logger.Warn("DryRun", "Service", service, "Action", method+" "+endpoint, "Payload", string(payload))
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/notion/notiontest"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// TestDryRunOnlyReads runs the writes of every client in dry run, they must be logged and never reach the services
+func TestDryRunOnlyReads(t *testing.T) {
+	var mu sync.Mutex
+	var arrRequests []string
+	arr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrRequests = append(arrRequests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	defer arr.Close()
+	fakeNotion := notiontest.NewServer(t)
+	fakeNotion.AddPage("p1", "tt1160419", nil)
+
+	var logs bytes.Buffer
+	Logger := slog.New(slog.NewTextHandler(&logs, nil))
+	R := radarr.InitRadarrClient("key", arr.URL)
+	R.DryRun = LogPlannedAction(Logger, "radarr")
+	S := sonarr.InitSonarrClient("key", arr.URL)
+	S.DryRun = LogPlannedAction(Logger, "sonarr")
+	N := fakeNotion.Client("db")
+	N.DryRun = LogPlannedAction(Logger, "notion")
+	N.Qpid = map[string]int{"Movie: HD-1080p": 4}
+	N.Rpid = map[string]string{"Movie: /movies": "/movies"}
+
+	for name, write := range map[string]func() error{
+		"AddMovie": func() error {
+			return R.AddMovie(radarr.MovieLookupResponse{Title: "Dune"}, 4, "/movies", true, true, constant.MovieOnly)
+		},
+		"UpdateMovie":        func() error { return R.UpdateMovie(radarr.GetMovieResponse{ID: 10}, 4, true, true, constant.MovieOnly) },
+		"MovieSearchCommand": func() error { return R.MovieSearchCommand(10) },
+		"Radarr AddNotification": func() error {
+			return R.AddNotification(radarr.Notification{Name: WebhookConnectionName})
+		},
+		"Radarr UpdateNotification": func() error {
+			return R.UpdateNotification(radarr.Notification{ID: 1, Name: WebhookConnectionName})
+		},
+		"AddSeries": func() error {
+			return S.AddSeries(sonarr.LookupSeriesResponse{Title: "Severance"}, 6, "/tv", true, true, true, constant.AllEpisodes)
+		},
+		"SeriesSearchCommand": func() error { return S.SeriesSearchCommand(20) },
+		"Sonarr AddNotification": func() error {
+			return S.AddNotification(sonarr.Notification{Name: WebhookConnectionName})
+		},
+		"UpdateDownloadState": func() error {
+			return N.UpdateDownloadState(constant.MediaTypeMovie, "p1", false, notion.DownloadState{Status: constant.MediaStatusQueued, QualityProfile: "Movie: HD-1080p"})
+		},
+		"UpdateReleaseInfo": func() error {
+			return N.UpdateReleaseInfo("p1", notion.ReleaseInfo{Release: "Dune.2021.1080p-GRP", Size: 1 << 30})
+		},
+		"SetDownload":     func() error { return N.SetDownload("p1", false) },
+		"MarkDuplicate":   func() error { return N.MarkDuplicate("p1", "https://www.notion.so/p0") },
+		"MarkForDownload": func() error { return N.MarkForDownload("p1", "Movie: HD-1080p", "Movie: /movies", "") },
+		"AddDBProperties": func() error {
+			return N.AddDBProperties(map[string]int{"Movie: HD-1080p": 4}, map[string]string{"Movie: /movies": "/movies"})
+		},
+	} {
+		err := write()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+	// the page is looked up, the rest only planned
+	_, err := N.QueryDBImdb("tt1160419")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range arrRequests {
+		if !strings.HasPrefix(request, http.MethodGet+" ") {
+			t.Errorf("write reached the *arr: %s", request)
+		}
+	}
+	for _, request := range fakeNotion.Requests() {
+		if request.Method != http.MethodGet && !strings.HasSuffix(request.Path, "/query") {
+			t.Errorf("write reached Notion: %s %s %s", request.Method, request.Path, request.Body)
+		}
+	}
+	for _, planned := range []string{
+		"Service=radarr Action=\"POST /movie\"",
+		"Service=radarr Action=\"PUT /movie/10\"",
+		"Service=radarr Action=\"POST /command\"",
+		"Service=sonarr Action=\"POST /series\"",
+		"Service=sonarr Action=\"POST /command\"",
+		"Service=notion Action=\"PATCH v1/pages/p1\"",
+		"Service=notion Action=\"PATCH v1/databases/db",
+	} {
+		if !strings.Contains(logs.String(), planned) {
+			t.Errorf("expected the planned %s to be logged", planned)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+// Duplicate policies, how the watchlist pages sharing an IMDb ID are updated
+const (
+	// every page of the title is updated
+	DuplicatesUpdateAll = "all"
+	// the oldest page is updated, the others are flagged Duplicate with a link to it
+	DuplicatesCanonical = "canonical"
+)
+
+// ValidDuplicatePolicy reports whether the policy is DuplicatesUpdateAll or DuplicatesCanonical
+func ValidDuplicatePolicy(policy string) bool {
+	return policy == DuplicatesUpdateAll || policy == DuplicatesCanonical
+}
+
+// CanonicalPage returns the oldest page of the title, ties are broken by page ID
+func CanonicalPage(pages []notion.Result) notion.Result {
+	canonical := pages[0]
+	for _, page := range pages[1:] {
+		if page.CreatedTime.Before(canonical.CreatedTime) || (page.CreatedTime.Equal(canonical.CreatedTime) && page.Pgid < canonical.Pgid) {
+			canonical = page
+		}
+	}
+	return canonical
+}
+
+// PagesToUpdate returns the pages of the title a change is written to under the policy
+func PagesToUpdate(policy string, pages []notion.Result) []notion.Result {
+	if policy != DuplicatesCanonical || len(pages) < 2 {
+		return pages
+	}
+	return []notion.Result{CanonicalPage(pages)}
+}
+
+// syncPages syncs the pages of a title under A.DuplicatePolicy, returns whether a page was written
+func (A *App) syncPages(service string, mediaType string, pages []notion.Result, sync func(notion.Result) (bool, error)) (bool, error) {
+	written := false
+	var errs error
+	for _, page := range PagesToUpdate(A.DuplicatePolicy, pages) {
+		pageWritten, err := sync(page)
+		written = written || pageWritten
+		errs = errors.Join(errs, err)
+	}
+	if A.DuplicatePolicy == DuplicatesCanonical && len(pages) > 1 {
+		flagged, err := A.flagDuplicates(service, mediaType, pages)
+		written = written || flagged
+		errs = errors.Join(errs, err)
+	}
+	return written, errs
+}
+
+// flagDuplicates marks the pages other than the canonical one as Duplicate, pages already pointing at it are left untouched
+func (A *App) flagDuplicates(service string, mediaType string, pages []notion.Result) (bool, error) {
+	canonical := CanonicalPage(pages)
+	written := false
+	for _, page := range pages {
+		if page.Pgid == canonical.Pgid || (page.Status() == constant.MediaStatusDuplicate && page.Properties.DuplicateOf.URL == canonical.URL) {
+			continue
+		}
+		err := A.RadarrMedia.N.MarkDuplicate(page.Pgid, canonical.URL)
+		if err != nil {
+			A.recordError(activity.SourceSync, service, mediaType, page.Pgid, pageImdbID(page), err)
+			return written, errors.Join(errors.New("failed to flag duplicate page"), err)
+		}
+		written = true
+		A.Logger.Warn("Duplicates", "Status", "Flagged duplicate page", "ImdbID", pageImdbID(page), "Page", page.Pgid, "Canonical", canonical.Pgid)
+		A.Tracker.UpdateTitle(activity.Title{PageID: page.Pgid, ImdbID: pageImdbID(page), MediaType: mediaType, Status: constant.MediaStatusDuplicate})
+	}
+	return written, nil
+}
+
+// duplicateDetail lists the pages of a duplicated title, canonical first
+func duplicateDetail(pages []notion.Result) string {
+	canonical := CanonicalPage(pages)
+	ids := make([]string, 0, len(pages))
+	for _, page := range pages {
+		ids = append(ids, page.Pgid)
+	}
+	return fmt.Sprintf("%d pages: %s; canonical %s", len(pages), strings.Join(ids, ", "), canonical.Pgid)
+}
+
+// duplicatesCheck lists the IMDb IDs with more than one watchlist page
+func (A *App) duplicatesCheck() DoctorCheck {
+	name := "Duplicate pages"
+	var found []string
+	for _, service := range []struct {
+		enabled   bool
+		mediaType string
+	}{
+		{A.RadarrInit, constant.MediaTypeMovie},
+		{A.SonarrInit, constant.MediaTypeTV},
+	} {
+		if !service.enabled {
+			continue
+		}
+		watchlist, err := A.RadarrMedia.N.QueryWatchlist(service.mediaType)
+		if err != nil {
+			return DoctorCheck{Name: name, Detail: err.Error()}
+		}
+		for imdbID, pages := range watchlistByImdb(watchlist) {
+			if len(pages) > 1 {
+				found = append(found, fmt.Sprintf("%s (%s)", imdbID, duplicateDetail(pages)))
+			}
+		}
+	}
+	if len(found) == 0 {
+		return DoctorCheck{Name: name, OK: true, Detail: "none"}
+	}
+	sort.Strings(found)
+	return DoctorCheck{Name: name, OK: true, Detail: fmt.Sprintf("%d title(s), DUPLICATE_POLICY=%s: %s", len(found), A.DuplicatePolicy, strings.Join(found, "; "))}
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+func TestPagesToUpdate(t *testing.T) {
+	now := time.Now()
+	older := watchlistPage(t, "p2", "tt1", false, "", "", "")
+	older.CreatedTime = now.Add(-time.Hour)
+	newer := watchlistPage(t, "p1", "tt1", false, "", "", "")
+	newer.CreatedTime = now
+	pages := []notion.Result{newer, older}
+
+	if got := CanonicalPage(pages); got.Pgid != "p2" {
+		t.Fatalf("expected the oldest page, got %s", got.Pgid)
+	}
+	if got := PagesToUpdate(DuplicatesUpdateAll, pages); len(got) != 2 {
+		t.Fatalf("expected every page, got %+v", got)
+	}
+	if got := PagesToUpdate(DuplicatesCanonical, pages); len(got) != 1 || got[0].Pgid != "p2" {
+		t.Fatalf("expected the canonical page, got %+v", got)
+	}
+	// same creation time, the lowest page ID wins
+	newer.CreatedTime = older.CreatedTime
+	if got := CanonicalPage([]notion.Result{older, newer}); got.Pgid != "p1" {
+		t.Fatalf("expected p1, got %s", got.Pgid)
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"errors"
+	"time"
 
 	"github.com/flxp49/notion-watchlistarr/internal/constant"
 	"github.com/flxp49/notion-watchlistarr/internal/notion"
@@ -54,19 +55,26 @@ func (radarrMedia RadarrMedia) ProcessTitles(notionPage notion.Result) (radarr.M
 	return movieLookupInfo, LibraryData, nil
 }
 
-func (radarrMedia RadarrMedia) AddTitle(LookupData radarr.MovieLookupResponse, notionPage notion.Result) error {
+// awaitingRelease reports whether the search of the movie is left to the sync: it isn't available yet and the Awaiting Release
+// status is enabled, the sync only searches the pages showing it
+func awaitingRelease(available bool) bool {
+	return !available && notion.StatusEnabled(constant.MediaStatusAwaitingRelease)
+}
+
+// AddTitle adds the movie to Radarr and returns its status, a movie awaiting its release is added without a search
+func (radarrMedia RadarrMedia) AddTitle(LookupData radarr.MovieLookupResponse, notionPage notion.Result) (string, error) {
 	// set monitor property
 	if notionPage.Properties.MonitorProfile.Select.Name == "" {
 		monitorProfile, err := radarrMedia.N.GetNotionMonitorProp(radarrMedia.R.DefaultMonitorProfile, constant.MediaTypeMovie)
 		if err != nil {
-			return errors.Join(errors.New("failed to get monitor profile notion property"), err)
+			return "", errors.Join(errors.New("failed to get monitor profile notion property"), err)
 		}
 		notionPage.Properties.MonitorProfile.Select.Name = monitorProfile
 	}
 	//get rootpath and qualityprofile properties for notion db
 	qualityProp, rootPathProp, err := radarrMedia.N.GetNotionQualityAndRootProps(radarrMedia.R.DefaultQualityProfile, radarrMedia.R.DefaultRootPath, constant.MediaTypeMovie)
 	if err != nil {
-		return errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
+		return "", errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
 	}
 	// set root folder property
 	if notionPage.Properties.RootFolder.Select.Name == "" {
@@ -76,72 +84,99 @@ func (radarrMedia RadarrMedia) AddTitle(LookupData radarr.MovieLookupResponse, n
 	if notionPage.Properties.QualityProfile.Select.Name == "" {
 		notionPage.Properties.QualityProfile.Select.Name = qualityProp
 	}
-	err = radarrMedia.R.AddMovie(LookupData, radarrMedia.N.Qpid[notionPage.Properties.QualityProfile.Select.Name], radarrMedia.N.Rpid[notionPage.Properties.RootFolder.Select.Name], true, true, notion.MonitorProfiles[notionPage.Properties.MonitorProfile.Select.Name])
+	err = radarrMedia.R.AddMovie(LookupData, radarrMedia.N.Qpid[notionPage.Properties.QualityProfile.Select.Name], radarrMedia.N.Rpid[notionPage.Properties.RootFolder.Select.Name], true, !awaitingRelease(LookupData.IsAvailable), notion.MonitorProfiles[notionPage.Properties.MonitorProfile.Select.Name])
 	if err != nil {
-		return errors.Join(errors.New("failed to add movie to radarr"), err)
+		return "", errors.Join(errors.New("failed to add movie to radarr"), err)
+	}
+	if awaitingRelease(LookupData.IsAvailable) {
+		return AwaitingReleaseState(LookupData.ExpectedAvailability()).Status, nil
 	}
-	return nil
+	return constant.MediaStatusQueued, nil
 }
 
-func (radarrMedia RadarrMedia) HandleExistingTitle(LibraryData []radarr.GetMovieResponse, notionPage notion.Result) error {
+// AwaitingReleaseState is the state of a movie that isn't available yet: Awaiting Release with the expected date, Queued when the status isn't enabled
+func AwaitingReleaseState(expected time.Time) notion.DownloadState {
+	if !notion.StatusEnabled(constant.MediaStatusAwaitingRelease) {
+		return notion.DownloadState{Status: constant.MediaStatusQueued}
+	}
+	state := notion.DownloadState{Status: constant.MediaStatusAwaitingRelease}
+	if !expected.IsZero() {
+		state.AvailableFrom = expected.Format("2006-01-02")
+	}
+	return state
+}
+
+// HandleExistingTitle updates the watchlist for a movie already in the library and returns the status set
+func (radarrMedia RadarrMedia) HandleExistingTitle(LibraryData []radarr.GetMovieResponse, notionPage notion.Result) (string, error) {
 	//get rootpath and qualityprofile properties for notion db
 	qualityProp, rootPathProp, err := radarrMedia.N.GetNotionQualityAndRootProps(LibraryData[0].QualityProfileID, LibraryData[0].RootFolderPath, constant.MediaTypeMovie)
 	if err != nil {
-		return err
+		return "", err
 	}
 	monitoredProfile, err := radarrMedia.getMovieMonitorProfile(LibraryData[0].Collection.TmdbID)
 	if err != nil {
-		return err
+		return "", err
 	}
 	monitoredProfileNotionProp, _ := radarrMedia.N.GetNotionMonitorProp(monitoredProfile, constant.MediaTypeMovie)
 	if LibraryData[0].HasFile {
-		radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, monitoredProfileNotionProp)
-		return nil
+		return constant.MediaStatusDownloaded, radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, monitoredProfileNotionProp)
 	}
 	//check for queue status
 	queueStatus, err := radarrMedia.R.GetQueueDetails(LibraryData[0].ID)
 	if err != nil {
-		return errors.Join(errors.New("failed to get queue details in radarr"), err)
+		return "", errors.Join(errors.New("failed to get queue details in radarr"), err)
 	}
 	if queueStatus {
-		radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, monitoredProfileNotionProp)
-		return nil
+		return constant.MediaStatusDownloading, radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, monitoredProfileNotionProp)
+	}
+	// nothing can be grabbed before the release, the sync searches once the movie is available
+	if awaitingRelease(LibraryData[0].IsAvailable) {
+		state := AwaitingReleaseState(LibraryData[0].ExpectedAvailability())
+		state.QualityProfile, state.RootFolder, state.MonitorProfile = qualityProp, rootPathProp, monitoredProfileNotionProp
+		return state.Status, radarrMedia.N.UpdateDownloadState(constant.MediaTypeMovie, notionPage.Pgid, false, state)
 	}
 	//trigger movie search in Radarr
 	err = radarrMedia.R.MovieSearchCommand(LibraryData[0].ID)
 	if err != nil {
-		return errors.Join(errors.New("failed to trigger movie search command in radarr"), err)
+		return "", errors.Join(errors.New("failed to trigger movie search command in radarr"), err)
 	}
-	radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusQueued, qualityProp, rootPathProp, monitoredProfileNotionProp)
-	return nil
+	return constant.MediaStatusQueued, radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, notionPage.Pgid, false, constant.MediaStatusQueued, qualityProp, rootPathProp, monitoredProfileNotionProp)
 }
 
-func (radarrMedia RadarrMedia) ProcessLibraryTitle(watchlistMovie notion.QueryDBIdResponse, radarrMovie radarr.GetMovieResponse) error {
-	monitoredProfile, err := radarrMedia.getMovieMonitorProfile(radarrMovie.Collection.TmdbID)
-	if err != nil {
-		return err
+// LibraryState computes the watchlist state of a movie in the library
+//
+// queued - the movie has items in the download queue, collectionMonitored - its collection is monitored
+func (radarrMedia RadarrMedia) LibraryState(radarrMovie radarr.GetMovieResponse, queued bool, collectionMonitored bool) (notion.DownloadState, error) {
+	state := notion.DownloadState{Status: constant.MediaStatusDownloaded}
+	switch {
+	case radarrMovie.HasFile && queued && notion.StatusEnabled(constant.MediaStatusUpgrading):
+		state.Status = constant.MediaStatusUpgrading
+	case radarrMovie.HasFile:
+	case queued:
+		state.Status = constant.MediaStatusDownloading
+	case radarrMovie.Monitored && awaitingRelease(radarrMovie.IsAvailable):
+		state = AwaitingReleaseState(radarrMovie.ExpectedAvailability())
+	default:
+		// the options are cleared
+		return notion.DownloadState{Status: constant.MediaStatusNotDownloaded}, nil
+	}
+	return radarrMedia.libraryProps(state, radarrMovie, collectionMonitored)
+}
+
+// libraryProps sets the notion options of the movie's profiles on the state
+func (radarrMedia RadarrMedia) libraryProps(state notion.DownloadState, radarrMovie radarr.GetMovieResponse, collectionMonitored bool) (notion.DownloadState, error) {
+	monitoredProfile := constant.MovieOnly
+	if collectionMonitored {
+		monitoredProfile = constant.MovieAndCollection
 	}
 	monitoredProfileNotionProp, _ := radarrMedia.N.GetNotionMonitorProp(monitoredProfile, constant.MediaTypeMovie)
 	//get rootpath and qualityprofile properties for notion db
 	qualityProp, rootPathProp, err := radarrMedia.N.GetNotionQualityAndRootProps(radarrMovie.QualityProfileID, radarrMovie.RootFolderPath, constant.MediaTypeMovie)
 	if err != nil {
-		return errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
-	}
-	if radarrMovie.HasFile {
-		radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, watchlistMovie.Results[0].Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, monitoredProfileNotionProp)
-		return nil
-	}
-	//check for queue status
-	queueStatus, err := radarrMedia.R.GetQueueDetails(radarrMovie.ID)
-	if err != nil {
-		return errors.Join(errors.New("failed to get queue details in radarr"), err)
-	}
-	if queueStatus {
-		radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, watchlistMovie.Results[0].Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, monitoredProfileNotionProp)
-		return nil
+		return notion.DownloadState{}, errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
 	}
-	radarrMedia.N.UpdateDownloadStatus(constant.MediaTypeMovie, watchlistMovie.Results[0].Pgid, false, constant.MediaStatusNotDownloaded, qualityProp, rootPathProp, monitoredProfileNotionProp)
-	return nil
+	state.QualityProfile, state.RootFolder, state.MonitorProfile = qualityProp, rootPathProp, monitoredProfileNotionProp
+	return state, nil
 }
 
 func (radarrMedia RadarrMedia) getMovieMonitorProfile(collectionTmdbid int) (string, error) {
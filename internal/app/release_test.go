@@ -0,0 +1,113 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+)
+
+func TestExpectedAvailability(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		name                               string
+		minimum                            string
+		inCinemas, digital, physical, want time.Time
+	}{
+		{"announced", radarr.AvailabilityAnnounced, day(1), day(2), day(3), time.Time{}},
+		{"in cinemas", radarr.AvailabilityInCinemas, day(1), day(2), day(3), day(1)},
+		{"earliest release", radarr.AvailabilityReleased, day(1), day(9), day(5), day(5)},
+		{"digital only", radarr.AvailabilityReleased, day(1), day(9), time.Time{}, day(9)},
+		{"cinema fallback", radarr.AvailabilityReleased, day(1), time.Time{}, time.Time{}, day(1).AddDate(0, 0, 90)},
+		{"no dates", radarr.AvailabilityReleased, time.Time{}, time.Time{}, time.Time{}, time.Time{}},
+	} {
+		if got := radarr.ExpectedAvailability(tc.minimum, tc.inCinemas, tc.digital, tc.physical); !got.Equal(tc.want) {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAwaitingReleaseState(t *testing.T) {
+	defer notion.UseStatuses(nil, nil)
+	expected := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+	if state := AwaitingReleaseState(expected); state.Status != constant.MediaStatusQueued || state.AvailableFrom != "" {
+		t.Fatalf("expected Queued while the status is disabled, got %+v", state)
+	}
+	err := notion.UseStatuses(nil, []string{constant.MediaStatusAwaitingRelease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := AwaitingReleaseState(expected)
+	if state.Status != constant.MediaStatusAwaitingRelease || state.AvailableFrom != "2026-03-14" {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	var page notion.Result
+	err = json.Unmarshal([]byte(`{"id": "p", "properties": {
+		"Download Status": {"select": {"name": "⏳ Awaiting Release"}},
+		"Available From": {"date": {"start": "2026-03-14"}}}}`), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Matches(constant.MediaTypeTV, state) {
+		t.Fatal("expected the page to match")
+	}
+	state.AvailableFrom = "2026-04-01"
+	if page.Matches(constant.MediaTypeTV, state) {
+		t.Fatal("a new date has to be written")
+	}
+	// the date is cleared once the title leaves Awaiting Release
+	if page.Matches(constant.MediaTypeTV, notion.DownloadState{Status: constant.MediaStatusDownloading}) {
+		t.Fatal("expected the date to be cleared")
+	}
+}
+
+func TestAddTitleSearch(t *testing.T) {
+	defer notion.UseStatuses(nil, nil)
+	var added []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/api/v3/movie" {
+			added, _ = io.ReadAll(r.Body)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	N := notion.InitNotionClient("", "")
+	N.UseProfiles(map[string]int{"Movie: HD-1080p": 4}, map[string]string{"Movie: /movies": "/movies"})
+	R := radarr.InitRadarrClient("key", srv.URL)
+	R.DefaultQualityProfile, R.DefaultRootPath, R.DefaultMonitorProfile = 4, "/movies", constant.MovieOnly
+	radarrMedia := NewRadarrMedia(N, R)
+	page := watchlistPage(t, "p1", "tt1", true, "", "", constant.NotionOptionMovieOnly)
+	searched := func() bool {
+		var payload struct {
+			AddOptions struct {
+				SearchForMovie bool `json:"searchForMovie"`
+			} `json:"addOptions"`
+		}
+		err := json.Unmarshal(added, &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload.AddOptions.SearchForMovie
+	}
+
+	// without the Awaiting Release status the sync never searches it, the search happens on add
+	status, err := radarrMedia.AddTitle(radarr.MovieLookupResponse{Title: "Unreleased"}, page)
+	if err != nil || status != constant.MediaStatusQueued || !searched() {
+		t.Fatalf("expected a search with the status disabled, got %q %v", status, err)
+	}
+	err = notion.UseStatuses(nil, []string{constant.MediaStatusAwaitingRelease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = radarrMedia.AddTitle(radarr.MovieLookupResponse{Title: "Unreleased"}, page)
+	if err != nil || status != constant.MediaStatusAwaitingRelease || searched() {
+		t.Fatalf("expected no search while Awaiting Release, got %q %v", status, err)
+	}
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+)
+
+// Drift kinds of the reconciliation report, in report order
+const (
+	DriftMissingFile    = "missing-file"
+	DriftNotInWatchlist = "not-in-watchlist"
+	DriftDuplicate      = "duplicate"
+	DriftProfile        = "profile-mismatch"
+	DriftStuck          = "stuck"
+)
+
+var driftKinds = []struct {
+	kind    string
+	heading string
+}{
+	{DriftMissingFile, "Marked Downloaded without a file"},
+	{DriftNotInWatchlist, "In the library but not in the watchlist"},
+	{DriftDuplicate, "Duplicate IMDb IDs"},
+	{DriftProfile, "Quality profile / root folder mismatch"},
+	{DriftStuck, "Stuck in Queued / Downloading"},
+}
+
+// Drift is a difference between the watchlist and the Radarr/Sonarr library
+type Drift struct {
+	Kind    string `json:"kind"`
+	Service string `json:"service"`
+	ImdbID  string `json:"imdbId"`
+	Title   string `json:"title,omitempty"`
+	PageID  string `json:"pageId,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+type Report struct {
+	Generated time.Time `json:"generated"`
+	StuckDays int       `json:"stuckDays"`
+	Drift     []Drift   `json:"drift"`
+}
+
+// libraryTitle is the part of a Radarr/Sonarr title compared with the watchlist
+//
+// qualityProfile, rootFolder - notion options of the *arr settings, profileErr - set when they have no matching option
+type libraryTitle struct {
+	imdbID         string
+	title          string
+	hasFile        bool
+	qualityProfile string
+	rootFolder     string
+	profileErr     error
+}
+
+func (A *App) radarrLibraryTitles() ([]libraryTitle, error) {
+	library, err := A.RadarrMedia.FetchRadarrLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return movieLibraryTitles(A.RadarrMedia.N, library), nil
+}
+
+func movieLibraryTitles(N *notion.NotionClient, library []radarr.GetMovieResponse) []libraryTitle {
+	titles := make([]libraryTitle, 0, len(library))
+	for _, movie := range library {
+		title := libraryTitle{imdbID: movie.ImdbID, title: movie.Title, hasFile: movie.HasFile}
+		title.qualityProfile, title.rootFolder, title.profileErr = N.GetNotionQualityAndRootProps(movie.QualityProfileID, movie.RootFolderPath, constant.MediaTypeMovie)
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+func (A *App) sonarrLibraryTitles() ([]libraryTitle, error) {
+	library, err := A.SonarrMedia.FetchSonarrLibrary()
+	if err != nil {
+		return nil, err
+	}
+	titles := make([]libraryTitle, 0, len(library))
+	for _, series := range library {
+		title := libraryTitle{imdbID: series.ImdbID, title: series.Title, hasFile: series.Statistics.EpisodeFileCount > 0}
+		title.qualityProfile, title.rootFolder, title.profileErr = A.SonarrMedia.N.GetNotionQualityAndRootProps(series.QualityProfileID, series.RootFolderPath, constant.MediaTypeTV)
+		titles = append(titles, title)
+	}
+	return titles, nil
+}
+
+// Report compares the watchlist with the Radarr/Sonarr libraries, titles Queued or Downloading for more than stuckDays are reported as stuck.
+// stuckDays <= 0 uses A.ReportStuckDays
+func (A *App) Report(stuckDays int) (Report, error) {
+	if stuckDays <= 0 {
+		stuckDays = A.ReportStuckDays
+	}
+	report := Report{Generated: time.Now(), StuckDays: stuckDays, Drift: []Drift{}}
+	// the tracked transition is more accurate than the last edit of the page
+	since := func(page notion.Result) time.Time {
+		if title, ok := A.Tracker.Title(page.Pgid); ok && title.Status == page.Status() && !title.LastTransition.IsZero() {
+			return title.LastTransition
+		}
+		return page.LastEditedTime
+	}
+	stuckBefore := report.Generated.Add(-time.Duration(stuckDays) * 24 * time.Hour)
+	for _, service := range []struct {
+		name      string
+		enabled   bool
+		mediaType string
+		library   func() ([]libraryTitle, error)
+	}{
+		{"radarr", A.RadarrInit, constant.MediaTypeMovie, A.radarrLibraryTitles},
+		{"sonarr", A.SonarrInit, constant.MediaTypeTV, A.sonarrLibraryTitles},
+	} {
+		if !service.enabled {
+			continue
+		}
+		library, err := service.library()
+		if err != nil {
+			return Report{}, errors.Join(fmt.Errorf("failed to fetch %s library", service.name), err)
+		}
+		pages, err := A.RadarrMedia.N.QueryWatchlist(service.mediaType)
+		if err != nil {
+			return Report{}, errors.Join(errors.New("failed to fetch notion watchlist"), err)
+		}
+		report.Drift = append(report.Drift, findDrift(service.name, pages, library, since, stuckBefore)...)
+	}
+	return report, nil
+}
+
+// findDrift compares the watchlist pages of a media type with the library
+func findDrift(service string, pages []notion.Result, library []libraryTitle, since func(notion.Result) time.Time, stuckBefore time.Time) []Drift {
+	drift := []Drift{}
+	byImdb := watchlistByImdb(pages)
+	titles := make(map[string]libraryTitle, len(library))
+	for _, title := range library {
+		if title.imdbID == "" {
+			continue
+		}
+		titles[title.imdbID] = title
+		if len(byImdb[title.imdbID]) == 0 {
+			drift = append(drift, Drift{Kind: DriftNotInWatchlist, Service: service, ImdbID: title.imdbID, Title: title.title, Detail: "in the " + service + " library but not in the watchlist"})
+		}
+	}
+	for imdbID, pages := range byImdb {
+		title, inLibrary := titles[imdbID]
+		if len(pages) > 1 {
+			drift = append(drift, Drift{Kind: DriftDuplicate, Service: service, ImdbID: imdbID, Title: title.title, Detail: duplicateDetail(pages)})
+		}
+		for _, page := range pages {
+			found := Drift{Service: service, ImdbID: imdbID, Title: title.title, PageID: page.Pgid}
+			status := page.Status()
+			// flagged pages keep their old options, the canonical page is the one compared
+			if status == constant.MediaStatusDuplicate {
+				continue
+			}
+			switch {
+			case status == constant.MediaStatusDownloaded && !inLibrary:
+				found.Kind, found.Detail = DriftMissingFile, "marked Downloaded but not in the "+service+" library"
+				drift = append(drift, found)
+			case status == constant.MediaStatusDownloaded && !title.hasFile:
+				found.Kind, found.Detail = DriftMissingFile, "marked Downloaded but has no file in "+service
+				drift = append(drift, found)
+			}
+			if mismatch := profileMismatch(page, title, service); inLibrary && mismatch != "" {
+				found.Kind, found.Detail = DriftProfile, mismatch
+				drift = append(drift, found)
+			}
+			if at := since(page); (status == constant.MediaStatusQueued || status == constant.MediaStatusDownloading) && !at.IsZero() && at.Before(stuckBefore) {
+				found.Kind, found.Detail = DriftStuck, fmt.Sprintf("%s since %s", status, at.Format("2006-01-02"))
+				drift = append(drift, found)
+			}
+		}
+	}
+	sortDrift(drift)
+	return drift
+}
+
+// profileMismatch describes the options of the page that differ from the library, unset options are ignored.
+// The settings of the library without a Notion option are reported as unknown, not compared
+func profileMismatch(page notion.Result, title libraryTitle, service string) string {
+	var mismatch []string
+	quality, root := page.Properties.QualityProfile.Select.Name, page.Properties.RootFolder.Select.Name
+	if title.profileErr != nil {
+		if quality == "" && root == "" {
+			return ""
+		}
+		return fmt.Sprintf("Quality Profile / Root Folder unknown, the %s settings have no Notion option: %s", service, title.profileErr)
+	}
+	if quality != "" && quality != title.qualityProfile {
+		mismatch = append(mismatch, fmt.Sprintf("Quality Profile is %q, %q in %s", quality, title.qualityProfile, service))
+	}
+	if root != "" && root != title.rootFolder {
+		mismatch = append(mismatch, fmt.Sprintf("Root Folder is %q, %q in %s", root, title.rootFolder, service))
+	}
+	return strings.Join(mismatch, "; ")
+}
+
+func sortDrift(drift []Drift) {
+	order := make(map[string]int, len(driftKinds))
+	for i, k := range driftKinds {
+		order[k.kind] = i
+	}
+	sort.SliceStable(drift, func(i, j int) bool {
+		a, b := drift[i], drift[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.ImdbID < b.ImdbID
+	})
+}
+
+// reportSections groups the drift by kind, titles are shown with their IMDb ID
+func reportSections(report Report) []notion.ReportSection {
+	sections := []notion.ReportSection{}
+	for _, k := range driftKinds {
+		section := notion.ReportSection{Heading: k.heading}
+		for _, d := range report.Drift {
+			if d.Kind == k.kind {
+				name := d.ImdbID
+				if d.Title != "" {
+					name = fmt.Sprintf("%s (%s)", d.Title, d.ImdbID)
+				}
+				section.Items = append(section.Items, fmt.Sprintf("[%s] %s: %s", d.Service, name, d.Detail))
+			}
+		}
+		if len(section.Items) != 0 {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// WriteReport prints the report as a table per drift kind
+func WriteReport(w io.Writer, report Report) error {
+	fmt.Fprintf(w, "Watchlist report %s, %d difference(s), stuck after %d day(s)\n", report.Generated.Format("2006-01-02 15:04"), len(report.Drift), report.StuckDays)
+	for _, k := range driftKinds {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		header := false
+		for _, d := range report.Drift {
+			if d.Kind != k.kind {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(w, "\n%s\n", k.heading)
+				fmt.Fprintln(tw, "SERVICE\tIMDB ID\tTITLE\tDETAIL")
+				header = true
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Service, d.ImdbID, d.Title, d.Detail)
+		}
+		err := tw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishReport writes the report to a new page under A.ReportPageID and returns its URL
+func (A *App) PublishReport(report Report) (string, error) {
+	if A.ReportPageID == "" {
+		return "", invalidRequest("NOTION_REPORT_PAGE_ID is not set")
+	}
+	title := "Watchlist report " + report.Generated.Format("2006-01-02 15:04")
+	url, err := A.RadarrMedia.N.CreateReportPage(A.ReportPageID, title, reportSections(report))
+	if err != nil {
+		return "", errors.Join(errors.New("failed to create notion report page"), err)
+	}
+	return url, nil
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+)
+
+func TestFindDrift(t *testing.T) {
+	now := time.Now()
+	pages := []notion.Result{
+		watchlistPage(t, "p1", "tt1", false, "🔵 Downloaded", "Movie: HD-1080p", ""),
+		watchlistPage(t, "p2", "tt2", false, "🔵 Downloaded", "", ""),
+		watchlistPage(t, "p3", "tt3", false, "🟡 Queued", "Movie: SD", ""),
+		watchlistPage(t, "p4", "tt3", false, "🟢 Downloading", "", ""),
+		watchlistPage(t, "p5", "tt5", false, "🟡 Queued", "", ""),
+	}
+	library := []libraryTitle{
+		{imdbID: "tt1", title: "One", hasFile: false, qualityProfile: "Movie: HD-1080p"},
+		{imdbID: "tt3", title: "Three", hasFile: false, qualityProfile: "Movie: HD-1080p"},
+		{imdbID: "tt4", title: "Four", hasFile: true},
+		{imdbID: "tt5", title: "Five"},
+	}
+	// p3 has been queued for a month, the others changed recently
+	since := func(page notion.Result) time.Time {
+		if page.Pgid == "p3" {
+			return now.AddDate(0, -1, 0)
+		}
+		return now
+	}
+	drift := findDrift("radarr", pages, library, since, now.AddDate(0, 0, -7))
+	got := []string{}
+	for _, d := range drift {
+		got = append(got, d.Kind+" "+d.ImdbID+" "+d.PageID)
+	}
+	want := []string{
+		"missing-file tt2 p2", // not in the library, sorted before titled entries
+		"missing-file tt1 p1",
+		"not-in-watchlist tt4 ",
+		"duplicate tt3 ",
+		"profile-mismatch tt3 p3",
+		"stuck tt3 p3",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out bytes.Buffer
+	err := WriteReport(&out, Report{Generated: now, StuckDays: 7, Drift: drift})
+	if err != nil || !strings.Contains(out.String(), "Duplicate IMDb IDs") || !strings.Contains(out.String(), "2 pages: p3, p4") {
+		t.Fatalf("unexpected table %s", out.String())
+	}
+	if sections := reportSections(Report{Drift: drift}); len(sections) != len(driftKinds) {
+		t.Fatalf("expected a section per kind, got %+v", sections)
+	}
+}
+
+func TestFindDriftProfiles(t *testing.T) {
+	N := notion.InitNotionClient("", "")
+	N.UseProfiles(map[string]int{"Movie: HD-1080p": 4, "Movie: SD": 1, "TV Series: HD-1080p": 4}, map[string]string{"Movie: /movies": "/movies", "TV Series: /tv": "/tv"})
+	library := movieLibraryTitles(N, []radarr.GetMovieResponse{
+		{ImdbID: "tt1", Title: "Same", QualityProfileID: 4, RootFolderPath: "/movies/"},
+		{ImdbID: "tt2", Title: "Other profile", QualityProfileID: 1, RootFolderPath: "/movies"},
+		{ImdbID: "tt3", Title: "Unknown profile", QualityProfileID: 9, RootFolderPath: "/movies"},
+	})
+	pages := []notion.Result{
+		watchlistPage(t, "p1", "tt1", false, "", "Movie: HD-1080p", ""),
+		watchlistPage(t, "p2", "tt2", false, "", "Movie: HD-1080p", ""),
+		watchlistPage(t, "p3", "tt3", false, "", "Movie: HD-1080p", ""),
+	}
+	for i := range pages {
+		pages[i].Properties.RootFolder.Select.Name = "Movie: /movies"
+	}
+	now := time.Now()
+	drift := findDrift("radarr", pages, library, func(notion.Result) time.Time { return now }, now)
+	if len(drift) != 2 || drift[0].ImdbID != "tt2" || drift[1].ImdbID != "tt3" {
+		t.Fatalf("expected tt2 and tt3 to be reported, got %+v", drift)
+	}
+	if drift[0].Detail != `Quality Profile is "Movie: HD-1080p", "Movie: SD" in radarr` {
+		t.Errorf("unexpected mismatch %q", drift[0].Detail)
+	}
+	if !strings.Contains(drift[1].Detail, "unknown") {
+		t.Errorf("expected tt3 to be reported as unknown, got %q", drift[1].Detail)
+	}
+}
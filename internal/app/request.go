@@ -0,0 +1,177 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrTitleNotFound  = errors.New("title not found")
+)
+
+// TitleRequest asks for a title to be added to the watchlist and downloaded.
+//
+// One of ImdbID, TmdbID (movie) or TvdbID (series) is required. Type (Movie || TV Series) is only needed to skip the lookup when an ImdbID is passed.
+// QualityProfile, RootFolder and Monitor are Notion option names, the defaults are used when empty.
+type TitleRequest struct {
+	ImdbID         string `json:"imdbId"`
+	TmdbID         int    `json:"tmdbId"`
+	TvdbID         int    `json:"tvdbId"`
+	Type           string `json:"type"`
+	QualityProfile string `json:"qualityProfile"`
+	RootFolder     string `json:"rootFolder"`
+	Monitor        string `json:"monitor"`
+}
+
+type TitleResult struct {
+	Created bool `json:"created"`
+	activity.Title
+}
+
+// resolvedTitle is the title found via the *arr lookup
+type resolvedTitle struct {
+	imdbID    string
+	name      string
+	mediaType string
+}
+
+func invalidRequest(msg string) error {
+	return errors.Join(ErrInvalidRequest, errors.New(msg))
+}
+
+func (A *App) resolveMovie(req TitleRequest) (resolvedTitle, error) {
+	if !A.RadarrInit {
+		return resolvedTitle{}, invalidRequest("radarr is not enabled")
+	}
+	var movie radarr.MovieLookupResponse
+	var err error
+	if req.TmdbID != 0 {
+		movie, err = A.RadarrMedia.R.LookupMovieTmdb(req.TmdbID)
+	} else {
+		movie, err = A.RadarrMedia.R.LookupMovie(req.ImdbID)
+	}
+	if err != nil || movie.TmdbID == 0 || movie.ImdbID == "" {
+		return resolvedTitle{}, errors.Join(ErrTitleNotFound, err)
+	}
+	return resolvedTitle{imdbID: movie.ImdbID, name: movie.Title, mediaType: constant.MediaTypeMovie}, nil
+}
+
+func (A *App) resolveSeries(req TitleRequest) (resolvedTitle, error) {
+	if !A.SonarrInit {
+		return resolvedTitle{}, invalidRequest("sonarr is not enabled")
+	}
+	idType, id := constant.IMDB, req.ImdbID
+	if req.TvdbID != 0 {
+		idType, id = constant.TVDB, strconv.Itoa(req.TvdbID)
+	}
+	series, err := A.SonarrMedia.S.LookupSeries(idType, id)
+	if err != nil || series.ImdbID == "" {
+		return resolvedTitle{}, errors.Join(ErrTitleNotFound, err)
+	}
+	return resolvedTitle{imdbID: series.ImdbID, name: series.Title, mediaType: constant.MediaTypeTV}, nil
+}
+
+// resolveTitle finds the title in Radarr/Sonarr, an IMDb ID without Type is tried as a movie first
+func (A *App) resolveTitle(req TitleRequest) (resolvedTitle, error) {
+	switch {
+	case req.TmdbID != 0:
+		return A.resolveMovie(req)
+	case req.TvdbID != 0:
+		return A.resolveSeries(req)
+	case req.ImdbID == "":
+		return resolvedTitle{}, invalidRequest("one of imdbId, tmdbId or tvdbId is required")
+	case req.Type == constant.MediaTypeMovie:
+		return A.resolveMovie(req)
+	case req.Type == constant.MediaTypeTV:
+		return A.resolveSeries(req)
+	case req.Type != "":
+		return resolvedTitle{}, invalidRequest(fmt.Sprintf("type must be %q or %q", constant.MediaTypeMovie, constant.MediaTypeTV))
+	}
+	if A.RadarrInit {
+		title, err := A.resolveMovie(req)
+		if err == nil || !A.SonarrInit {
+			return title, err
+		}
+	}
+	return A.resolveSeries(req)
+}
+
+// validateProps checks the requested options exist in the DB for the media type
+func (A *App) validateProps(req TitleRequest, mediaType string) error {
+	N := A.RadarrMedia.N
+	if _, exists := N.Qpid[req.QualityProfile]; req.QualityProfile != "" && (!exists || !strings.Contains(req.QualityProfile, mediaType)) {
+		return invalidRequest(fmt.Sprintf("unknown %s quality profile %q", mediaType, req.QualityProfile))
+	}
+	if _, exists := N.Rpid[req.RootFolder]; req.RootFolder != "" && (!exists || !strings.Contains(req.RootFolder, mediaType)) {
+		return invalidRequest(fmt.Sprintf("unknown %s root folder %q", mediaType, req.RootFolder))
+	}
+	if _, exists := notion.MonitorProfiles[req.Monitor]; req.Monitor != "" && (!exists || !strings.Contains(req.Monitor, mediaType)) {
+		return invalidRequest(fmt.Sprintf("unknown %s monitor %q", mediaType, req.Monitor))
+	}
+	return nil
+}
+
+// RequestTitle creates (or locates) the watchlist page of the title, ticks Download and processes it right away
+func (A *App) RequestTitle(req TitleRequest) (TitleResult, error) {
+	title, err := A.resolveTitle(req)
+	if err != nil {
+		return TitleResult{}, err
+	}
+	err = A.validateProps(req, title.mediaType)
+	if err != nil {
+		return TitleResult{}, err
+	}
+	N := A.RadarrMedia.N
+	service, mu, process := "radarr", &A.radarrPollMu, A.RadarrProcessPage
+	if title.mediaType == constant.MediaTypeTV {
+		service, mu, process = "sonarr", &A.sonarrPollMu, A.SonarrProcessPage
+	}
+	// hold the poll lock so the poll job doesn't pick up the page while it's processed here
+	mu.Lock()
+	defer mu.Unlock()
+
+	result := TitleResult{}
+	existing, err := N.QueryDBImdb(title.imdbID)
+	if err != nil {
+		return TitleResult{}, errors.Join(errors.New("failed to query watchlist"), err)
+	}
+	pageID := ""
+	if len(existing.Results) != 0 {
+		pageID = CanonicalPage(existing.Results).Pgid
+		err = N.MarkForDownload(pageID, req.QualityProfile, req.RootFolder, req.Monitor)
+		if err != nil {
+			return TitleResult{}, errors.Join(errors.New("failed to tick download in watchlist"), err)
+		}
+	} else {
+		pageID, err = N.CreatePage(title.name, title.imdbID, title.mediaType, req.QualityProfile, req.RootFolder, req.Monitor)
+		if err != nil {
+			return TitleResult{}, errors.Join(errors.New("failed to add title to watchlist"), err)
+		}
+		result.Created = true
+		if A.DryRun {
+			// the page creation was only planned, there is no page to process
+			return result, nil
+		}
+	}
+	page, err := N.GetPage(pageID)
+	if err != nil {
+		return TitleResult{}, errors.Join(errors.New("failed to fetch watchlist page"), err)
+	}
+	status, err := process(page)
+	if err != nil {
+		N.UpdateDownloadStatus(title.mediaType, pageID, false, constant.MediaStatusError, "", "", "")
+		A.recordError(activity.SourceManual, service, title.mediaType, pageID, title.imdbID, err)
+		return TitleResult{}, err
+	}
+	A.Tracker.Record(activity.Event{Source: activity.SourceManual, Service: service, Type: "request", ImdbID: title.imdbID, Title: title.name, Status: status})
+	result.Title, _ = A.Tracker.Title(pageID)
+	return result, nil
+}
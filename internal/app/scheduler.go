@@ -0,0 +1,465 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Schedule returns the next run time of a job after the given time
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// ParseSchedule parses a job schedule:
+//
+//	"30s" | "@every 30s"                 - fixed interval
+//	"0 4 * * *" | "@daily" | "@hourly"   - cron expression (minute hour day-of-month month day-of-week, local time)
+//	"18:00-23:00=30s,23:00-07:00=1h,*=5m" - time windows with their interval, * is used outside the windows (no runs outside the windows when omitted)
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, errors.New("empty schedule")
+	case spec == "@hourly":
+		spec = "0 * * * *"
+	case spec == "@daily" || spec == "@midnight":
+		spec = "0 0 * * *"
+	case strings.HasPrefix(spec, "@every "):
+		spec = strings.TrimSpace(strings.TrimPrefix(spec, "@every "))
+	}
+	if strings.Contains(spec, "=") {
+		return parseWindows(spec)
+	}
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", spec)
+		}
+		return Every(d), nil
+	}
+	return parseCron(spec)
+}
+
+// Every runs the job at a fixed interval
+func Every(d time.Duration) Schedule {
+	return intervalSchedule(d)
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule holds the allowed values of each field as a bitset
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// day-of-month and day-of-week are OR'ed when both are restricted
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+func parseCron(spec string) (Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected an interval, a cron expression with 5 fields or time windows", spec)
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	// 7 is sunday too
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	s := &cronSchedule{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4], domStar: parts[2] == "*", dowStar: parts[4] == "*"}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses "*", "5", "1-5", "*/15", "0-30/10" and comma separated lists of those
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i != -1 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", f.name, field)
+			}
+			rng, step = item[:i], s
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s field %q", f.name, field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid %s field %q", f.name, field)
+				}
+			} else if step != 1 {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", f.name, field, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<v) != 0
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch, dowMatch := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// give up after 5 years, only impossible dates (ex: 30 2 *) get there
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			// next hour of the local clock, Truncate works in UTC and is off in the half-hour zones
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// window runs the job every interval between start and end (minutes of the day, end excluded, may wrap past midnight)
+type window struct {
+	start, end int
+	interval   time.Duration
+}
+
+func (w window) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+type windowSchedule struct {
+	windows []window
+	// used outside the windows, 0 = no runs
+	fallback time.Duration
+}
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseWindows(spec string) (Schedule, error) {
+	s := &windowSchedule{}
+	for _, entry := range strings.Split(spec, ",") {
+		rng, interval, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM=interval", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid interval in window %q", entry)
+		}
+		rng = strings.TrimSpace(rng)
+		if rng == "*" {
+			s.fallback = d
+			continue
+		}
+		from, to, found := strings.Cut(rng, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM=interval", entry)
+		}
+		w := window{interval: d}
+		if w.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("window %q is empty", entry)
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(s.windows) == 0 && s.fallback == 0 {
+		return nil, errors.New("no windows in schedule")
+	}
+	return s, nil
+}
+
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// interval returns the interval of the first window containing t
+func (s *windowSchedule) interval(t time.Time) time.Duration {
+	for _, w := range s.windows {
+		if w.contains(minuteOfDay(t)) {
+			return w.interval
+		}
+	}
+	return s.fallback
+}
+
+// nextWindowStart returns the first window start after t
+func (s *windowSchedule) nextWindowStart(t time.Time) time.Time {
+	var next time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, w := range s.windows {
+		start := midnight.Add(time.Duration(w.start) * time.Minute)
+		if !start.After(t) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+func (s *windowSchedule) Next(after time.Time) time.Time {
+	nextStart := s.nextWindowStart(after)
+	interval := s.interval(after)
+	if interval == 0 {
+		return nextStart
+	}
+	next := after.Add(interval)
+	// a window starting earlier (ex: the fast evening window) takes over
+	if !nextStart.IsZero() && nextStart.Before(next) {
+		return nextStart
+	}
+	// no runs outside the windows without a fallback
+	if s.fallback == 0 && s.interval(next) == 0 {
+		return nextStart
+	}
+	return next
+}
+
+// scheduledJob is a job registered with the Scheduler
+type scheduledJob struct {
+	name     string
+	schedule Schedule
+	run      func(ctx context.Context) error
+	running  atomic.Bool
+	wake     chan struct{}
+}
+
+// Scheduler runs each job on its schedule. A run due while the previous one is still going is skipped.
+//
+// Jitter is the max random delay added to each run, capped at a tenth of the time to the following run.
+// RetryAfter is the delay before running again when a run fails.
+type Scheduler struct {
+	Jitter     time.Duration
+	RetryAfter time.Duration
+	Logger     *slog.Logger
+	mu         sync.Mutex
+	jobs       map[string]*scheduledJob
+	nextRuns   map[string]time.Time
+	// loops and in-flight runs, waited for on shutdown
+	wg sync.WaitGroup
+}
+
+func NewScheduler(jitter time.Duration, Logger *slog.Logger) *Scheduler {
+	return &Scheduler{Jitter: jitter, RetryAfter: 5 * time.Second, Logger: Logger, jobs: make(map[string]*scheduledJob), nextRuns: make(map[string]time.Time)}
+}
+
+// Add registers a job, it runs once right away when the scheduler starts and then on its schedule
+func (s *Scheduler) Add(name string, schedule Schedule, run func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = &scheduledJob{name: name, schedule: schedule, run: run, wake: make(chan struct{}, 1)}
+}
+
+// Start runs every registered job in its own goroutine until ctx is cancelled, the runs get ctx to stop early
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job *scheduledJob) {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+}
+
+// Wait waits for the loops and the in-flight runs to return after the context is cancelled, returns false on timeout
+func (s *Scheduler) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Trigger runs the job now instead of waiting for its next run, returns false if the job is unknown
+func (s *Scheduler) Trigger(name string) bool {
+	s.mu.Lock()
+	job, exists := s.jobs[name]
+	s.mu.Unlock()
+	if !exists {
+		return false
+	}
+	select {
+	case job.wake <- struct{}{}:
+	default: // already triggered
+	}
+	return true
+}
+
+// NextRuns returns the next run time of each job
+func (s *Scheduler) NextRuns() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make(map[string]time.Time, len(s.nextRuns))
+	for name, at := range s.nextRuns {
+		runs[name] = at
+	}
+	return runs
+}
+
+// next returns the next run of the job with jitter applied
+func (s *Scheduler) next(job *scheduledJob, now time.Time) time.Time {
+	next := job.schedule.Next(now)
+	if next.IsZero() || s.Jitter <= 0 {
+		return next
+	}
+	jitter := s.Jitter
+	if gap := job.schedule.Next(next).Sub(next) / 10; gap < jitter {
+		jitter = gap
+	}
+	if jitter <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(jitter))))
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	done := make(chan error, 1)
+	// the ticks skipped by a long run are only logged once
+	overlapLogged := false
+	start := func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if !job.running.CompareAndSwap(false, true) {
+			if !overlapLogged {
+				s.Logger.Warn("Scheduler", "Status", "Previous run still going, skipping", "job", job.name)
+				overlapLogged = true
+			}
+			return
+		}
+		overlapLogged = false
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			err := job.run(ctx)
+			job.running.Store(false)
+			done <- err
+		}()
+	}
+	// the first run happens on start, like the poll/sync loops always did
+	start()
+	retry := false
+	for {
+		now := time.Now()
+		next := s.next(job, now)
+		if retry && s.RetryAfter > 0 && (next.IsZero() || now.Add(s.RetryAfter).Before(next)) {
+			next = now.Add(s.RetryAfter)
+		}
+		retry = false
+		var timer *time.Timer
+		var tick <-chan time.Time
+		if next.IsZero() {
+			s.Logger.Error("Scheduler", "job", job.name, "Error", "schedule has no next run, the job only runs when triggered")
+			s.mu.Lock()
+			delete(s.nextRuns, job.name)
+			s.mu.Unlock()
+		} else {
+			s.mu.Lock()
+			s.nextRuns[job.name] = next
+			s.mu.Unlock()
+			timer = time.NewTimer(time.Until(next))
+			tick = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-tick:
+			start()
+		case <-job.wake:
+			start()
+		case err := <-done:
+			// the next run is counted from the end of this one
+			if err != nil && ctx.Err() == nil {
+				s.Logger.Error("Scheduler", "job", job.name, "Error", err)
+				retry = true
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02 15:04", clock, time.Local)
+	return t
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "0s", "0 0 30 2 *", "* * *", "61 * * * *", "*/0 * * * *", "5-1 * * * *", "18:00=30s", "25:00-23:00=1m", "18:00-18:00=1m", "18:00-23:00=fast"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{"30s", "2024-05-01 10:00", "2024-05-01 10:00"},
+		{"@every 1h", "2024-05-01 10:00", "2024-05-01 11:00"},
+		{"0 4 * * *", "2024-05-01 10:00", "2024-05-02 04:00"},
+		{"0 4 * * *", "2024-05-01 03:59", "2024-05-01 04:00"},
+		{"@daily", "2024-05-01 10:00", "2024-05-02 00:00"},
+		{"*/15 * * * *", "2024-05-01 10:07", "2024-05-01 10:15"},
+		{"30 9 * * 1-5", "2024-05-03 10:00", "2024-05-06 09:30"}, // friday -> monday
+		{"0 0 1 * 0", "2024-05-02 00:00", "2024-05-05 00:00"},    // dom or dow: first sunday after the 1st
+		{"0 12 29 2 *", "2024-03-01 00:00", "2028-02-29 12:00"},
+		// fast in the evening, slow overnight, nothing during the day
+		{"18:00-23:00=30m,23:00-07:00=2h", "2024-05-01 18:10", "2024-05-01 18:40"},
+		{"18:00-23:00=30m,23:00-07:00=2h", "2024-05-01 23:30", "2024-05-02 01:30"},
+		{"18:00-23:00=30m,23:00-07:00=2h", "2024-05-02 06:30", "2024-05-02 18:00"},
+		{"18:00-23:00=30m,23:00-07:00=2h", "2024-05-01 12:00", "2024-05-01 18:00"},
+		// the fallback interval is cut short by a window starting earlier
+		{"18:00-23:00=1m,*=1h", "2024-05-01 17:30", "2024-05-01 18:00"},
+		{"18:00-23:00=1m,*=1h", "2024-05-01 12:00", "2024-05-01 13:00"},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.spec, err)
+		}
+		want := at(tc.want)
+		if tc.spec == "30s" {
+			want = want.Add(30 * time.Second)
+		}
+		if got := s.Next(at(tc.after)); !got.Equal(want) {
+			t.Errorf("%q after %s: got %s, want %s", tc.spec, tc.after, got.Format("2006-01-02 15:04:05"), want.Format("2006-01-02 15:04:05"))
+		}
+	}
+}
+
+func TestScheduleNextHalfHourZone(t *testing.T) {
+	// UTC+5:30, whole local hours are half UTC hours
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	for _, tc := range []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"0 4 * * *", time.Date(2024, 5, 1, 10, 0, 0, 0, kolkata), time.Date(2024, 5, 2, 4, 0, 0, 0, kolkata)},
+		{"@hourly", time.Date(2024, 5, 1, 10, 15, 0, 0, kolkata), time.Date(2024, 5, 1, 11, 0, 0, 0, kolkata)},
+		{"15 */6 * * *", time.Date(2024, 5, 1, 1, 0, 0, 0, kolkata), time.Date(2024, 5, 1, 6, 15, 0, 0, kolkata)},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.spec, err)
+		}
+		if got := s.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%q after %s: got %s, want %s", tc.spec, tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestSchedulerSkipsWhileRunning(t *testing.T) {
+	var logs bytes.Buffer
+	s := NewScheduler(0, slog.New(slog.NewTextHandler(&logs, nil)))
+	var runs atomic.Int32
+	release := make(chan struct{})
+	s.Add("job", Every(time.Hour), func(ctx context.Context) error {
+		runs.Add(1)
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	waitFor(t, func() bool { return runs.Load() == 1 })
+	// the first run is still going, triggers are skipped
+	s.Trigger("job")
+	time.Sleep(20 * time.Millisecond)
+	s.Trigger("job")
+	time.Sleep(50 * time.Millisecond)
+	if runs.Load() != 1 {
+		t.Fatalf("run started while the previous one was going")
+	}
+	close(release)
+	waitFor(t, func() bool { return !s.jobs["job"].running.Load() })
+	s.Trigger("job")
+	waitFor(t, func() bool { return runs.Load() == 2 })
+	if s.Trigger("missing") {
+		t.Fatal("unknown job triggered")
+	}
+	cancel()
+	if !s.Wait(time.Second) {
+		t.Fatal("scheduler didn't stop")
+	}
+	if skipped := strings.Count(logs.String(), "Previous run still going"); skipped != 1 {
+		t.Fatalf("expected the overlapping run to be logged once, got %d", skipped)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -91,54 +91,49 @@ func (sonarrMedia SonarrMedia) AddTitle(LookupData sonarr.LookupSeriesResponse,
 	return nil
 }
 
-func (sonarrMedia SonarrMedia) HandleExistingTitle(LibraryData []sonarr.GetSeriesResponse, notionPage notion.Result) error {
+// HandleExistingTitle updates the watchlist for a series already in the library and returns the status set
+func (sonarrMedia SonarrMedia) HandleExistingTitle(LibraryData []sonarr.GetSeriesResponse, notionPage notion.Result) (string, error) {
 	qualityProp, rootPathProp, err := sonarrMedia.N.GetNotionQualityAndRootProps(LibraryData[0].QualityProfileID, LibraryData[0].RootFolderPath, constant.MediaTypeTV)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if LibraryData[0].Statistics.PercentOfEpisodes == 100 {
-		sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, "")
-		return nil
+		return constant.MediaStatusDownloaded, sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, "")
 	}
 	//check for download queue
 	queueStatus, err := sonarrMedia.S.GetQueueDetails(LibraryData[0].ID)
 	if err != nil {
-		return errors.Join(errors.New("failed to get queue details in sonarr"), err)
+		return "", errors.Join(errors.New("failed to get queue details in sonarr"), err)
 	}
 	if queueStatus {
-		sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, "")
-		return nil
+		return constant.MediaStatusDownloading, sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, "")
 	}
 
 	// trigger search for series
 	err = sonarrMedia.S.SeriesSearchCommand(LibraryData[0].ID)
 	if err != nil {
-		return errors.Join(errors.New("failed to trigger series search command in sonarr"), err)
+		return "", errors.Join(errors.New("failed to trigger series search command in sonarr"), err)
 	}
-	sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusQueued, qualityProp, rootPathProp, "")
-	return nil
+	return constant.MediaStatusQueued, sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, notionPage.Pgid, false, constant.MediaStatusQueued, qualityProp, rootPathProp, "")
 }
 
-func (sonarrMedia SonarrMedia) ProcessLibraryTitle(watchlistSeries notion.QueryDBIdResponse, sonarrSeries sonarr.GetSeriesResponse) error {
+// LibraryState computes the watchlist state of a series in the library
+//
+// queued - the series has items in the download queue
+func (sonarrMedia SonarrMedia) LibraryState(sonarrSeries sonarr.GetSeriesResponse, queued bool) (notion.DownloadState, error) {
+	status := constant.MediaStatusDownloaded
+	switch {
+	case sonarrSeries.Statistics.PercentOfEpisodes == 100:
+	case queued:
+		status = constant.MediaStatusDownloading
+	default:
+		// the options are cleared
+		return notion.DownloadState{Status: constant.MediaStatusNotDownloaded}, nil
+	}
 	//get rootpath and qualityprofile properties for notion db
 	qualityProp, rootPathProp, err := sonarrMedia.N.GetNotionQualityAndRootProps(sonarrSeries.QualityProfileID, sonarrSeries.RootFolderPath, constant.MediaTypeTV)
 	if err != nil {
-		return errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
-	}
-	if sonarrSeries.Statistics.PercentOfEpisodes == 100 {
-		sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, watchlistSeries.Results[0].Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, "")
-		return nil
-	}
-	//check for queue status
-	queueStatus, err := sonarrMedia.S.GetQueueDetails(sonarrSeries.ID)
-	if err != nil {
-		return errors.Join(errors.New("failed to get queue details in radarr"), err)
-	}
-	if queueStatus {
-		sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, watchlistSeries.Results[0].Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, "")
-		return nil
+		return notion.DownloadState{}, errors.Join(errors.New("failed to get quality and root path profile notion property"), err)
 	}
-	sonarrMedia.N.UpdateDownloadStatus(constant.MediaTypeTV, watchlistSeries.Results[0].Pgid, false, constant.MediaStatusNotDownloaded, qualityProp, rootPathProp, "")
-
-	return nil
+	return notion.DownloadState{Status: status, QualityProfile: qualityProp, RootFolder: rootPathProp}, nil
 }
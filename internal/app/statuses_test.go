@@ -0,0 +1,56 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+func TestUseStatuses(t *testing.T) {
+	defer notion.UseStatuses(nil, nil)
+	path := filepath.Join(t.TempDir(), "statuses.json")
+	err := os.WriteFile(path, []byte(`[{"key": "Downloaded", "label": "✅ Done", "color": "green", "aliases": ["🔵 Downloaded"]}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs, err := notion.LoadStatusDefinitions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notion.UseStatuses(defs, []string{constant.MediaStatusUpgrading})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notion.StatusEnabled(constant.MediaStatusUpgrading) || notion.StatusEnabled(constant.MediaStatusWatched) {
+		t.Fatal("unexpected extra statuses")
+	}
+	// pages still showing the old name are matched by the alias, and not rewritten
+	for _, name := range []string{"✅ Done", "🔵 Downloaded", "downloaded"} {
+		page := watchlistPage(t, "p", "tt1", false, name, "", "")
+		if page.Status() != constant.MediaStatusDownloaded {
+			t.Errorf("%s: got %q", name, page.Status())
+		}
+		if !page.Matches(constant.MediaTypeTV, notion.DownloadState{Status: constant.MediaStatusDownloaded}) {
+			t.Errorf("%s: expected a match", name)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		defs   []notion.StatusDefinition
+		extras []string
+	}{
+		{"unknown key", []notion.StatusDefinition{{Key: "Archived"}}, nil},
+		{"unknown extra", nil, []string{"Archived"}},
+		{"colour", []notion.StatusDefinition{{Key: constant.MediaStatusQueued, Color: "teal"}}, nil},
+		{"group", []notion.StatusDefinition{{Key: constant.MediaStatusQueued, Group: "Later"}}, nil},
+		{"shared alias", []notion.StatusDefinition{{Key: constant.MediaStatusQueued, Aliases: []string{"🔵 Downloaded"}}}, nil},
+	} {
+		if err := notion.UseStatuses(tc.defs, tc.extras); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// SyncSummary is the result of a library sync run
+//
+// Synced - titles in the library and the watchlist, Unchanged - synced titles whose page was already up to date, Skipped - titles only in the library
+type SyncSummary struct {
+	Service   string   `json:"service"`
+	Library   int      `json:"library"`
+	Synced    int      `json:"synced"`
+	Unchanged int      `json:"unchanged"`
+	Skipped   int      `json:"skipped"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+	Duration  string   `json:"duration"`
+}
+
+func (summary *SyncSummary) fail(imdbID string, err error) {
+	summary.Failed++
+	summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %s", imdbID, err))
+}
+
+// add counts the result of a synced title
+func (summary *SyncSummary) add(imdbID string, written bool, err error) {
+	switch {
+	case err != nil:
+		summary.fail(imdbID, err)
+	case !written:
+		summary.Synced++
+		summary.Unchanged++
+	default:
+		summary.Synced++
+	}
+}
+
+// syncFetchError records a failed fetch of the sync run
+func (A *App) syncFetchError(service string, mediaType string, msg string, err error) error {
+	A.recordError(activity.SourceSync, service, mediaType, "", "", err)
+	return errors.Join(errors.New(msg), err)
+}
+
+// watchlistByImdb groups the watchlist pages by IMDb ID, pages without one are left out
+func watchlistByImdb(pages []notion.Result) map[string][]notion.Result {
+	byImdb := make(map[string][]notion.Result, len(pages))
+	for _, page := range pages {
+		if imdbID := pageImdbID(page); imdbID != "" {
+			byImdb[imdbID] = append(byImdb[imdbID], page)
+		}
+	}
+	return byImdb
+}
+
+// keepWatched keeps the Watched status (when enabled) of a page ticked Watched while the title is still downloaded, see the media server webhooks
+func keepWatched(page notion.Result, state notion.DownloadState) notion.DownloadState {
+	if page.Properties.Watched.Checkbox && notion.StatusEnabled(constant.MediaStatusWatched) &&
+		(state.Status == constant.MediaStatusDownloaded || state.Status == constant.MediaStatusUpgraded) {
+		state.Status = constant.MediaStatusWatched
+	}
+	return state
+}
+
+// RadarrSync reconciles the watchlist with the Radarr library, runs never overlap. Stops before the next title once ctx is cancelled
+//
+// The watchlist, queue and collections are fetched once and joined with the library, only the pages that changed are written
+func (A *App) RadarrSync(ctx context.Context) (SyncSummary, error) {
+	A.radarrSyncMu.Lock()
+	defer A.radarrSyncMu.Unlock()
+	A.Logger.Info("RadarrSyncWatchlist", "Status", "Fetching titles from Radarr")
+	start := time.Now()
+	summary := SyncSummary{Service: "radarr"}
+	radarrLibrary, err := A.RadarrMedia.FetchRadarrLibrary()
+	if err != nil {
+		return summary, A.syncFetchError("radarr", constant.MediaTypeMovie, "failed to fetch radarr library", err)
+	}
+	A.Logger.Info("RadarrSyncWatchlist", "Status", "Fetched titles from DB", "No of titles fetched", len(radarrLibrary))
+	summary.Library = len(radarrLibrary)
+	watchlist, err := A.RadarrMedia.N.QueryWatchlist(constant.MediaTypeMovie)
+	if err != nil {
+		return summary, A.syncFetchError("radarr", constant.MediaTypeMovie, "failed to fetch movies from notion watchlist", err)
+	}
+	queued, err := A.RadarrMedia.R.GetQueuedMovies()
+	if err != nil {
+		return summary, A.syncFetchError("radarr", constant.MediaTypeMovie, "failed to fetch radarr queue", err)
+	}
+	collections, err := A.RadarrMedia.R.GetCollections()
+	if err != nil {
+		return summary, A.syncFetchError("radarr", constant.MediaTypeMovie, "failed to fetch radarr collections", err)
+	}
+	pages := watchlistByImdb(watchlist)
+	for _, radarrMovie := range radarrLibrary {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		watchlistPages, found := pages[radarrMovie.ImdbID]
+		if !found {
+			summary.Skipped++
+			continue
+		}
+		written, err := A.syncPages("radarr", constant.MediaTypeMovie, watchlistPages, func(page notion.Result) (bool, error) {
+			return A.radarrSyncMovie(page, radarrMovie, queued[radarrMovie.ID], collections[radarrMovie.Collection.TmdbID])
+		})
+		summary.add(radarrMovie.ImdbID, written, err)
+	}
+	A.Logger.Info("RadarrSyncWatchlist", "Status", "Finished")
+	A.Tracker.Record(activity.Event{Source: activity.SourceSync, Service: "radarr", Type: "finished", Detail: fmt.Sprintf("%d titles synced, %d updated", summary.Synced, summary.Synced-summary.Unchanged)})
+	metrics.SyncDuration.Observe(time.Since(start).Seconds(), "radarr")
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+	return summary, nil
+}
+
+// radarrSyncMovie updates the watchlist page of the movie if it differs from the library, returns whether the page was written
+func (A *App) radarrSyncMovie(page notion.Result, radarrMovie radarr.GetMovieResponse, queued bool, collectionMonitored bool) (bool, error) {
+	metrics.TitlesProcessed.Inc("radarr", "sync")
+	written := false
+	var state notion.DownloadState
+	var err error
+	if page.Status() == constant.MediaStatusAwaitingRelease && radarrMovie.IsAvailable && radarrMovie.Monitored && !radarrMovie.HasFile && !queued {
+		// released since the last run, the search was skipped until now
+		err = A.RadarrMedia.R.MovieSearchCommand(radarrMovie.ID)
+		if err == nil {
+			state, err = A.RadarrMedia.libraryProps(notion.DownloadState{Status: constant.MediaStatusQueued}, radarrMovie, collectionMonitored)
+		}
+	} else {
+		state, err = A.RadarrMedia.LibraryState(radarrMovie, queued, collectionMonitored)
+		state = keepWatched(page, state)
+	}
+	if err == nil {
+		written, err = A.RadarrMedia.N.SyncDownloadStatus(constant.MediaTypeMovie, page, state)
+	}
+	if err != nil {
+		A.Logger.Error("RadarrSyncWatchlist", "Failed to process movie", err)
+		A.recordError(activity.SourceSync, "radarr", constant.MediaTypeMovie, page.Pgid, radarrMovie.ImdbID, err)
+		return written, err
+	}
+	A.Tracker.UpdateTitle(activity.Title{PageID: page.Pgid, ImdbID: radarrMovie.ImdbID, Title: radarrMovie.Title, MediaType: constant.MediaTypeMovie, Status: state.Status, RadarrID: radarrMovie.ID, TmdbID: radarrMovie.TmdbID})
+	return written, nil
+}
+
+// radarrSyncTitle reconciles a single movie with its own queries, returns false if the movie isn't in the watchlist
+func (A *App) radarrSyncTitle(radarrMovie radarr.GetMovieResponse) (bool, error) {
+	watchlistMovie, err := A.RadarrMedia.N.QueryDBImdb(radarrMovie.ImdbID)
+	if err != nil {
+		return false, errors.Join(errors.New("failed to query movie from notion watchlist"), err)
+	}
+	if len(watchlistMovie.Results) == 0 {
+		return false, nil
+	}
+	queued, err := A.RadarrMedia.R.GetQueueDetails(radarrMovie.ID)
+	if err != nil {
+		return true, errors.Join(errors.New("failed to get queue details in radarr"), err)
+	}
+	collectionMonitored := false
+	if radarrMovie.Collection.TmdbID != 0 {
+		collectionMonitored, err = A.RadarrMedia.R.GetCollection(radarrMovie.Collection.TmdbID)
+		if err != nil {
+			return true, errors.Join(errors.New("failed to get movie collection"), err)
+		}
+	}
+	_, err = A.syncPages("radarr", constant.MediaTypeMovie, watchlistMovie.Results, func(page notion.Result) (bool, error) {
+		return A.radarrSyncMovie(page, radarrMovie, queued, collectionMonitored)
+	})
+	return true, err
+}
+
+// SonarrSync reconciles the watchlist with the Sonarr library, runs never overlap. Stops before the next title once ctx is cancelled
+//
+// The watchlist and queue are fetched once and joined with the library, only the pages that changed are written
+func (A *App) SonarrSync(ctx context.Context) (SyncSummary, error) {
+	A.sonarrSyncMu.Lock()
+	defer A.sonarrSyncMu.Unlock()
+	A.Logger.Info("SonarrSyncWatchlist", "Status", "Fetching titles from Sonarr")
+	start := time.Now()
+	summary := SyncSummary{Service: "sonarr"}
+	sonarrLibrary, err := A.SonarrMedia.FetchSonarrLibrary()
+	if err != nil {
+		return summary, A.syncFetchError("sonarr", constant.MediaTypeTV, "failed to fetch sonarr library", err)
+	}
+	A.Logger.Info("SonarrSyncWatchlist", "Status", "Fetched titles from DB", "No of titles fetched", len(sonarrLibrary))
+	summary.Library = len(sonarrLibrary)
+	watchlist, err := A.SonarrMedia.N.QueryWatchlist(constant.MediaTypeTV)
+	if err != nil {
+		return summary, A.syncFetchError("sonarr", constant.MediaTypeTV, "failed to fetch series from notion watchlist", err)
+	}
+	queued, err := A.SonarrMedia.S.GetQueuedSeries()
+	if err != nil {
+		return summary, A.syncFetchError("sonarr", constant.MediaTypeTV, "failed to fetch sonarr queue", err)
+	}
+	pages := watchlistByImdb(watchlist)
+	for _, sonarrSeries := range sonarrLibrary {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		watchlistPages, found := pages[sonarrSeries.ImdbID]
+		if !found {
+			summary.Skipped++
+			continue
+		}
+		written, err := A.syncPages("sonarr", constant.MediaTypeTV, watchlistPages, func(page notion.Result) (bool, error) {
+			return A.sonarrSyncSeries(page, sonarrSeries, queued[sonarrSeries.ID])
+		})
+		summary.add(sonarrSeries.ImdbID, written, err)
+	}
+	A.Logger.Info("SonarrSyncWatchlist", "Status", "Finished")
+	A.Tracker.Record(activity.Event{Source: activity.SourceSync, Service: "sonarr", Type: "finished", Detail: fmt.Sprintf("%d titles synced, %d updated", summary.Synced, summary.Synced-summary.Unchanged)})
+	metrics.SyncDuration.Observe(time.Since(start).Seconds(), "sonarr")
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+	return summary, nil
+}
+
+// sonarrSyncSeries updates the watchlist page of the series if it differs from the library, returns whether the page was written
+func (A *App) sonarrSyncSeries(page notion.Result, sonarrSeries sonarr.GetSeriesResponse, queued bool) (bool, error) {
+	metrics.TitlesProcessed.Inc("sonarr", "sync")
+	written := false
+	state, err := A.SonarrMedia.LibraryState(sonarrSeries, queued)
+	state = keepWatched(page, state)
+	if err == nil {
+		written, err = A.SonarrMedia.N.SyncDownloadStatus(constant.MediaTypeTV, page, state)
+	}
+	if err != nil {
+		A.Logger.Error("SonarrSyncWatchlist", "Failed to process series", err)
+		A.recordError(activity.SourceSync, "sonarr", constant.MediaTypeTV, page.Pgid, sonarrSeries.ImdbID, err)
+		return written, err
+	}
+	A.Tracker.UpdateTitle(activity.Title{PageID: page.Pgid, ImdbID: sonarrSeries.ImdbID, Title: sonarrSeries.Title, MediaType: constant.MediaTypeTV, Status: state.Status, SonarrID: sonarrSeries.ID, TvdbID: sonarrSeries.TvdbID})
+	return written, nil
+}
+
+// sonarrSyncTitle reconciles a single series with its own queries, returns false if the series isn't in the watchlist
+func (A *App) sonarrSyncTitle(sonarrSeries sonarr.GetSeriesResponse) (bool, error) {
+	watchlistSeries, err := A.SonarrMedia.N.QueryDBImdb(sonarrSeries.ImdbID)
+	if err != nil {
+		return false, errors.Join(errors.New("failed to query series from notion watchlist"), err)
+	}
+	if len(watchlistSeries.Results) == 0 {
+		return false, nil
+	}
+	queued, err := A.SonarrMedia.S.GetQueueDetails(sonarrSeries.ID)
+	if err != nil {
+		return true, errors.Join(errors.New("failed to get queue details in sonarr"), err)
+	}
+	_, err = A.syncPages("sonarr", constant.MediaTypeTV, watchlistSeries.Results, func(page notion.Result) (bool, error) {
+		return A.sonarrSyncSeries(page, sonarrSeries, queued)
+	})
+	return true, err
+}
+
+// Sync runs the library sync of the service ("radarr" || "sonarr"), or of every enabled service when empty
+func (A *App) Sync(ctx context.Context, service string) ([]SyncSummary, error) {
+	if service != "" && service != "radarr" && service != "sonarr" {
+		return nil, invalidRequest(`service must be "radarr" or "sonarr"`)
+	}
+	if (service == "radarr" && !A.RadarrInit) || (service == "sonarr" && !A.SonarrInit) {
+		return nil, invalidRequest(service + " is not enabled")
+	}
+	var summaries []SyncSummary
+	var errs error
+	if A.RadarrInit && service != "sonarr" {
+		summary, err := A.RadarrSync(ctx)
+		summaries = append(summaries, summary)
+		errs = errors.Join(errs, err)
+	}
+	if A.SonarrInit && service != "radarr" {
+		summary, err := A.SonarrSync(ctx)
+		summaries = append(summaries, summary)
+		errs = errors.Join(errs, err)
+	}
+	return summaries, errs
+}
+
+// SyncTitle reconciles a single title of the watchlist with the Radarr/Sonarr library
+func (A *App) SyncTitle(imdbID string) (activity.Title, error) {
+	if A.RadarrInit {
+		movie, err := A.RadarrMedia.R.LookupMovie(imdbID)
+		if err == nil && movie.TmdbID != 0 {
+			library, err := A.RadarrMedia.R.GetMovie(movie.TmdbID)
+			if err != nil {
+				return activity.Title{}, errors.Join(errors.New("failed to fetch movie from radarr"), err)
+			}
+			if len(library) != 0 {
+				return A.syncTitleResult(&A.radarrSyncMu, imdbID, func() (bool, error) { return A.radarrSyncTitle(library[0]) })
+			}
+		}
+	}
+	if A.SonarrInit {
+		series, err := A.SonarrMedia.S.LookupSeries(constant.IMDB, imdbID)
+		if err == nil && series.TvdbID != 0 {
+			library, err := A.SonarrMedia.S.GetSeries(series.TvdbID)
+			if err != nil {
+				return activity.Title{}, errors.Join(errors.New("failed to fetch series from sonarr"), err)
+			}
+			if len(library) != 0 {
+				return A.syncTitleResult(&A.sonarrSyncMu, imdbID, func() (bool, error) { return A.sonarrSyncTitle(library[0]) })
+			}
+		}
+	}
+	return activity.Title{}, errors.Join(ErrTitleNotFound, errors.New("title is not in the radarr/sonarr library"))
+}
+
+// syncTitleResult runs the reconcile under the sync lock and returns the tracked state of the title
+func (A *App) syncTitleResult(mu *sync.Mutex, imdbID string, reconcile func() (bool, error)) (activity.Title, error) {
+	mu.Lock()
+	found, err := reconcile()
+	mu.Unlock()
+	if err != nil {
+		return activity.Title{}, err
+	}
+	if !found {
+		return activity.Title{}, errors.Join(ErrTitleNotFound, errors.New("title is not in the watchlist"))
+	}
+	for _, title := range A.Tracker.Titles() {
+		if title.ImdbID == imdbID {
+			return title, nil
+		}
+	}
+	return activity.Title{ImdbID: imdbID}, nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+func watchlistPage(t *testing.T, id string, imdbID string, download bool, status string, quality string, monitor string) notion.Result {
+	t.Helper()
+	data := `{"id": "` + id + `", "properties": {
+		"Download": {"checkbox": ` + map[bool]string{true: "true", false: "false"}[download] + `},
+		"IMDb ID": {"rich_text": [{"plain_text": "` + imdbID + `"}]},
+		"Download Status": {"select": {"name": "` + status + `"}},
+		"Quality Profile": {"select": {"name": "` + quality + `"}},
+		"Root Folder": {"select": null},
+		"Monitor": {"select": {"name": "` + monitor + `"}}}}`
+	var page notion.Result
+	err := json.Unmarshal([]byte(data), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestWatchlistByImdbGroupsPages(t *testing.T) {
+	pages := watchlistByImdb([]notion.Result{
+		watchlistPage(t, "p1", "tt1", false, "", "", ""),
+		watchlistPage(t, "p2", "tt1", false, "", "", ""),
+		watchlistPage(t, "p3", "", false, "", "", ""),
+	})
+	if len(pages) != 1 || len(pages["tt1"]) != 2 || pages["tt1"][0].Pgid != "p1" {
+		t.Fatalf("unexpected index %+v", pages)
+	}
+}
+
+func TestPageMatchesState(t *testing.T) {
+	downloaded := notion.DownloadState{Status: constant.MediaStatusDownloaded, QualityProfile: "Movie: HD-1080p", MonitorProfile: constant.NotionOptionMovieOnly}
+	for _, tc := range []struct {
+		name      string
+		page      notion.Result
+		mediaType string
+		state     notion.DownloadState
+		want      bool
+	}{
+		{"same", watchlistPage(t, "p", "tt1", false, "🔵 Downloaded", "Movie: HD-1080p", constant.NotionOptionMovieOnly), constant.MediaTypeMovie, downloaded, true},
+		{"download ticked", watchlistPage(t, "p", "tt1", true, "🔵 Downloaded", "Movie: HD-1080p", constant.NotionOptionMovieOnly), constant.MediaTypeMovie, downloaded, false},
+		{"status", watchlistPage(t, "p", "tt1", false, "🟢 Downloading", "Movie: HD-1080p", constant.NotionOptionMovieOnly), constant.MediaTypeMovie, downloaded, false},
+		{"monitor", watchlistPage(t, "p", "tt1", false, "🔵 Downloaded", "Movie: HD-1080p", constant.NotionOptionCollection), constant.MediaTypeMovie, downloaded, false},
+		// the monitor of a series is left untouched
+		{"series monitor", watchlistPage(t, "p", "tt1", false, "🔵 Downloaded", "TV Series: HD-1080p", "TV Series: All Episodes"), constant.MediaTypeTV, notion.DownloadState{Status: constant.MediaStatusDownloaded, QualityProfile: "TV Series: HD-1080p"}, true},
+		// not downloaded clears the options
+		{"cleared", watchlistPage(t, "p", "tt1", false, "⚫ Not Downloaded", "", ""), constant.MediaTypeMovie, notion.DownloadState{Status: constant.MediaStatusNotDownloaded}, true},
+		{"not cleared", watchlistPage(t, "p", "tt1", false, "⚫ Not Downloaded", "Movie: HD-1080p", ""), constant.MediaTypeMovie, notion.DownloadState{Status: constant.MediaStatusNotDownloaded}, false},
+	} {
+		if got := tc.page.Matches(tc.mediaType, tc.state); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestStatusPropertyPage(t *testing.T) {
+	var page notion.Result
+	err := json.Unmarshal([]byte(`{"id": "p", "properties": {"Download Status": {"type": "status", "status": {"name": "🔵 Downloaded"}}}}`), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Status() != constant.MediaStatusDownloaded {
+		t.Fatalf("unexpected status %q", page.Status())
+	}
+	if !page.Matches(constant.MediaTypeTV, notion.DownloadState{Status: constant.MediaStatusDownloaded}) {
+		t.Fatal("expected the page to match")
+	}
+}
+
+func TestKeepWatched(t *testing.T) {
+	defer notion.UseStatuses(nil, nil)
+	page := watchlistPage(t, "p1", "tt1", false, "", "", "")
+	page.Properties.Watched.Checkbox = true
+	downloaded := notion.DownloadState{Status: constant.MediaStatusDownloaded}
+	if state := keepWatched(page, downloaded); state.Status != constant.MediaStatusDownloaded {
+		t.Fatalf("Watched status disabled, got %q", state.Status)
+	}
+	err := notion.UseStatuses(nil, []string{constant.MediaStatusWatched})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := keepWatched(page, downloaded); state.Status != constant.MediaStatusWatched {
+		t.Fatalf("expected Watched, got %q", state.Status)
+	}
+	// a watched title being downloaded again isn't hidden
+	if state := keepWatched(page, notion.DownloadState{Status: constant.MediaStatusDownloading}); state.Status != constant.MediaStatusDownloading {
+		t.Fatalf("expected Downloading, got %q", state.Status)
+	}
+}
@@ -5,24 +5,48 @@ import (
 )
 
 type config struct {
-	Port                        string `env:"PORT" envDefault:"7879"`
-	RadarrHost                  string `env:"RADARR_HOST"`
-	RadarrKey                   string `env:"RADARR_KEY"`
-	RadarrInit                  bool   `env:"RADARR_INIT" envDefault:"true"`
-	RadarrDefaultRootPath       string `env:"RADARR_DEFAULT_ROOT_PATH"`
-	RadarrDefaultQualityProfile string `env:"RADARR_DEFAULT_QUALITY_PROFILE"`
-	RadarrDefaultMonitor        string `env:"RADARR_DEFAULT_MONITOR"`
-	SonarrHost                  string `env:"SONARR_HOST"`
-	SonarrKey                   string `env:"SONARR_KEY"`
-	SonarrInit                  bool   `env:"SONARR_INIT" envDefault:"true"`
-	SonarrDefaultRootPath       string `env:"SONARR_DEFAULT_ROOT_PATH"`
-	SonarrDefaultQualityProfile string `env:"SONARR_DEFAULT_QUALITY_PROFILE"`
-	SonarrDefaultMonitor        string `env:"SONARR_DEFAULT_MONITOR"`
-	NotionSecret                string `env:"NOTION_INTEGRATION_SECRET,notEmpty"`
-	NotionDBID                  string `env:"NOTION_DB_ID,notEmpty"`
-	PollInternvalSec            int    `env:"POLL_INTERVAL_SEC" envDefault:"10"`
-	WatchlistSyncIntervalHr     int    `env:"WATCHLIST_SYNC_INTERVAL_HOUR" envDefault:"24"`
-	LogDebug                    bool   `env:"LOG_DEBUG" envDefault:"false"`
+	Port                        string   `env:"PORT" envDefault:"7879"`
+	BindAddress                 string   `env:"BIND_ADDRESS"`
+	WebhookUsername             string   `env:"WEBHOOK_USERNAME"`
+	WebhookPassword             string   `env:"WEBHOOK_PASSWORD"`
+	WebhookToken                string   `env:"WEBHOOK_TOKEN"`
+	AllowUnauthenticatedWebhook bool     `env:"ALLOW_UNAUTHENTICATED_WEBHOOK" envDefault:"false"`
+	PublicURL                   string   `env:"PUBLIC_URL"`
+	RadarrHost                  string   `env:"RADARR_HOST"`
+	RadarrKey                   string   `env:"RADARR_KEY"`
+	RadarrInit                  bool     `env:"RADARR_INIT" envDefault:"true"`
+	RadarrDefaultRootPath       string   `env:"RADARR_DEFAULT_ROOT_PATH"`
+	RadarrDefaultQualityProfile string   `env:"RADARR_DEFAULT_QUALITY_PROFILE"`
+	RadarrDefaultMonitor        string   `env:"RADARR_DEFAULT_MONITOR"`
+	SonarrHost                  string   `env:"SONARR_HOST"`
+	SonarrKey                   string   `env:"SONARR_KEY"`
+	SonarrInit                  bool     `env:"SONARR_INIT" envDefault:"true"`
+	SonarrDefaultRootPath       string   `env:"SONARR_DEFAULT_ROOT_PATH"`
+	SonarrDefaultQualityProfile string   `env:"SONARR_DEFAULT_QUALITY_PROFILE"`
+	SonarrDefaultMonitor        string   `env:"SONARR_DEFAULT_MONITOR"`
+	NotionSecret                string   `env:"NOTION_INTEGRATION_SECRET,notEmpty"`
+	NotionDBID                  string   `env:"NOTION_DB_ID,notEmpty"`
+	PollInternvalSec            int      `env:"POLL_INTERVAL_SEC" envDefault:"10"`
+	WatchlistSyncIntervalHr     int      `env:"WATCHLIST_SYNC_INTERVAL_HOUR" envDefault:"24"`
+	PollSchedule                string   `env:"POLL_SCHEDULE"`
+	SyncSchedule                string   `env:"SYNC_SCHEDULE"`
+	ScheduleJitterSec           int      `env:"SCHEDULE_JITTER_SEC" envDefault:"30"`
+	DataDir                     string   `env:"DATA_DIR" envDefault:"data"`
+	WebhookMaxAttempts          int      `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"10"`
+	ShutdownTimeoutSec          int      `env:"SHUTDOWN_TIMEOUT_SEC" envDefault:"10"`
+	LogDebug                    bool     `env:"LOG_DEBUG" envDefault:"false"`
+	DryRun                      bool     `env:"DRY_RUN" envDefault:"false"`
+	ReportStuckDays             int      `env:"REPORT_STUCK_DAYS" envDefault:"7"`
+	NotionReportPageID          string   `env:"NOTION_REPORT_PAGE_ID"`
+	DuplicatePolicy             string   `env:"DUPLICATE_POLICY" envDefault:"all"`
+	DownloadStatusType          string   `env:"DOWNLOAD_STATUS_TYPE" envDefault:"select"`
+	StatusConfig                string   `env:"STATUS_CONFIG"`
+	ExtraStatuses               []string `env:"EXTRA_STATUSES"`
+	NotionCalendarDBID          string   `env:"NOTION_CALENDAR_DB_ID"`
+	CalendarDays                int      `env:"CALENDAR_DAYS" envDefault:"30"`
+	CalendarSchedule            string   `env:"CALENDAR_SCHEDULE"`
+	NotifyURLs                  []string `env:"NOTIFY_URLS" envSeparator:" "`
+	NotifyTemplate              string   `env:"NOTIFY_TEMPLATE"`
 }
 
 func LoadConfig() (config, error) {
@@ -36,17 +36,34 @@ const (
 	MediaStatusNotDownloaded = "Not Downloaded"
 	MediaStatusQueued        = "Queued"
 	MediaStatusError         = "Error"
+	MediaStatusUpgraded      = "Upgraded"
+	MediaStatusManualImport  = "Needs Manual Import"
+	MediaStatusRemovedKept   = "Removed (files kept)"
+	MediaStatusDuplicate     = "Duplicate"
+	// optional statuses, see notion.UseStatuses
+	MediaStatusAwaitingRelease = "Awaiting Release"
+	MediaStatusUpgrading       = "Upgrading"
+	MediaStatusWatched         = "Watched"
 
-	EventTypeTest            = "Test"
-	EventTypeMovieAdded      = "MovieAdded"
-	EventTypeMovieGrabbed    = "Grab"
-	EventTypeMovieDownloaded = "Download"
-	EventTypeMovieDelete     = "MovieDelete"
-	EventTypeMovieFileDelete = "MovieFileDelete"
-	EventTypeTVAdded         = "SeriesAdd"
-	EventTypeTVGrabbed       = "Grab"
-	EventTypeTVDownloaded    = "Download"
-	EventTypeTVDelete        = "SeriesDelete"
+	EventTypeTest                      = "Test"
+	EventTypeRename                    = "Rename"
+	EventTypeHealth                    = "Health"
+	EventTypeHealthRestored            = "HealthRestored"
+	EventTypeApplicationUpdate         = "ApplicationUpdate"
+	EventTypeManualInteractionRequired = "ManualInteractionRequired"
+	EventTypeMovieAdded                = "MovieAdded"
+	EventTypeMovieGrabbed              = "Grab"
+	EventTypeMovieDownloaded           = "Download"
+	EventTypeMovieDelete               = "MovieDelete"
+	EventTypeMovieFileDelete           = "MovieFileDelete"
+	EventTypeTVAdded                   = "SeriesAdd"
+	EventTypeTVGrabbed                 = "Grab"
+	EventTypeTVDownloaded              = "Download"
+	EventTypeTVDelete                  = "SeriesDelete"
+	EventTypeTVEpisodeFileDelete       = "EpisodeFileDelete"
+
+	// deleteReason sent with MovieFileDelete/EpisodeFileDelete when a file is replaced by a better one
+	DeleteReasonUpgrade = "upgrade"
 
 	IMDB = "imdb"
 	TVDB = "tvdb"
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal Prometheus text exposition, enough for the handful of metrics the app exports.
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// labelKey joins the label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[0], extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+type CounterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]*series
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*series)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := labelKey(labelValues)
+	s, exists := c.values[key]
+	if !exists {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the current count for the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, exists := c.values[labelKey(labelValues)]; exists {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels), formatFloat(s.value))
+	}
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramSeries
+}
+
+// Default buckets (seconds) covering fast API calls up to long library syncs
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(labelValues)
+	s, exists := h.values[key]
+	if !exists {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels), s.count)
+	}
+}
+
+// GaugeFunc reports the value returned by fn at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteText writes every registered metric in the Prometheus text format
+func WriteText(w io.Writer) {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// App metrics
+
+var (
+	PollDuration      = NewHistogramVec("notionwatchlistarr_poll_duration_seconds", "Duration of a watchlist poll.", DefaultBuckets, "service")
+	SyncDuration      = NewHistogramVec("notionwatchlistarr_sync_duration_seconds", "Duration of a library sync.", DefaultBuckets, "service")
+	TitlesProcessed   = NewCounterVec("notionwatchlistarr_titles_processed_total", "Titles processed by poll and sync jobs.", "service", "job")
+	StatusTransitions = NewCounterVec("notionwatchlistarr_status_transitions_total", "Download Status updates written to Notion.", "status")
+	SkippedWrites     = NewCounterVec("notionwatchlistarr_skipped_writes_total", "Download Status writes skipped because the page was already up to date.")
+	WebhookEvents     = NewCounterVec("notionwatchlistarr_webhook_events_total", "Webhook events received.", "service", "event")
+	Requests          = NewCounterVec("notionwatchlistarr_requests_total", "Requests made to Notion/Radarr/Sonarr.", "service", "outcome")
+	RequestDuration   = NewHistogramVec("notionwatchlistarr_request_duration_seconds", "Latency of requests made to Notion/Radarr/Sonarr.", DefaultBuckets, "service")
+)
+
+// ObserveRequest records the outcome and latency of a request to an upstream service
+func ObserveRequest(service string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	Requests.Inc(service, outcome)
+	RequestDuration.Observe(time.Since(start).Seconds(), service)
+}
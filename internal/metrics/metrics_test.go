@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounterVec("test_events_total", "Test events.", "service", "event")
+	c.Inc("radarr", "Grab")
+	c.Inc("radarr", "Grab")
+	c.Inc("sonarr", "Download")
+	h := NewHistogramVec("test_duration_seconds", "Test durations.", []float64{1, 5}, "service")
+	h.Observe(0.5, "notion")
+	h.Observe(3, "notion")
+
+	var buf bytes.Buffer
+	WriteText(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE test_events_total counter",
+		`test_events_total{service="radarr",event="Grab"} 2`,
+		`test_events_total{service="sonarr",event="Download"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{service="notion",le="1"} 1`,
+		`test_duration_seconds_bucket{service="notion",le="5"} 2`,
+		`test_duration_seconds_bucket{service="notion",le="+Inf"} 2`,
+		`test_duration_seconds_sum{service="notion"} 3.5`,
+		`test_duration_seconds_count{service="notion"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in output:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Events a notification is sent for
+const (
+	EventAdded      = "Added"
+	EventGrabbed    = "Grabbed"
+	EventDownloaded = "Downloaded"
+	EventError      = "Error"
+	EventStalled    = "Stalled"
+)
+
+var events = []string{EventAdded, EventGrabbed, EventDownloaded, EventError, EventStalled}
+
+// DefaultTemplate renders the body of the notifications when no template is set
+const DefaultTemplate = `{{.Title}}{{if .Year}} ({{.Year}}){{end}}{{if .Episodes}} {{.Episodes}}{{end}}{{if .Quality}} - {{.Quality}}{{end}}{{if .Error}}
+{{.Error}}{{end}}{{if .URL}}
+{{.URL}}{{end}}`
+
+// Message is a status transition of a watchlist title
+//
+// Quality - the release quality (the quality profile for Added), Episodes - Ex: "S01E01, S01E02", URL - the notion page
+type Message struct {
+	Event     string    `json:"event"`
+	Title     string    `json:"title"`
+	Year      int       `json:"year,omitempty"`
+	MediaType string    `json:"mediaType,omitempty"`
+	Quality   string    `json:"quality,omitempty"`
+	Episodes  string    `json:"episodes,omitempty"`
+	ImdbID    string    `json:"imdbId,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// target is a notification service parsed from its URL
+type target struct {
+	// scheme of the URL, logged instead of the URL which holds the secrets
+	name string
+	// events sent to the target, every event when nil
+	events map[string]bool
+	send   func(client *http.Client, subject string, body string, msg Message) error
+}
+
+// Notifier sends the status transitions to the configured services
+type Notifier struct {
+	targets  []target
+	template *template.Template
+	client   *http.Client
+	Logger   *slog.Logger
+	// DryRun logs the notifications instead of sending them
+	DryRun bool
+	// pending is sent by a single goroutine, done is closed once it returns
+	pending chan Message
+	done    chan struct{}
+	mu      sync.Mutex
+	closed  bool
+}
+
+// maxPending notifications waiting for a send, the next ones are dropped
+const maxPending = 100
+
+// NewNotifier parses the service URLs and the body template (DefaultTemplate when empty). See parseTarget for the URL formats
+func NewNotifier(urls []string, bodyTemplate string, Logger *slog.Logger) (*Notifier, error) {
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultTemplate
+	}
+	tmpl, err := template.New("notification").Parse(bodyTemplate)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid notification template"), err)
+	}
+	n := &Notifier{template: tmpl, client: &http.Client{Timeout: 10 * time.Second}, Logger: Logger}
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+		n.targets = append(n.targets, t)
+	}
+	if len(n.targets) != 0 {
+		n.pending = make(chan Message, maxPending)
+		n.done = make(chan struct{})
+		go n.run()
+	}
+	return n, nil
+}
+
+// Enabled reports whether a service is configured
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.targets) != 0
+}
+
+// Notify queues the message for the services subscribed to its event, it is sent in the background
+// so a slow service doesn't hold the caller. Failures are logged. A nil Notifier sends nothing
+func (n *Notifier) Notify(msg Message) {
+	if !n.Enabled() {
+		return
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		n.Logger.Error("Notify", "Event", msg.Event, "Title", msg.Title, "Error", "notifier closed")
+		return
+	}
+	select {
+	case n.pending <- msg:
+	default:
+		n.Logger.Error("Notify", "Event", msg.Event, "Title", msg.Title, "Error", "too many pending notifications, dropped")
+	}
+}
+
+// Close stops accepting messages and waits up to timeout for the pending ones to be sent, false on timeout
+func (n *Notifier) Close(timeout time.Duration) bool {
+	if !n.Enabled() {
+		return true
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.pending)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for msg := range n.pending {
+		n.send(msg)
+	}
+}
+
+// send delivers the message to the services subscribed to its event
+func (n *Notifier) send(msg Message) {
+	subject, body, err := n.render(msg)
+	if err != nil {
+		n.Logger.Error("Notify", "Event", msg.Event, "Error", err)
+		return
+	}
+	for _, t := range n.targets {
+		if t.events != nil && !t.events[msg.Event] {
+			continue
+		}
+		if n.DryRun {
+			n.Logger.Info("DryRun", "Action", "notify", "Service", t.name, "Subject", subject, "Body", body)
+			continue
+		}
+		err := t.send(n.client, subject, body, msg)
+		if err != nil {
+			n.Logger.Error("Notify", "Service", t.name, "Event", msg.Event, "Error", err)
+		}
+	}
+}
+
+// render returns the subject ("Downloaded: Dune") and the templated body of the message
+func (n *Notifier) render(msg Message) (string, string, error) {
+	var body bytes.Buffer
+	err := n.template.Execute(&body, msg)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s: %s", msg.Event, msg.Title), body.String(), nil
+}
+
+// discordWebhookURL is where discord:// URLs are sent
+var discordWebhookURL = "https://discord.com/api/webhooks/"
+
+// parseTarget reads an Apprise style service URL, ?events=Downloaded,Error limits the events sent to it
+//
+//	json://host/path, jsons://, http(s)://host/path - the message as JSON, with the rendered text in "message"
+//	discord://webhook_id/webhook_token, https://discord.com/api/webhooks/... - Discord webhook
+//	ntfy://topic, ntfy://[user:pass@]host/topic, ntfys:// - ntfy, ntfy.sh when only the topic is given
+//	gotify://host[/path]/token, gotifys:// - Gotify application token
+//	apprise://host[/path]/key, apprises:// - Apprise API, notifies the config stored under key
+func parseTarget(raw string) (target, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return target{}, fmt.Errorf("invalid notification URL %q", redact(raw))
+	}
+	t := target{name: u.Scheme}
+	query := u.Query()
+	if filter := query.Get("events"); filter != "" {
+		t.events = make(map[string]bool)
+		for _, event := range strings.Split(filter, ",") {
+			event = strings.TrimSpace(event)
+			if !validEvent(event) {
+				return target{}, fmt.Errorf("%s notification URL: unknown event %q, expected one of %s", u.Scheme, event, strings.Join(events, ", "))
+			}
+			t.events[event] = true
+		}
+	}
+	query.Del("events")
+	u.RawQuery = query.Encode()
+	path := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "json", "jsons", "http", "https":
+		if u.Host == "discord.com" || u.Host == "discordapp.com" {
+			t.name = "discord"
+			t.send = discordSender(httpScheme(u.Scheme, "https") + "://" + u.Host + u.EscapedPath())
+			return t, nil
+		}
+		t.name = "json"
+		u.Scheme = httpScheme(u.Scheme, "jsons")
+		t.send = jsonSender(u.String())
+	case "discord":
+		if path == "" {
+			return target{}, errors.New("discord notification URL: expected discord://webhook_id/webhook_token")
+		}
+		t.send = discordSender(discordWebhookURL + u.Host + "/" + path)
+	case "ntfy", "ntfys":
+		endpoint := &url.URL{Scheme: httpScheme(u.Scheme, "ntfys"), Host: u.Host, Path: "/" + path, User: u.User}
+		if path == "" {
+			endpoint = &url.URL{Scheme: "https", Host: "ntfy.sh", Path: "/" + u.Host}
+		}
+		t.send = ntfySender(endpoint)
+	case "gotify", "gotifys":
+		prefix, token := splitLast(path)
+		if token == "" {
+			return target{}, errors.New("gotify notification URL: expected gotify://host/token")
+		}
+		endpoint := &url.URL{Scheme: httpScheme(u.Scheme, "gotifys"), Host: u.Host, Path: strings.TrimSuffix("/"+prefix, "/") + "/message", RawQuery: url.Values{"token": {token}}.Encode()}
+		t.send = gotifySender(endpoint.String())
+	case "apprise", "apprises":
+		prefix, key := splitLast(path)
+		if key == "" {
+			return target{}, errors.New("apprise notification URL: expected apprise://host/key")
+		}
+		endpoint := &url.URL{Scheme: httpScheme(u.Scheme, "apprises"), Host: u.Host, Path: strings.TrimSuffix("/"+prefix, "/") + "/notify/" + key, User: u.User}
+		t.send = appriseSender(endpoint.String())
+	default:
+		return target{}, fmt.Errorf("unsupported notification URL scheme %q", u.Scheme)
+	}
+	return t, nil
+}
+
+func validEvent(event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// httpScheme is https for the secure variant of the scheme (and https itself), http otherwise
+func httpScheme(scheme string, secure string) string {
+	if scheme == secure || scheme == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// splitLast splits "a/b/c" into "a/b" and "c"
+func splitLast(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	return path[:i+1], path[i+1:]
+}
+
+// redact drops the credentials and path (tokens) of the URL for the error messages
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparsable>"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// post sends the payload and fails on a non 2xx response
+func post(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func postJSON(client *http.Client, endpoint string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(client, req)
+}
+
+func jsonSender(endpoint string) func(*http.Client, string, string, Message) error {
+	return func(client *http.Client, subject string, body string, msg Message) error {
+		return postJSON(client, endpoint, struct {
+			Message
+			Subject string `json:"subject"`
+			Text    string `json:"message"`
+		}{msg, subject, body})
+	}
+}
+
+func discordSender(endpoint string) func(*http.Client, string, string, Message) error {
+	return func(client *http.Client, subject string, body string, msg Message) error {
+		// discord limits the content to 2000 characters
+		content := fmt.Sprintf("**%s**\n%s", subject, body)
+		if utf8.RuneCountInString(content) > 2000 {
+			content = string([]rune(content)[:2000])
+		}
+		return postJSON(client, endpoint, map[string]string{"content": content})
+	}
+}
+
+func ntfySender(endpoint *url.URL) func(*http.Client, string, string, Message) error {
+	return func(client *http.Client, subject string, body string, msg Message) error {
+		user := endpoint.User
+		target := *endpoint
+		target.User = nil
+		req, err := http.NewRequest(http.MethodPost, target.String(), strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			password, _ := user.Password()
+			req.SetBasicAuth(user.Username(), password)
+		}
+		req.Header.Set("Title", subject)
+		req.Header.Set("Tags", strings.ToLower(msg.Event))
+		if msg.URL != "" {
+			req.Header.Set("Click", msg.URL)
+		}
+		if msg.Event == EventError || msg.Event == EventStalled {
+			req.Header.Set("Priority", "high")
+		}
+		return post(client, req)
+	}
+}
+
+func gotifySender(endpoint string) func(*http.Client, string, string, Message) error {
+	return func(client *http.Client, subject string, body string, msg Message) error {
+		priority := 5
+		if msg.Event == EventError || msg.Event == EventStalled {
+			priority = 8
+		}
+		return postJSON(client, endpoint, map[string]interface{}{"title": subject, "message": body, "priority": priority})
+	}
+}
+
+func appriseSender(endpoint string) func(*http.Client, string, string, Message) error {
+	return func(client *http.Client, subject string, body string, msg Message) error {
+		notifyType := "info"
+		switch msg.Event {
+		case EventDownloaded:
+			notifyType = "success"
+		case EventStalled:
+			notifyType = "warning"
+		case EventError:
+			notifyType = "failure"
+		}
+		return postJSON(client, endpoint, map[string]string{"title": subject, "body": body, "type": notifyType})
+	}
+}
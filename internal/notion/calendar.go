@@ -0,0 +1,233 @@
+package notion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/util"
+)
+
+// CalendarEntry is an upcoming release of the calendar DB
+//
+// Key - identifies the release across runs, Episode - Ex: "S01E02", PageID - the watchlist page of the title
+type CalendarEntry struct {
+	Key     string
+	Title   string
+	Date    time.Time
+	AllDay  bool
+	Episode string
+	Type    string
+	PageID  string
+}
+
+// Equal reports whether the entries would be written the same
+func (e CalendarEntry) Equal(other CalendarEntry) bool {
+	return e.Key == other.Key && e.Title == other.Title && e.Date.Equal(other.Date) && e.AllDay == other.AllDay &&
+		e.Episode == other.Episode && e.Type == other.Type && e.PageID == other.PageID
+}
+
+// CalendarRow is an entry read from the calendar DB
+type CalendarRow struct {
+	ID string
+	CalendarEntry
+}
+
+// AddCalendarProperties adds the properties ( Date, Episode, Type, Watchlist, Key ) to the calendar DB, Watchlist relates the rows to the watchlist DB
+func (n *NotionClient) AddCalendarProperties(dbid string) error {
+	type addCalendarPropertiesPayload struct {
+		Properties struct {
+			Date struct {
+				Type string   `json:"type"`
+				Date struct{} `json:"date"`
+			} `json:"Date"`
+			Episode textProperty `json:"Episode"`
+			Type    struct {
+				Type   string `json:"type"`
+				Select struct {
+					Options []struct {
+						Name string `json:"name"`
+					} `json:"options"`
+				} `json:"select"`
+			} `json:"Type"`
+			Watchlist struct {
+				Type     string `json:"type"`
+				Relation struct {
+					DatabaseID     string   `json:"database_id"`
+					Type           string   `json:"type"`
+					SingleProperty struct{} `json:"single_property"`
+				} `json:"relation"`
+			} `json:"Watchlist"`
+			Key textProperty `json:"Key"`
+		} `json:"properties"`
+	}
+	payload := addCalendarPropertiesPayload{}
+	payload.Properties.Date.Type = "date"
+	payload.Properties.Episode.Type = "rich_text"
+	payload.Properties.Type.Type = "select"
+	for _, mediaType := range []string{constant.MediaTypeMovie, constant.MediaTypeTV} {
+		payload.Properties.Type.Select.Options = append(payload.Properties.Type.Select.Options, struct {
+			Name string `json:"name"`
+		}{Name: mediaType})
+	}
+	payload.Properties.Watchlist.Type = "relation"
+	payload.Properties.Watchlist.Relation.DatabaseID = n.dbid
+	payload.Properties.Watchlist.Relation.Type = "single_property"
+	payload.Properties.Key.Type = "rich_text"
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/databases/%s", dbid), data)
+	return err
+}
+
+// CalendarTitleProperty returns the name of the calendar DB's title property
+func (n *NotionClient) CalendarTitleProperty(dbid string) (string, error) {
+	return n.titleProperty(dbid)
+}
+
+type calendarPage struct {
+	ID         string `json:"id"`
+	Properties map[string]struct {
+		Type  string `json:"type"`
+		Title []struct {
+			PlainText string `json:"plain_text"`
+		} `json:"title"`
+		RichText []struct {
+			PlainText string `json:"plain_text"`
+		} `json:"rich_text"`
+		Date *struct {
+			Start string `json:"start"`
+		} `json:"date"`
+		Select *struct {
+			Name string `json:"name"`
+		} `json:"select"`
+		Relation []struct {
+			ID string `json:"id"`
+		} `json:"relation"`
+	} `json:"properties"`
+}
+
+// row reads the entry of the page, dates are "2006-01-02" (all day) or RFC 3339
+func (p calendarPage) row() CalendarRow {
+	row := CalendarRow{ID: p.ID}
+	for _, prop := range p.Properties {
+		if prop.Type == "title" && len(prop.Title) != 0 {
+			row.Title = prop.Title[0].PlainText
+		}
+	}
+	if key := p.Properties["Key"].RichText; len(key) != 0 {
+		row.Key = key[0].PlainText
+	}
+	if episode := p.Properties["Episode"].RichText; len(episode) != 0 {
+		row.Episode = episode[0].PlainText
+	}
+	if date := p.Properties["Date"].Date; date != nil {
+		if day, err := time.Parse(time.DateOnly, date.Start); err == nil {
+			row.Date, row.AllDay = day, true
+		} else if at, err := time.Parse(time.RFC3339, date.Start); err == nil {
+			row.Date = at
+		}
+	}
+	if mediaType := p.Properties["Type"].Select; mediaType != nil {
+		row.Type = mediaType.Name
+	}
+	if relation := p.Properties["Watchlist"].Relation; len(relation) != 0 {
+		row.PageID = relation[0].ID
+	}
+	return row
+}
+
+// QueryCalendar fetches every row of the calendar DB, following the pagination
+func (n *NotionClient) QueryCalendar(dbid string) ([]CalendarRow, error) {
+	type queryCalendarPayload struct {
+		StartCursor string `json:"start_cursor,omitempty"`
+		PageSize    int    `json:"page_size"`
+	}
+	type queryCalendarResponse struct {
+		Results    []calendarPage `json:"results"`
+		HasMore    bool           `json:"has_more"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	payload := queryCalendarPayload{PageSize: 100}
+	rows := []CalendarRow{}
+	for {
+		data, _ := json.Marshal(payload)
+		_, body, err := n.performNotionReq(http.MethodPost, fmt.Sprintf("v1/databases/%s/query", dbid), data)
+		if err != nil {
+			return nil, err
+		}
+		var qC queryCalendarResponse
+		err = util.ParseJson(body, &qC)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range qC.Results {
+			rows = append(rows, page.row())
+		}
+		if !qC.HasMore || qC.NextCursor == "" {
+			return rows, nil
+		}
+		payload.StartCursor = qC.NextCursor
+	}
+}
+
+// calendarProperties are the props of the entry, titleProp is the name of the calendar DB's title property
+func calendarProperties(titleProp string, entry CalendarEntry) map[string]interface{} {
+	date := entry.Date.UTC().Format(time.RFC3339)
+	if entry.AllDay {
+		date = entry.Date.Format(time.DateOnly)
+	}
+	relation := []map[string]string{}
+	if entry.PageID != "" {
+		relation = append(relation, map[string]string{"id": entry.PageID})
+	}
+	return map[string]interface{}{
+		titleProp:   map[string]interface{}{"title": []map[string]interface{}{{"text": map[string]string{"content": entry.Title}}}},
+		"Date":      dateProperty{Date: &dateValue{Start: date}},
+		"Episode":   richTextProp(entry.Episode),
+		"Type":      selectProp(entry.Type),
+		"Watchlist": map[string]interface{}{"relation": relation},
+		"Key":       richTextProp(entry.Key),
+	}
+}
+
+// CreateCalendarRow adds the entry to the calendar DB
+func (n *NotionClient) CreateCalendarRow(dbid string, titleProp string, entry CalendarEntry) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"parent":     map[string]string{"database_id": dbid},
+		"properties": calendarProperties(titleProp, entry),
+	})
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPost, "v1/pages", data)
+	return err
+}
+
+// UpdateCalendarRow overwrites the row with the entry
+func (n *NotionClient) UpdateCalendarRow(id string, titleProp string, entry CalendarEntry) error {
+	data, err := json.Marshal(map[string]interface{}{"properties": calendarProperties(titleProp, entry)})
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	return err
+}
+
+// DeleteCalendarRow archives the row
+func (n *NotionClient) DeleteCalendarRow(id string) error {
+	data, err := json.Marshal(map[string]bool{"archived": true})
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	if err != nil {
+		return errors.Join(errors.New("failed to archive calendar row"), err)
+	}
+	return nil
+}
@@ -2,15 +2,19 @@ package notion
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
 	"github.com/flxp49/notion-watchlistarr/internal/util"
 )
 
@@ -40,24 +44,59 @@ type NotionClient struct {
 	req    *http.Request
 	Rpid   map[string]string
 	Qpid   map[string]int
+	// DryRun, when set, receives the writes (pages, DB schema) instead of Notion. DB queries are still performed
+	DryRun func(method string, endpoint string, payload []byte)
+	// StatusType is the type of the Download Status property, StatusTypeSelect (default when empty) || StatusTypeStatus
+	StatusType string
+	// BaseURL of the Notion API, replaced by a fake API in tests
+	BaseURL string
 }
 
-func (n *NotionClient) performNotionReq(method string, endpoint string, data []byte) (*http.Response, []byte, error) {
-	n.req.Method = method
-	n.req.URL, _ = url.Parse("https://api.notion.com" + "/" + endpoint)
+// Download Status property types
+const (
+	StatusTypeSelect = "select"
+	// the native status type, the property and its options are created in Notion, see CheckStatusProperty
+	StatusTypeStatus = "status"
+)
+
+// ValidStatusType reports whether t is StatusTypeSelect or StatusTypeStatus
+func ValidStatusType(t string) bool {
+	return t == StatusTypeSelect || t == StatusTypeStatus
+}
+
+// DownloadState is the Download Status of a page with its options
+type DownloadState struct {
+	Status         string `json:"status"`
+	QualityProfile string `json:"qualityProfile,omitempty"`
+	RootFolder     string `json:"rootFolder,omitempty"`
+	MonitorProfile string `json:"monitorProfile,omitempty"`
+	// AvailableFrom is the expected release date (2006-01-02) of an Awaiting Release title
+	AvailableFrom string `json:"availableFrom,omitempty"`
+}
+
+func (n *NotionClient) performNotionReq(method string, endpoint string, data []byte) (resp *http.Response, body []byte, err error) {
+	if n.DryRun != nil && method != http.MethodGet && !strings.HasSuffix(endpoint, "/query") {
+		n.DryRun(method, endpoint, data)
+		return nil, []byte("{}"), nil
+	}
+	defer func(start time.Time) { metrics.ObserveRequest("notion", start, err) }(time.Now())
+	// clone the template request, the client is shared by the poll, sync and webhook goroutines
+	req := n.req.Clone(context.Background())
+	req.Method = method
+	req.URL, _ = url.Parse(n.BaseURL + "/" + endpoint)
 	if method == http.MethodGet {
-		n.req.Body = nil
-		n.req.ContentLength = 0
+		req.Body = nil
+		req.ContentLength = 0
 	} else {
-		n.req.Body = io.NopCloser(bytes.NewBuffer(data))
-		n.req.ContentLength = int64(len(data))
+		req.Body = io.NopCloser(bytes.NewBuffer(data))
+		req.ContentLength = int64(len(data))
 	}
-	resp, err := http.DefaultClient.Do(n.req)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil || (resp.StatusCode < 200 || resp.StatusCode >= 300) {
 		if err == nil {
 			err = errors.New(string(body))
@@ -67,93 +106,474 @@ func (n *NotionClient) performNotionReq(method string, endpoint string, data []b
 	return resp, body, nil
 }
 
-type statusMap struct {
-	name  string
-	color string
+type selectValue struct {
+	Name string `json:"name"`
+}
+
+// statusProperty is the Download Status value, written in the shape of NotionClient.StatusType
+type statusProperty struct {
+	Select *selectValue `json:"select,omitempty"`
+	Status *selectValue `json:"status,omitempty"`
+}
+
+func (n *NotionClient) statusProp(name string) *statusProperty {
+	if n.StatusType == StatusTypeStatus {
+		return &statusProperty{Status: &selectValue{Name: name}}
+	}
+	return &statusProperty{Select: &selectValue{Name: name}}
+}
+
+// selectProperty with a nil Select clears the property
+type selectProperty struct {
+	Select *selectValue `json:"select"`
+}
+
+func selectProp(name string) *selectProperty {
+	return &selectProperty{Select: &selectValue{Name: name}}
+}
+
+// pageProps are the current values of the properties UpdateDownloadStatus writes, status is the notion option name
+type pageProps struct {
+	download       bool
+	status         string
+	qualityProfile string
+	rootFolder     string
+	monitorProfile string
+	availableFrom  string
+}
+
+func (r Result) props() pageProps {
+	p := r.Properties
+	return pageProps{
+		download:       p.Download.Checkbox,
+		status:         r.statusName(),
+		qualityProfile: p.QualityProfile.Select.Name,
+		rootFolder:     p.RootFolder.Select.Name,
+		monitorProfile: p.MonitorProfile.Select.Name,
+		availableFrom:  r.availableFrom(),
+	}
+}
+
+type checkboxProperty struct {
+	Checkbox bool `json:"checkbox"`
+}
+
+type dateValue struct {
+	Start string `json:"start"`
+}
+
+// dateProperty with a nil Date clears the property
+type dateProperty struct {
+	Date *dateValue `json:"date"`
+}
+
+type updateDownloadStatus struct {
+	Properties struct {
+		Download       *checkboxProperty `json:"Download,omitempty"`
+		DStatus        *statusProperty   `json:"Download Status,omitempty"`
+		QualityProfile *selectProperty   `json:"Quality Profile,omitempty"`
+		RootFolder     *selectProperty   `json:"Root Folder,omitempty"`
+		MonitorProfile *selectProperty   `json:"Monitor,omitempty"`
+		AvailableFrom  *dateProperty     `json:"Available From,omitempty"`
+	} `json:"properties"`
+}
+
+// diffSelect sets the property when the wanted option differs from the current one.
+// A nil wanted leaves the property untouched, an empty one clears it
+func diffSelect(prop **selectProperty, current string, wanted *string) bool {
+	if wanted == nil || current == *wanted {
+		return false
+	}
+	*prop = &selectProperty{}
+	if *wanted != "" {
+		*prop = selectProp(*wanted)
+	}
+	return true
 }
 
-var sMap = map[string]statusMap{
-	"Error":          {name: "🔴 Error", color: "red"},
-	"Not Downloaded": {name: "⚫ Not Downloaded", color: "gray"},
-	"Downloading":    {name: "🟢 Downloading", color: "green"},
-	"Downloaded":     {name: "🔵 Downloaded", color: "blue"},
-	"Queued":         {name: "🟡 Queued", color: "yellow"},
+// downloadStatusUpdate builds the payload holding only the properties that differ from current, see UpdateDownloadStatus.
+// Returns the properties of the page after the write and whether anything has to be written
+func downloadStatusUpdate(current pageProps, mediaType string, download bool, state DownloadState) (updateDownloadStatus, pageProps, bool) {
+	status, qualityProfile, rootPath, monitorProfile := state.Status, state.QualityProfile, state.RootFolder, state.MonitorProfile
+	// the options to set, nil leaves the property untouched
+	var quality, root, monitor *string
+	if status == constant.MediaStatusError || status == constant.MediaStatusNotDownloaded {
+		quality, root, monitor = new(string), new(string), new(string)
+	} else {
+		if qualityProfile != "" {
+			quality = &qualityProfile
+		}
+		if rootPath != "" {
+			root = &rootPath
+		}
+		if mediaType == constant.MediaTypeMovie && monitorProfile != "" {
+			monitor = &monitorProfile
+		}
+	}
+	statusName := sMap[status].name
+	payload := updateDownloadStatus{}
+	changed := false
+	if current.download != download {
+		payload.Properties.Download = &checkboxProperty{Checkbox: download}
+		changed = true
+	}
+	// the shape of the status is set on write, see writeDownloadStatus
+	// an alias of the status is left as is
+	if statusKey(current.status) != status {
+		payload.Properties.DStatus = &statusProperty{Select: &selectValue{Name: statusName}}
+		changed = true
+	}
+	changed = diffSelect(&payload.Properties.QualityProfile, current.qualityProfile, quality) || changed
+	changed = diffSelect(&payload.Properties.RootFolder, current.rootFolder, root) || changed
+	changed = diffSelect(&payload.Properties.MonitorProfile, current.monitorProfile, monitor) || changed
+	// the date only belongs to Awaiting Release, it's cleared by the other statuses
+	availableFrom := ""
+	if status == constant.MediaStatusAwaitingRelease {
+		availableFrom = state.AvailableFrom
+	}
+	if current.availableFrom != availableFrom {
+		payload.Properties.AvailableFrom = &dateProperty{}
+		if availableFrom != "" {
+			payload.Properties.AvailableFrom.Date = &dateValue{Start: availableFrom}
+		}
+		changed = true
+	}
+
+	after := current
+	after.download, after.status, after.availableFrom = download, statusName, availableFrom
+	for _, set := range []struct {
+		value  *string
+		target *string
+	}{{quality, &after.qualityProfile}, {root, &after.rootFolder}, {monitor, &after.monitorProfile}} {
+		if set.value != nil {
+			*set.target = *set.value
+		}
+	}
+	return payload, after, changed
 }
 
-// updateDownloadStatus function updates the "Download Status" prop
+// statusName is the option of the Download Status, whether it's a select or a status property
+func (r Result) statusName() string {
+	if r.Properties.DownloadStatus.Status.Name != "" {
+		return r.Properties.DownloadStatus.Status.Name
+	}
+	return r.Properties.DownloadStatus.Select.Name
+}
+
+// Status returns the constant.MediaStatus value of the Download Status, empty when unset or unknown
+func (r Result) Status() string {
+	return statusKey(r.statusName())
+}
+
+// Matches reports whether the page already shows the state with Download unticked
+func (r Result) Matches(mediaType string, state DownloadState) bool {
+	_, _, changed := downloadStatusUpdate(r.props(), mediaType, false, state)
+	return !changed
+}
+
+// UpdateDownloadStatus function updates the "Download Status" prop
 //
 // id - page id to update
 //
 // download - true || false for checkbox property
 //
-// status - "Queued" , "Downloading" , "Downloaded" or "Error"
+// status - one of the constant.MediaStatus values
+//
+// qualityProfile, rootPath, monitorProfile - notion options to set, empty leaves the property untouched.
+// "Error" and "Not Downloaded" clear them instead
 //
 // mediaType - "Movie" || "TV Series"
+//
+// The page is fetched and only the properties that differ are sent, nothing is written when the page is already up to date.
+// Manual edits in Notion are corrected by the next write
 func (n *NotionClient) UpdateDownloadStatus(mediaType string, id string, download bool, status string, qualityProfile string, rootPath string, monitorProfile string) error {
-	type updateDownloadStatus struct {
+	return n.UpdateDownloadState(mediaType, id, download, DownloadState{Status: status, QualityProfile: qualityProfile, RootFolder: rootPath, MonitorProfile: monitorProfile})
+}
+
+// UpdateDownloadState is UpdateDownloadStatus with the state, the Available From date of Awaiting Release is written too
+func (n *NotionClient) UpdateDownloadState(mediaType string, id string, download bool, state DownloadState) error {
+	page, err := n.GetPage(id)
+	if err != nil {
+		return err
+	}
+	_, err = n.writeDownloadStatus(id, page.props(), mediaType, download, state)
+	return err
+}
+
+// SyncDownloadStatus writes the state to a page fetched from the watchlist, returns whether the page was written
+func (n *NotionClient) SyncDownloadStatus(mediaType string, page Result, state DownloadState) (bool, error) {
+	return n.writeDownloadStatus(page.Pgid, page.props(), mediaType, false, state)
+}
+
+func (n *NotionClient) writeDownloadStatus(id string, current pageProps, mediaType string, download bool, state DownloadState) (bool, error) {
+	payload, after, changed := downloadStatusUpdate(current, mediaType, download, state)
+	if changed {
+		if payload.Properties.DStatus != nil {
+			payload.Properties.DStatus = n.statusProp(after.status)
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return false, err
+		}
+		_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+		if err != nil {
+			return false, err
+		}
+		if payload.Properties.DStatus != nil {
+			metrics.StatusTransitions.Inc(state.Status)
+		}
+	} else {
+		metrics.SkippedWrites.Inc()
+	}
+	return changed, nil
+}
+
+// Details of the grabbed/imported release, empty fields are left untouched
+//
+// # Size - in bytes, written to Notion in GB
+//
+// Episodes - Ex: "S01E01, S01E02"
+type ReleaseInfo struct {
+	Release  string
+	Indexer  string
+	Quality  string
+	Size     int64
+	Episodes string
+}
+
+type richTextContent struct {
+	Content string `json:"content"`
+}
+
+type richTextProperty struct {
+	RichText []struct {
+		Text richTextContent `json:"text"`
+	} `json:"rich_text"`
+}
+
+func richTextProp(content string) *richTextProperty {
+	// notion limits a rich text object to 2000 characters
+	if runes := []rune(content); len(runes) > 2000 {
+		content = string(runes[:2000])
+	}
+	p := &richTextProperty{}
+	p.RichText = append(p.RichText, struct {
+		Text richTextContent `json:"text"`
+	}{Text: richTextContent{Content: content}})
+	return p
+}
+
+type numberProperty struct {
+	Number float64 `json:"number"`
+}
+
+// UpdateReleaseInfo writes the Release, Indexer, Quality, Size and Episodes props
+//
+// id - page id to update
+func (n *NotionClient) UpdateReleaseInfo(id string, info ReleaseInfo) error {
+	type updateReleaseInfo struct {
+		Properties struct {
+			Release  *richTextProperty `json:"Release,omitempty"`
+			Indexer  *richTextProperty `json:"Indexer,omitempty"`
+			Quality  *selectProperty   `json:"Quality,omitempty"`
+			Size     *numberProperty   `json:"Size,omitempty"`
+			Episodes *richTextProperty `json:"Episodes,omitempty"`
+		} `json:"properties"`
+	}
+	payload := updateReleaseInfo{}
+	if info.Release != "" {
+		payload.Properties.Release = richTextProp(info.Release)
+	}
+	if info.Indexer != "" {
+		payload.Properties.Indexer = richTextProp(info.Indexer)
+	}
+	if info.Quality != "" {
+		payload.Properties.Quality = selectProp(info.Quality)
+	}
+	if info.Size > 0 {
+		payload.Properties.Size = &numberProperty{Number: math.Round(float64(info.Size)/(1<<30)*100) / 100}
+	}
+	if info.Episodes != "" {
+		payload.Properties.Episodes = richTextProp(info.Episodes)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetDownload ticks (or unticks) the Download checkbox of the page
+func (n *NotionClient) SetDownload(id string, download bool) error {
+	type setDownload struct {
 		Properties struct {
 			Download struct {
 				Checkbox bool `json:"checkbox"`
 			}
-			DStatus struct {
-				Select struct {
-					Name string `json:"name"`
-					// Color string `json:"color"`
-				} `json:"select"`
-			} `json:"Download Status"`
-			QualityProfile struct {
-				Select *struct {
-					Name string `json:"name"`
-				} `json:"select"`
-			} `json:"Quality Profile"`
-			RootFolder struct {
-				Select *struct {
-					Name string `json:"name"`
-				} `json:"select"`
-			} `json:"Root Folder"`
-			MonitorProfile *struct {
-				Select *struct {
-					Name string `json:"name"`
-				} `json:"select"`
-			} `json:"Monitor,omitempty"`
 		} `json:"properties"`
 	}
-	payload := updateDownloadStatus{}
-	payload.Properties.DStatus.Select.Name = sMap[status].name
+	payload := setDownload{}
 	payload.Properties.Download.Checkbox = download
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
-	if status == constant.MediaStatusError || status == constant.MediaStatusNotDownloaded {
-		payload.Properties.MonitorProfile = &struct {
-			Select *struct {
-				Name string `json:"name"`
-			} `json:"select"`
-		}{}
-	} else {
-		payload.Properties.QualityProfile = struct {
-			Select *struct {
-				Name string `json:"name"`
-			} `json:"select"`
-		}{Select: &struct {
-			Name string `json:"name"`
-		}{Name: qualityProfile}}
-		payload.Properties.RootFolder = struct {
-			Select *struct {
-				Name string `json:"name"`
-			} `json:"select"`
-		}{Select: &struct {
-			Name string `json:"name"`
-		}{Name: rootPath}}
-		if mediaType == constant.MediaTypeMovie {
-			payload.Properties.MonitorProfile = &struct {
-				Select *struct {
-					Name string `json:"name"`
-				} `json:"select"`
-			}{Select: &struct {
-				Name string `json:"name"`
-			}{Name: monitorProfile}}
+// MarkDuplicate unticks Download on the page and flags it as a Duplicate of the canonical page
+func (n *NotionClient) MarkDuplicate(id string, canonicalURL string) error {
+	type markDuplicate struct {
+		Properties struct {
+			Download struct {
+				Checkbox bool `json:"checkbox"`
+			}
+			DStatus     *statusProperty `json:"Download Status"`
+			DuplicateOf struct {
+				URL string `json:"url"`
+			} `json:"Duplicate Of"`
+		} `json:"properties"`
+	}
+	payload := markDuplicate{}
+	payload.Properties.DStatus = n.statusProp(sMap[constant.MediaStatusDuplicate].name)
+	payload.Properties.DuplicateOf.URL = canonicalURL
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// titleProperty returns the name of the DB's title property (Name by default, but it can be renamed)
+func (n *NotionClient) titleProperty(dbid string) (string, error) {
+	props, err := n.databaseProperties(dbid)
+	if err != nil {
+		return "", err
+	}
+	for name, prop := range props {
+		if prop.Type == "title" {
+			return name, nil
 		}
 	}
+	return "", errors.New("title property not found in DB")
+}
+
+// downloadProperties ticks Download and sets the non-empty profile props
+func downloadProperties(qualityProfile string, rootFolder string, monitorProfile string) map[string]interface{} {
+	props := map[string]interface{}{
+		"Download": map[string]bool{"checkbox": true},
+	}
+	if qualityProfile != "" {
+		props["Quality Profile"] = selectProp(qualityProfile)
+	}
+	if rootFolder != "" {
+		props["Root Folder"] = selectProp(rootFolder)
+	}
+	if monitorProfile != "" {
+		props["Monitor"] = selectProp(monitorProfile)
+	}
+	return props
+}
+
+// CreatePage adds a title to the watchlist with Download ticked and returns the page id
+//
+// mediaType : Movie || TV Series
+func (n *NotionClient) CreatePage(name string, imdbId string, mediaType string, qualityProfile string, rootFolder string, monitorProfile string) (string, error) {
+	titleProp, err := n.titleProperty(n.dbid)
+	if err != nil {
+		return "", errors.Join(errors.New("failed to fetch DB schema"), err)
+	}
+	props := downloadProperties(qualityProfile, rootFolder, monitorProfile)
+	props[titleProp] = map[string]interface{}{"title": []map[string]interface{}{{"text": map[string]string{"content": name}}}}
+	props["IMDb ID"] = richTextProp(imdbId)
+	props["Type"] = selectProp(mediaType)
+	payload := map[string]interface{}{
+		"parent":     map[string]string{"database_id": n.dbid},
+		"properties": props,
+	}
 	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	_, body, err := n.performNotionReq(http.MethodPost, "v1/pages", data)
+	if err != nil {
+		return "", err
+	}
+	var page Result
+	err = util.ParseJson(body, &page)
+	if err != nil {
+		return "", err
+	}
+	return page.Pgid, nil
+}
+
+// ReportSection is a heading followed by a bulleted list
+type ReportSection struct {
+	Heading string
+	Items   []string
+}
+
+// CreateReportPage adds a page with the sections under the parent page and returns its URL
+func (n *NotionClient) CreateReportPage(parentID string, title string, sections []ReportSection) (string, error) {
+	type block map[string]interface{}
+	textBlock := func(kind string, content string) block {
+		return block{"object": "block", "type": kind, kind: richTextProp(content)}
+	}
+	blocks := []block{}
+	for _, section := range sections {
+		blocks = append(blocks, textBlock("heading_2", section.Heading))
+		for _, item := range section.Items {
+			blocks = append(blocks, textBlock("bulleted_list_item", item))
+		}
+	}
+	// notion accepts up to 100 blocks per request, the rest is appended
+	first := blocks[:min(len(blocks), 100)]
+	payload := map[string]interface{}{
+		"parent":     map[string]string{"page_id": parentID},
+		"properties": map[string]interface{}{"title": map[string]interface{}{"title": []map[string]interface{}{{"text": map[string]string{"content": title}}}}},
+		"children":   first,
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	_, body, err := n.performNotionReq(http.MethodPost, "v1/pages", data)
+	if err != nil {
+		return "", err
+	}
+	var page Result
+	err = util.ParseJson(body, &page)
+	if err != nil {
+		return "", err
+	}
+	for start := len(first); start < len(blocks); start += 100 {
+		data, err := json.Marshal(map[string]interface{}{"children": blocks[start:min(len(blocks), start+100)]})
+		if err != nil {
+			return "", err
+		}
+		_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/blocks/%s/children", page.Pgid), data)
+		if err != nil {
+			return "", errors.Join(errors.New("failed to append to report page"), err)
+		}
+	}
+	return page.URL, nil
+}
+
+// MarkForDownload ticks Download on an existing page and sets the non-empty profile props
+func (n *NotionClient) MarkForDownload(id string, qualityProfile string, rootFolder string, monitorProfile string) error {
+	data, err := json.Marshal(map[string]interface{}{"properties": downloadProperties(qualityProfile, rootFolder, monitorProfile)})
 	if err != nil {
 		return err
 	}
@@ -164,13 +584,35 @@ func (n *NotionClient) UpdateDownloadStatus(mediaType string, id string, downloa
 	return nil
 }
 
+// PageURL links the page by ID
+func PageURL(id string) string {
+	return "https://www.notion.so/" + strings.ReplaceAll(id, "-", "")
+}
+
+// GetPage fetches a single watchlist page
+func (n *NotionClient) GetPage(id string) (Result, error) {
+	_, body, err := n.performNotionReq(http.MethodGet, fmt.Sprintf("v1/pages/%s", id), nil)
+	if err != nil {
+		return Result{}, err
+	}
+	var page Result
+	err = util.ParseJson(body, &page)
+	if err != nil {
+		return Result{}, err
+	}
+	return page, nil
+}
+
 // QueryDB Response struct
 type QueryDBResponse struct {
 	Results []Result `json:"results"`
 }
 type Result struct {
-	Pgid       string `json:"id"`
-	Properties struct {
+	Pgid           string    `json:"id"`
+	URL            string    `json:"url"`
+	CreatedTime    time.Time `json:"created_time"`
+	LastEditedTime time.Time `json:"last_edited_time"`
+	Properties     struct {
 		Download struct {
 			Checkbox bool `json:"checkbox"`
 		}
@@ -194,9 +636,42 @@ type Result struct {
 				Name string `json:"name"`
 			} `json:"select"`
 		} `json:"Monitor"`
+		DownloadStatus struct {
+			Select struct {
+				Name string `json:"name"`
+			} `json:"select"`
+			Status struct {
+				Name string `json:"name"`
+			} `json:"status"`
+		} `json:"Download Status"`
+		DuplicateOf struct {
+			URL string `json:"url"`
+		} `json:"Duplicate Of"`
+		AvailableFrom struct {
+			Date *struct {
+				Start string `json:"start"`
+			} `json:"date"`
+		} `json:"Available From"`
+		Watched struct {
+			Checkbox bool `json:"checkbox"`
+		} `json:"Watched"`
+		EpisodesWatched struct {
+			Rich_text []struct {
+				Plain_text string `json:"plain_text"`
+			} `json:"rich_text"`
+		} `json:"Episodes Watched"`
 	} `json:"properties"`
 }
 
+// availableFrom is the day of the Available From date, empty when unset
+func (r Result) availableFrom() string {
+	date := r.Properties.AvailableFrom.Date
+	if date == nil || len(date.Start) < len("2006-01-02") {
+		return ""
+	}
+	return date.Start[:len("2006-01-02")]
+}
+
 // Query DB for titles to Download where download is checked
 // mtype : Movie || TV Series
 func (n *NotionClient) QueryDB(mtype string) (QueryDBResponse, error) {
@@ -251,21 +726,51 @@ func (n *NotionClient) QueryDB(mtype string) (QueryDBResponse, error) {
 	return qDB, nil
 }
 
+// QueryWatchlist fetches every page of the media type, following the pagination
+//
+// mtype - "Movie" || "TV Series"
+func (n *NotionClient) QueryWatchlist(mtype string) ([]Result, error) {
+	type queryWatchlistPayload struct {
+		Filter struct {
+			Property string `json:"property"`
+			Select   struct {
+				Equals string `json:"equals"`
+			} `json:"select"`
+		} `json:"filter"`
+		StartCursor string `json:"start_cursor,omitempty"`
+		PageSize    int    `json:"page_size"`
+	}
+	type queryWatchlistResponse struct {
+		Results    []Result `json:"results"`
+		HasMore    bool     `json:"has_more"`
+		NextCursor string   `json:"next_cursor"`
+	}
+	payload := queryWatchlistPayload{PageSize: 100}
+	payload.Filter.Property = "Type"
+	payload.Filter.Select.Equals = mtype
+	pages := []Result{}
+	for {
+		data, _ := json.Marshal(payload)
+		_, body, err := n.performNotionReq(http.MethodPost, fmt.Sprintf("v1/databases/%s/query", n.dbid), data)
+		if err != nil {
+			return nil, err
+		}
+		var qW queryWatchlistResponse
+		err = util.ParseJson(body, &qW)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, qW.Results...)
+		if !qW.HasMore || qW.NextCursor == "" {
+			return pages, nil
+		}
+		payload.StartCursor = qW.NextCursor
+	}
+}
+
 // QueryDBTmdb Response struct
 type QueryDBIdResponse struct {
-	Results []struct {
-		Pgid string `json:"id"`
-		// Properties struct {
-		// 	Tmdbid struct {
-		// 		Number int `json:"number"`
-		// 	} `json:"ID"`
-		// 	Name struct {
-		// 		Title []struct {
-		// 			Plain_text string `json:"plain_text"`
-		// 		} `json:"title"`
-		// 	}
-		// } `json:"properties"`
-	} `json:"results"`
+	Results []Result `json:"results"`
 }
 
 // Query DB for existing titles by TmdbID
@@ -336,12 +841,26 @@ func (n *NotionClient) QueryDBImdb(imdbId string) (QueryDBIdResponse, error) {
 	return qDBI, nil
 }
 
-// addQualityProfiles() adds the properties ( Download, Download Status, Quality Profile ) to the DB.
+type textProperty struct {
+	Type     string   `json:"type"`
+	RichText struct{} `json:"rich_text"`
+}
+
+// UseProfiles sets the Quality Profile / Root Folder options (ex: "Movie: HD-1080p") and their Radarr/Sonarr values
+func (n *NotionClient) UseProfiles(qpid map[string]int, rpid map[string]string) {
+	n.Rpid = rpid
+	n.Qpid = qpid
+}
+
+// addQualityProfiles() adds the properties ( Download, Download Status, Quality Profile, Root Folder, Monitor, the release details, Duplicate Of, Available From and the watched state ) to the DB.
 //
 // profiles : Radarr/Sonarr quality profiles to add
 func (n *NotionClient) AddDBProperties(qpid map[string]int, rpid map[string]string) error {
-	n.Rpid = rpid
-	n.Qpid = qpid
+	n.UseProfiles(qpid, rpid)
+	props, err := n.databaseProperties(n.dbid)
+	if err != nil {
+		return err
+	}
 	type addDBPropertiesPayload struct {
 		Properties struct {
 			QualityProfile struct {
@@ -356,15 +875,16 @@ func (n *NotionClient) AddDBProperties(qpid map[string]int, rpid map[string]stri
 				Type     string   `json:"type"`
 				Checkbox struct{} `json:"checkbox"`
 			} `json:"Download"`
-			DownloadStatus struct {
+			// left out with StatusTypeStatus, the API can't change status properties
+			DownloadStatus *struct {
 				Type   string `json:"type"`
 				Select struct {
 					Options []struct {
 						Name  string `json:"name"`
-						Color string `json:"color"`
+						Color string `json:"color,omitempty"`
 					} `json:"options"`
 				} `json:"select"`
-			} `json:"Download Status"`
+			} `json:"Download Status,omitempty"`
 			RootFolder struct {
 				Type   string `json:"type"`
 				Select struct {
@@ -381,6 +901,36 @@ func (n *NotionClient) AddDBProperties(qpid map[string]int, rpid map[string]stri
 					} `json:"options"`
 				} `json:"select"`
 			} `json:"Monitor"`
+			Release textProperty `json:"Release"`
+			Indexer textProperty `json:"Indexer"`
+			Quality struct {
+				Type   string   `json:"type"`
+				Select struct{} `json:"select"`
+			} `json:"Quality"`
+			Size struct {
+				Type   string `json:"type"`
+				Number struct {
+					Format string `json:"format"`
+				} `json:"number"`
+			} `json:"Size"`
+			Episodes    textProperty `json:"Episodes"`
+			DuplicateOf struct {
+				Type string   `json:"type"`
+				URL  struct{} `json:"url"`
+			} `json:"Duplicate Of"`
+			AvailableFrom struct {
+				Type string   `json:"type"`
+				Date struct{} `json:"date"`
+			} `json:"Available From"`
+			Watched struct {
+				Type     string   `json:"type"`
+				Checkbox struct{} `json:"checkbox"`
+			} `json:"Watched"`
+			WatchedOn struct {
+				Type string   `json:"type"`
+				Date struct{} `json:"date"`
+			} `json:"Watched On"`
+			EpisodesWatched textProperty `json:"Episodes Watched"`
 		} `json:"properties"`
 	}
 	payload := addDBPropertiesPayload{}
@@ -402,16 +952,52 @@ func (n *NotionClient) AddDBProperties(qpid map[string]int, rpid map[string]stri
 			Name string `json:"name"`
 		}{Name: m})
 	}
-	for _, val := range sMap {
-		payload.Properties.DownloadStatus.Select.Options = append(payload.Properties.DownloadStatus.Select.Options, struct {
-			Name  string `json:"name"`
-			Color string `json:"color"`
-		}{Name: val.name, Color: val.color})
+	if n.StatusType != StatusTypeStatus {
+		payload.Properties.DownloadStatus = &struct {
+			Type   string `json:"type"`
+			Select struct {
+				Options []struct {
+					Name  string `json:"name"`
+					Color string `json:"color,omitempty"`
+				} `json:"options"`
+			} `json:"select"`
+		}{Type: "select"}
+		// existing options keep their name and colour, only the missing ones are created with ours
+		var existing []string
+		if prop, exists := props["Download Status"]; exists && prop.Type == "select" {
+			for _, option := range prop.Select.Options {
+				existing = append(existing, option.Name)
+			}
+		}
+		missing := make(map[string]bool)
+		for _, key := range resolveStatusOptions(existing) {
+			missing[key] = true
+		}
+		for _, key := range sortedStatuses() {
+			option := struct {
+				Name  string `json:"name"`
+				Color string `json:"color,omitempty"`
+			}{Name: sMap[key].name}
+			if missing[key] {
+				option.Color = sMap[key].color
+			}
+			payload.Properties.DownloadStatus.Select.Options = append(payload.Properties.DownloadStatus.Select.Options, option)
+		}
 	}
 	payload.Properties.Download.Type = "checkbox"
-	payload.Properties.DownloadStatus.Type = "select"
+	payload.Properties.Release = textProperty{Type: "rich_text"}
+	payload.Properties.Indexer = textProperty{Type: "rich_text"}
+	payload.Properties.Quality.Type = "select"
+	payload.Properties.Size.Type = "number"
+	payload.Properties.Size.Number.Format = "number"
+	payload.Properties.Episodes = textProperty{Type: "rich_text"}
+	payload.Properties.DuplicateOf.Type = "url"
+	payload.Properties.AvailableFrom.Type = "date"
+	payload.Properties.Watched.Type = "checkbox"
+	payload.Properties.WatchedOn.Type = "date"
+	payload.Properties.EpisodesWatched = textProperty{Type: "rich_text"}
 	data, _ := json.Marshal(payload)
-	_, _, err := n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/databases/%s/", n.dbid), data)
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/databases/%s/", n.dbid), data)
 	if err != nil {
 		return err
 	}
@@ -452,8 +1038,14 @@ func (n *NotionClient) GetNotionMonitorProp(monitorProfile string, mtype string)
 	return "", errors.New("invalid monitorProfile id value passed")
 }
 
+// Ping checks that the integration can access the database
+func (n *NotionClient) Ping() error {
+	_, _, err := n.performNotionReq(http.MethodGet, fmt.Sprintf("v1/databases/%s", n.dbid), nil)
+	return err
+}
+
 func InitNotionClient(secret string, dbid string) *NotionClient {
-	n := &NotionClient{secret: secret, dbid: dbid}
+	n := &NotionClient{secret: secret, dbid: dbid, BaseURL: "https://api.notion.com"}
 	n.req, _ = http.NewRequest("", "", nil)
 	n.req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", n.secret))
 	n.req.Header.Add("Notion-Version", "2022-06-28")
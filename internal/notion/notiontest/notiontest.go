@@ -0,0 +1,252 @@
+// Package notiontest provides a fake Notion API for the tests of the packages writing to the watchlist
+package notiontest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+// Request is a request received by the fake API
+type Request struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// Server serves the pages of a watchlist DB, the page writes (PATCH) are applied to the pages
+type Server struct {
+	URL      string
+	mu       sync.Mutex
+	pages    map[string]*page
+	order    []string
+	requests []Request
+}
+
+type page struct {
+	properties map[string]interface{}
+	archived   bool
+	created    time.Time
+	edited     time.Time
+	// archiveOn archives the page when a write of the property arrives
+	archiveOn string
+}
+
+// NewServer starts a fake API, closed at the end of the test
+func NewServer(t testing.TB) *Server {
+	s := &Server{pages: make(map[string]*page)}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+	return s
+}
+
+// Client returns a NotionClient of the DB sending its requests to the fake API
+func (s *Server) Client(dbid string) *notion.NotionClient {
+	n := notion.InitNotionClient("secret", dbid)
+	n.BaseURL = s.URL
+	return n
+}
+
+// AddPage adds a watchlist page with the IMDb ID, created a minute after the previous one. props are extra properties in the API format. Ex:
+//
+//	{"Download Status": {"select": {"name": "🔵 Downloaded"}}}
+func (s *Server) AddPage(id string, imdbID string, props map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	properties := map[string]interface{}{"IMDb ID": map[string]interface{}{"rich_text": []interface{}{map[string]interface{}{"plain_text": imdbID}}}}
+	for name, value := range props {
+		properties[name] = value
+	}
+	created := time.Date(2024, 1, 1, 0, len(s.order), 0, 0, time.UTC)
+	s.pages[id] = &page{properties: properties, created: created, edited: created}
+	s.order = append(s.order, id)
+}
+
+// SetProperty edits a property of the page, as a user would in Notion
+func (s *Server) SetProperty(id string, name string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[id].properties[name] = value
+	s.pages[id].edited = time.Now().UTC()
+}
+
+// Archive removes the page from the watchlist, the API then fails the reads and writes of the page
+func (s *Server) Archive(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[id].archived = true
+}
+
+// ArchiveOnWrite archives the page when a write of the property arrives, as if it was removed between two writes
+func (s *Server) ArchiveOnWrite(id string, property string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[id].archiveOn = property
+}
+
+// Property returns a property of the page as JSON, empty when unset
+func (s *Server) Property(id string, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, exists := s.pages[id]
+	if !exists || p.properties[name] == nil {
+		return ""
+	}
+	data, _ := json.Marshal(p.properties[name])
+	return string(data)
+}
+
+// Option returns the option name of a select or status property of the page
+func (s *Server) Option(id string, name string) string {
+	var prop struct {
+		Select *struct{ Name string } `json:"select"`
+		Status *struct{ Name string } `json:"status"`
+	}
+	json.Unmarshal([]byte(s.Property(id, name)), &prop)
+	switch {
+	case prop.Select != nil:
+		return prop.Select.Name
+	case prop.Status != nil:
+		return prop.Status.Name
+	}
+	return ""
+}
+
+// Text returns the plain text of a rich text property of the page
+func (s *Server) Text(id string, name string) string {
+	var prop struct {
+		RichText []struct {
+			PlainText string `json:"plain_text"`
+		} `json:"rich_text"`
+	}
+	json.Unmarshal([]byte(s.Property(id, name)), &prop)
+	var text strings.Builder
+	for _, t := range prop.RichText {
+		text.WriteString(t.PlainText)
+	}
+	return text.String()
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Writes returns the PATCH requests of the page
+func (s *Server) Writes(id string) []Request {
+	var writes []Request
+	for _, r := range s.Requests() {
+		if r.Method == http.MethodPatch && r.Path == "/v1/pages/"+id {
+			writes = append(writes, r)
+		}
+	}
+	return writes
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: string(body)})
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "databases" && parts[3] == "query":
+		s.query(w, body)
+	case len(parts) == 3 && parts[1] == "pages":
+		p, exists := s.pages[parts[2]]
+		if exists && p.archiveOn != "" && r.Method == http.MethodPatch && strings.Contains(string(body), `"`+p.archiveOn+`"`) {
+			p.archived = true
+		}
+		if !exists || p.archived {
+			http.Error(w, `{"object": "error", "status": 404, "code": "object_not_found"}`, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(s.result(parts[2]))
+		case http.MethodPatch:
+			s.patch(w, parts[2], body)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		// DB schema reads and writes aren't faked
+		w.Write([]byte("{}"))
+	}
+}
+
+func (s *Server) result(id string) map[string]interface{} {
+	p := s.pages[id]
+	return map[string]interface{}{"object": "page", "id": id, "created_time": p.created.Format(time.RFC3339), "last_edited_time": p.edited.Format(time.RFC3339), "properties": p.properties}
+}
+
+// query answers the IMDb ID filter of QueryDBImdb, the other queries get every page
+func (s *Server) query(w http.ResponseWriter, body []byte) {
+	var payload struct {
+		Filter struct {
+			Property string `json:"property"`
+			RichText struct {
+				Equals string `json:"equals"`
+			} `json:"rich_text"`
+		} `json:"filter"`
+	}
+	json.Unmarshal(body, &payload)
+	results := []interface{}{}
+	for _, id := range s.order {
+		if s.pages[id].archived {
+			continue
+		}
+		if payload.Filter.Property == "IMDb ID" {
+			var imdb struct {
+				RichText []struct {
+					PlainText string `json:"plain_text"`
+				} `json:"rich_text"`
+			}
+			data, _ := json.Marshal(s.pages[id].properties["IMDb ID"])
+			json.Unmarshal(data, &imdb)
+			if len(imdb.RichText) == 0 || imdb.RichText[0].PlainText != payload.Filter.RichText.Equals {
+				continue
+			}
+		}
+		results = append(results, s.result(id))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "results": results, "has_more": false})
+}
+
+// patch applies the written properties, rich text is given its plain_text like Notion does
+func (s *Server) patch(w http.ResponseWriter, id string, body []byte) {
+	var payload struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+		Archived   bool                              `json:"archived"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := s.pages[id]
+	for name, value := range payload.Properties {
+		if texts, ok := value["rich_text"].([]interface{}); ok {
+			for _, t := range texts {
+				t := t.(map[string]interface{})
+				if content, ok := t["text"].(map[string]interface{}); ok {
+					t["plain_text"] = content["content"]
+				}
+			}
+		}
+		p.properties[name] = value
+	}
+	p.archived = payload.Archived
+	p.edited = time.Now().UTC()
+	json.NewEncoder(w).Encode(s.result(id))
+}
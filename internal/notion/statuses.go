@@ -0,0 +1,318 @@
+package notion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/util"
+)
+
+// status property groups
+const (
+	groupToDo       = "To-do"
+	groupInProgress = "In progress"
+	groupComplete   = "Complete"
+)
+
+// statusMap is a Download Status option
+//
+// name - the notion option, color - colour of a new select option, group - group of the option when Download Status is a status property,
+// aliases - other names the option is matched by, ex: before it was renamed
+type statusMap struct {
+	name    string
+	color   string
+	group   string
+	aliases []string
+}
+
+// sMap holds the enabled statuses by constant.MediaStatus key, see UseStatuses
+var sMap = defaultStatuses()
+
+func defaultStatuses() map[string]statusMap {
+	return map[string]statusMap{
+		constant.MediaStatusError:         {name: "🔴 Error", color: "red", group: groupToDo},
+		constant.MediaStatusNotDownloaded: {name: "⚫ Not Downloaded", color: "gray", group: groupToDo},
+		constant.MediaStatusDownloading:   {name: "🟢 Downloading", color: "green", group: groupInProgress},
+		constant.MediaStatusDownloaded:    {name: "🔵 Downloaded", color: "blue", group: groupComplete},
+		constant.MediaStatusQueued:        {name: "🟡 Queued", color: "yellow", group: groupToDo},
+		constant.MediaStatusUpgraded:      {name: "🟣 Upgraded", color: "purple", group: groupComplete},
+		constant.MediaStatusManualImport:  {name: "🟠 Needs Manual Import", color: "orange", group: groupInProgress},
+		constant.MediaStatusRemovedKept:   {name: "🟤 Removed (files kept)", color: "brown", group: groupComplete},
+		constant.MediaStatusDuplicate:     {name: "⚪ Duplicate", color: "default", group: groupComplete},
+	}
+}
+
+// extraStatuses are the optional statuses, off unless enabled with UseStatuses
+var extraStatuses = map[string]statusMap{
+	constant.MediaStatusAwaitingRelease: {name: "⏳ Awaiting Release", color: "pink", group: groupToDo},
+	constant.MediaStatusUpgrading:       {name: "🔼 Upgrading", color: "purple", group: groupInProgress},
+	constant.MediaStatusWatched:         {name: "✅ Watched", color: "green", group: groupComplete},
+}
+
+var notionColors = map[string]bool{"default": true, "gray": true, "brown": true, "orange": true, "yellow": true, "green": true, "blue": true, "purple": true, "pink": true, "red": true}
+
+// StatusDefinition customises a Download Status option, empty fields keep the default
+//
+// Key - the constant.MediaStatus value, Group - "To-do" || "In progress" || "Complete", Aliases - other names of an existing option to reuse
+type StatusDefinition struct {
+	Key     string   `json:"key"`
+	Label   string   `json:"label,omitempty"`
+	Color   string   `json:"color,omitempty"`
+	Group   string   `json:"group,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// LoadStatusDefinitions reads the JSON array of definitions at path, none when path is empty
+func LoadStatusDefinitions(path string) ([]StatusDefinition, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []StatusDefinition
+	err = json.Unmarshal(data, &defs)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("invalid status definitions in %s", path), err)
+	}
+	return defs, nil
+}
+
+// UseStatuses applies the definitions to the default statuses. The extra statuses ("Awaiting Release", "Upgrading", "Watched")
+// are enabled when listed in extras or defined in defs. Called before the client is used
+func UseStatuses(defs []StatusDefinition, extras []string) error {
+	statuses := defaultStatuses()
+	enable := func(key string) error {
+		if _, enabled := statuses[key]; enabled {
+			return nil
+		}
+		extra, exists := extraStatuses[key]
+		if !exists {
+			return fmt.Errorf("unknown status %q", key)
+		}
+		statuses[key] = extra
+		return nil
+	}
+	for _, key := range extras {
+		err := enable(strings.TrimSpace(key))
+		if err != nil {
+			return err
+		}
+	}
+	for _, def := range defs {
+		err := enable(def.Key)
+		if err != nil {
+			return err
+		}
+		status := statuses[def.Key]
+		if def.Label != "" {
+			status.name = def.Label
+		}
+		if def.Color != "" {
+			if !notionColors[def.Color] {
+				return fmt.Errorf("status %q: unknown notion colour %q", def.Key, def.Color)
+			}
+			status.color = def.Color
+		}
+		if def.Group != "" {
+			if def.Group != groupToDo && def.Group != groupInProgress && def.Group != groupComplete {
+				return fmt.Errorf("status %q: group must be %q, %q or %q", def.Key, groupToDo, groupInProgress, groupComplete)
+			}
+			status.group = def.Group
+		}
+		status.aliases = append(status.aliases, def.Aliases...)
+		statuses[def.Key] = status
+	}
+	// an option can only stand for one status
+	owners := make(map[string]string)
+	for key, status := range statuses {
+		for _, name := range append([]string{key, status.name}, status.aliases...) {
+			name = normalizeOption(name)
+			if owner, taken := owners[name]; taken && owner != key {
+				return fmt.Errorf("%q is used by the statuses %q and %q", name, owner, key)
+			}
+			owners[name] = key
+		}
+	}
+	sMap = statuses
+	return nil
+}
+
+// StatusEnabled reports whether the constant.MediaStatus value is one of the enabled statuses
+func StatusEnabled(status string) bool {
+	_, enabled := sMap[status]
+	return enabled
+}
+
+func normalizeOption(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// matches reports whether the option is the name of the status, its key or one of its aliases
+func (s statusMap) matches(key string, option string) bool {
+	option = normalizeOption(option)
+	if option == "" {
+		return false
+	}
+	for _, name := range append([]string{s.name, key}, s.aliases...) {
+		if normalizeOption(name) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// statusKey returns the status the option stands for, empty when none
+func statusKey(option string) string {
+	for key, status := range sMap {
+		if status.matches(key, option) {
+			return key
+		}
+	}
+	return ""
+}
+
+// resolveStatusOptions points the statuses at the existing options they match, so options renamed in Notion (and listed as aliases) keep being used.
+// Returns the statuses without an option
+func resolveStatusOptions(options []string) []string {
+	var missing []string
+	for _, key := range sortedStatuses() {
+		status := sMap[key]
+		found := ""
+		for _, option := range options {
+			if option == status.name {
+				found = option
+				break
+			}
+			if found == "" && status.matches(key, option) {
+				found = option
+			}
+		}
+		if found == "" {
+			missing = append(missing, key)
+			continue
+		}
+		status.name = found
+		sMap[key] = status
+	}
+	return missing
+}
+
+// sortedStatuses returns the sMap keys in a stable order
+func sortedStatuses() []string {
+	statuses := make([]string, 0, len(sMap))
+	for status := range sMap {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	return statuses
+}
+
+// expectedStatusOptions lists the options of each status group
+func expectedStatusOptions() string {
+	var groups []string
+	for _, group := range []string{groupToDo, groupInProgress, groupComplete} {
+		var names []string
+		for _, status := range sortedStatuses() {
+			if sMap[status].group == group {
+				names = append(names, fmt.Sprintf("%q", sMap[status].name))
+			}
+		}
+		groups = append(groups, fmt.Sprintf("%s: %s", group, strings.Join(names, ", ")))
+	}
+	return strings.Join(groups, "; ")
+}
+
+type dbOption struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// dbProperty is a property of the DB schema, Select/Status hold the options of the select/status types
+type dbProperty struct {
+	Type   string `json:"type"`
+	Select struct {
+		Options []dbOption `json:"options"`
+	} `json:"select"`
+	Status struct {
+		Options []dbOption `json:"options"`
+		Groups  []struct {
+			Name      string   `json:"name"`
+			OptionIDs []string `json:"option_ids"`
+		} `json:"groups"`
+	} `json:"status"`
+}
+
+// databaseProperties fetches the schema of the DB
+func (n *NotionClient) databaseProperties(dbid string) (map[string]dbProperty, error) {
+	type getDBResponse struct {
+		Properties map[string]dbProperty `json:"properties"`
+	}
+	_, body, err := n.performNotionReq(http.MethodGet, fmt.Sprintf("v1/databases/%s", dbid), nil)
+	if err != nil {
+		return nil, err
+	}
+	var db getDBResponse
+	err = util.ParseJson(body, &db)
+	if err != nil {
+		return nil, err
+	}
+	return db.Properties, nil
+}
+
+// CheckStatusProperty verifies the Download Status property when StatusType is StatusTypeStatus.
+// The Notion API can't create status properties nor add their options, so the property is made in Notion and reused here.
+// Returns the missing options and the ones outside their group
+func (n *NotionClient) CheckStatusProperty() error {
+	if n.StatusType != StatusTypeStatus {
+		return nil
+	}
+	props, err := n.databaseProperties(n.dbid)
+	if err != nil {
+		return err
+	}
+	prop, exists := props["Download Status"]
+	if !exists || prop.Type != StatusTypeStatus {
+		return fmt.Errorf("create a Download Status property of type status in Notion, with the options %s", expectedStatusOptions())
+	}
+	// option name -> group name
+	groups := make(map[string]string, len(prop.Status.Options))
+	names := make([]string, 0, len(prop.Status.Options))
+	for _, option := range prop.Status.Options {
+		names = append(names, option.Name)
+	}
+	for _, group := range prop.Status.Groups {
+		for _, id := range group.OptionIDs {
+			for _, option := range prop.Status.Options {
+				if option.ID == id {
+					groups[option.Name] = group.Name
+				}
+			}
+		}
+	}
+	missing := make(map[string]bool)
+	for _, key := range resolveStatusOptions(names) {
+		missing[key] = true
+	}
+	var problems []string
+	for _, key := range sortedStatuses() {
+		status := sMap[key]
+		switch {
+		case missing[key]:
+			problems = append(problems, fmt.Sprintf("%q is missing from %s", status.name, status.group))
+		case groups[status.name] != status.group:
+			problems = append(problems, fmt.Sprintf("%q is in %s, expected %s", status.name, groups[status.name], status.group))
+		}
+	}
+	if len(problems) != 0 {
+		return errors.New("Download Status options to fix in Notion: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package notion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WatchState is the playback state reported by a media server
+//
+// Watched - ticks Watched, Date - day of the playback, Episodes - the watched episodes of a series, ex: "S01E01-E08, S02E01", left untouched when empty
+type WatchState struct {
+	Watched  bool
+	Date     time.Time
+	Episodes string
+}
+
+// EpisodesWatched returns the Episodes Watched prop of the page
+func (r Result) EpisodesWatched() string {
+	var parts []string
+	for _, text := range r.Properties.EpisodesWatched.Rich_text {
+		parts = append(parts, text.Plain_text)
+	}
+	return strings.Join(parts, "")
+}
+
+// UpdateWatchState writes the Watched, Watched On and Episodes Watched props, Watched is only ever ticked
+func (n *NotionClient) UpdateWatchState(id string, state WatchState) error {
+	type updateWatchState struct {
+		Properties struct {
+			Watched         *checkboxProperty `json:"Watched,omitempty"`
+			WatchedOn       dateProperty      `json:"Watched On"`
+			EpisodesWatched *richTextProperty `json:"Episodes Watched,omitempty"`
+		} `json:"properties"`
+	}
+	payload := updateWatchState{}
+	if state.Watched {
+		payload.Properties.Watched = &checkboxProperty{Checkbox: true}
+	}
+	payload.Properties.WatchedOn.Date = &dateValue{Start: state.Date.Format("2006-01-02")}
+	if state.Episodes != "" {
+		payload.Properties.EpisodesWatched = richTextProp(state.Episodes)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, _, err = n.performNotionReq(http.MethodPatch, fmt.Sprintf("v1/pages/%s", id), data)
+	return err
+}
@@ -0,0 +1,241 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Job is a unit of webhook work persisted until it is processed successfully
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Key         string          `json:"key"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+	Created     time.Time       `json:"created"`
+}
+
+// Handler processes a job, a returned error schedules a retry
+type Handler func(job Job) error
+
+// Queue is a disk backed FIFO with per key ordering and exponential backoff.
+//
+// Jobs sharing a Key are processed strictly in the order they were enqueued, so a
+// failing "Grab" is never overtaken by the "Download" of the same title.
+type Queue struct {
+	mu          sync.Mutex
+	path        string
+	jobs        []Job
+	handlers    map[string]Handler
+	notify      chan struct{}
+	seq         int
+	Logger      *slog.Logger
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewQueue loads the pending jobs from path, creating the file on first write
+//
+// maxAttempts : jobs failing this many times are dropped
+func NewQueue(path string, maxAttempts int, Logger *slog.Logger) (*Queue, error) {
+	q := &Queue{
+		path:        path,
+		handlers:    make(map[string]Handler),
+		notify:      make(chan struct{}, 1),
+		Logger:      Logger,
+		MaxAttempts: maxAttempts,
+		BaseBackoff: 5 * time.Second,
+		MaxBackoff:  10 * time.Minute,
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return q, nil
+		}
+		return nil, err
+	}
+	if len(data) != 0 {
+		err = json.Unmarshal(data, &q.jobs)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to parse queue file %s", path), err)
+		}
+	}
+	return q, nil
+}
+
+// Handle registers the handler for jobs of the given kind
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Enqueue persists a new job, it is only acknowledged once it is on disk
+func (q *Queue) Enqueue(kind string, key string, payload []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	now := time.Now()
+	job := Job{
+		ID:          fmt.Sprintf("%d-%d", now.UnixNano(), q.seq),
+		Kind:        kind,
+		Key:         key,
+		Payload:     json.RawMessage(payload),
+		NextAttempt: now,
+		Created:     now,
+	}
+	q.jobs = append(q.jobs, job)
+	err := q.persist()
+	if err != nil {
+		q.jobs = q.jobs[:len(q.jobs)-1]
+		return err
+	}
+	q.wake()
+	return nil
+}
+
+// Len returns the number of pending jobs
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// Run processes jobs one at a time until ctx is cancelled.
+// The job being processed is finished first, pending jobs stay persisted for the next start.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job, wait, ok := q.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.notify:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		q.process(job)
+	}
+}
+
+// next returns the first due job whose key has no earlier pending job,
+// otherwise how long to wait for the next one
+func (q *Queue) next() (Job, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	wait := time.Minute
+	blocked := make(map[string]bool)
+	for _, job := range q.jobs {
+		if blocked[job.Key] {
+			continue
+		}
+		blocked[job.Key] = true
+		if !job.NextAttempt.After(now) {
+			return job, 0, true
+		}
+		if d := job.NextAttempt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return Job{}, wait, false
+}
+
+func (q *Queue) process(job Job) {
+	q.mu.Lock()
+	handler, exists := q.handlers[job.Kind]
+	q.mu.Unlock()
+	var err error
+	if !exists {
+		err = fmt.Errorf("no handler registered for job kind %q", job.Kind)
+	} else {
+		err = handler(job)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.indexOf(job.ID)
+	if i == -1 {
+		return
+	}
+	if err == nil {
+		q.remove(i)
+	} else {
+		q.jobs[i].Attempts++
+		q.jobs[i].LastError = err.Error()
+		if q.jobs[i].Attempts >= q.MaxAttempts {
+			q.Logger.Error("WebhookQueue", "Status", "Dropping job after max attempts", "kind", job.Kind, "key", job.Key, "attempts", q.jobs[i].Attempts, "Error", err)
+			q.remove(i)
+		} else {
+			q.jobs[i].NextAttempt = time.Now().Add(q.backoff(q.jobs[i].Attempts))
+			q.Logger.Warn("WebhookQueue", "Status", "Job failed, retrying", "kind", job.Kind, "key", job.Key, "attempts", q.jobs[i].Attempts, "next attempt", q.jobs[i].NextAttempt, "Error", err)
+		}
+	}
+	perr := q.persist()
+	if perr != nil {
+		q.Logger.Error("WebhookQueue", "Failed to persist queue", perr)
+	}
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= q.MaxBackoff {
+			return q.MaxBackoff
+		}
+	}
+	return d
+}
+
+func (q *Queue) indexOf(id string) int {
+	for i, job := range q.jobs {
+		if job.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *Queue) remove(i int) {
+	q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// persist atomically rewrites the queue file, q.mu must be held
+func (q *Queue) persist() error {
+	data, err := json.Marshal(q.jobs)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
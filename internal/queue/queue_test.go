@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestQueuePersistsPendingJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := NewQueue(path, 3, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Enqueue("radarr", "radarr:tt1", []byte(`{"eventType":"Grab"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewQueue(path, 3, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Len() != 1 {
+		t.Fatalf("expected 1 pending job after reload, got %d", reloaded.Len())
+	}
+	if string(reloaded.jobs[0].Payload) != `{"eventType":"Grab"}` {
+		t.Fatalf("unexpected payload %s", reloaded.jobs[0].Payload)
+	}
+}
+
+func TestQueueRetriesAndKeepsKeyOrder(t *testing.T) {
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), 3, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.BaseBackoff = time.Millisecond
+	var processed []string
+	failures := 1
+	q.Handle("radarr", func(job Job) error {
+		if string(job.Payload) == `"grab"` && failures > 0 {
+			failures--
+			return errors.New("notion unavailable")
+		}
+		processed = append(processed, string(job.Payload))
+		return nil
+	})
+	q.Enqueue("radarr", "radarr:tt1", []byte(`"grab"`))
+	q.Enqueue("radarr", "radarr:tt1", []byte(`"download"`))
+
+	for i := 0; i < 10 && q.Len() > 0; i++ {
+		job, wait, ok := q.next()
+		if !ok {
+			time.Sleep(wait)
+			continue
+		}
+		q.process(job)
+	}
+	if len(processed) != 2 || processed[0] != `"grab"` || processed[1] != `"download"` {
+		t.Fatalf("expected grab then download, got %v", processed)
+	}
+}
+
+func TestQueueDropsAfterMaxAttempts(t *testing.T) {
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), 2, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.BaseBackoff = time.Millisecond
+	q.Handle("sonarr", func(job Job) error { return errors.New("always fails") })
+	q.Enqueue("sonarr", "sonarr:tt2", []byte(`{}`))
+	for i := 0; i < 10 && q.Len() > 0; i++ {
+		job, wait, ok := q.next()
+		if !ok {
+			time.Sleep(wait)
+			continue
+		}
+		q.process(job)
+	}
+	if q.Len() != 0 {
+		t.Fatalf("expected job to be dropped, %d pending", q.Len())
+	}
+}
+
+func TestQueueRunFinishesJobOnCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := NewQueue(path, 3, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	finished := false
+	q.Handle("radarr", func(job Job) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+		return nil
+	})
+	q.Enqueue("radarr", "radarr:tt1", []byte(`{}`))
+	q.Enqueue("radarr", "radarr:tt1", []byte(`{}`))
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+	if !finished {
+		t.Fatal("in-flight job was interrupted")
+	}
+	reloaded, err := NewQueue(path, 3, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Len() != 1 {
+		t.Fatalf("expected the pending job to stay persisted, got %d", reloaded.Len())
+	}
+}
@@ -0,0 +1,38 @@
+package radarr
+
+import "time"
+
+// Radarr minimum availability values
+const (
+	AvailabilityAnnounced = "announced"
+	AvailabilityInCinemas = "inCinemas"
+	AvailabilityReleased  = "released"
+)
+
+// ExpectedAvailability is when Radarr will consider the movie available for its minimum availability, zero when there's no date yet
+//
+// "released" is the earliest digital/physical release, Radarr falls back to 90 days after the cinema release without one
+func ExpectedAvailability(minimumAvailability string, inCinemas time.Time, digitalRelease time.Time, physicalRelease time.Time) time.Time {
+	switch minimumAvailability {
+	case AvailabilityAnnounced:
+		return time.Time{}
+	case AvailabilityInCinemas:
+		return inCinemas
+	}
+	release := digitalRelease
+	if release.IsZero() || (!physicalRelease.IsZero() && physicalRelease.Before(release)) {
+		release = physicalRelease
+	}
+	if release.IsZero() && !inCinemas.IsZero() {
+		release = inCinemas.AddDate(0, 0, 90)
+	}
+	return release
+}
+
+func (m GetMovieResponse) ExpectedAvailability() time.Time {
+	return ExpectedAvailability(m.MinimumAvailability, m.InCinemas, m.DigitalRelease, m.PhysicalRelease)
+}
+
+func (m MovieLookupResponse) ExpectedAvailability() time.Time {
+	return ExpectedAvailability(m.MinimumAvailability, m.InCinemas, m.DigitalRelease, m.PhysicalRelease)
+}
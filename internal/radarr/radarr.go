@@ -2,6 +2,7 @@ package radarr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
 	"github.com/flxp49/notion-watchlistarr/internal/util"
 )
 
@@ -20,24 +22,33 @@ type RadarrClient struct {
 	DefaultRootPath       string
 	DefaultQualityProfile int
 	DefaultMonitorProfile string
+	// DryRun, when set, receives the writes (adds, updates, commands) instead of Radarr
+	DryRun func(method string, endpoint string, payload []byte)
 }
 
-func (r *RadarrClient) performReq(method string, endpoint string, data []byte) (*http.Response, []byte, error) {
-	r.req.Method = method
-	r.req.URL, _ = url.Parse(r.hostpath + "/api/v3" + endpoint)
+func (r *RadarrClient) performReq(method string, endpoint string, data []byte) (resp *http.Response, body []byte, err error) {
+	if r.DryRun != nil && method != http.MethodGet {
+		r.DryRun(method, endpoint, data)
+		return nil, []byte("{}"), nil
+	}
+	defer func(start time.Time) { metrics.ObserveRequest("radarr", start, err) }(time.Now())
+	// clone the template request, the client is shared by the poll, sync and webhook goroutines
+	req := r.req.Clone(context.Background())
+	req.Method = method
+	req.URL, _ = url.Parse(r.hostpath + "/api/v3" + endpoint)
 	if method == http.MethodGet {
-		r.req.Body = nil
-		r.req.ContentLength = 0
+		req.Body = nil
+		req.ContentLength = 0
 	} else {
-		r.req.Body = io.NopCloser(bytes.NewBuffer(data))
-		r.req.ContentLength = int64(len(data))
+		req.Body = io.NopCloser(bytes.NewBuffer(data))
+		req.ContentLength = int64(len(data))
 	}
-	resp, err := http.DefaultClient.Do(r.req)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil || (resp.StatusCode < 200 || resp.StatusCode >= 300) {
 		if err == nil {
 			err = errors.New(string(body))
@@ -153,6 +164,20 @@ func (r *RadarrClient) LookupMovie(imdbId string) (MovieLookupResponse, error) {
 	return lMBIR, nil
 }
 
+// lookup movie via Radarr by tmdbid
+func (r *RadarrClient) LookupMovieTmdb(tmdbId int) (MovieLookupResponse, error) {
+	_, body, err := r.performReq(http.MethodGet, fmt.Sprintf("/movie/lookup/tmdb?tmdbId=%d", tmdbId), nil)
+	if err != nil {
+		return MovieLookupResponse{}, err
+	}
+	var lMBIR MovieLookupResponse
+	err = util.ParseJson(body, &lMBIR)
+	if err != nil {
+		return MovieLookupResponse{}, err
+	}
+	return lMBIR, nil
+}
+
 // Add the movie to Radarr
 //
 // monitor : "MovieOnly" | "MovieandCollection" | "None"
@@ -369,6 +394,21 @@ func (r *RadarrClient) GetMovie(tmdbId int) ([]GetMovieResponse, error) {
 	return gMR, nil
 }
 
+// GetCalendar fetches the monitored movies with a cinema, digital or physical release between start and end
+func (r *RadarrClient) GetCalendar(start time.Time, end time.Time) ([]GetMovieResponse, error) {
+	query := fmt.Sprintf("/calendar?start=%s&end=%s&unmonitored=false", url.QueryEscape(start.UTC().Format(time.RFC3339)), url.QueryEscape(end.UTC().Format(time.RFC3339)))
+	_, body, err := r.performReq(http.MethodGet, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var movies []GetMovieResponse
+	err = util.ParseJson(body, &movies)
+	if err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
 type GetQueueDetailsResponse struct {
 	TotalRecords int `json:"totalRecords"`
 	Records      []struct {
@@ -396,6 +436,35 @@ func (r *RadarrClient) GetQueueDetails(movieID int) (bool, error) {
 	}
 }
 
+// GetQueuedMovies fetches the whole download queue, returns the IDs of the movies with queue items
+func (r *RadarrClient) GetQueuedMovies() (map[int]bool, error) {
+	type getQueueResponse struct {
+		TotalRecords int `json:"totalRecords"`
+		Records      []struct {
+			MovieID int `json:"movieId"`
+		} `json:"records"`
+	}
+	queued := make(map[int]bool)
+	for page, fetched := 1, 0; ; page++ {
+		_, body, err := r.performReq(http.MethodGet, fmt.Sprintf("/queue?page=%d&pageSize=500", page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var gQR getQueueResponse
+		err = util.ParseJson(body, &gQR)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range gQR.Records {
+			queued[record.MovieID] = true
+		}
+		fetched += len(gQR.Records)
+		if len(gQR.Records) == 0 || fetched >= gQR.TotalRecords {
+			return queued, nil
+		}
+	}
+}
+
 type GetCollectionResponse struct {
 	Title               string `json:"title"`
 	TmdbID              int    `json:"tmdbId"`
@@ -421,6 +490,102 @@ func (r *RadarrClient) GetCollection(tmdbID int) (bool, error) {
 	return gCR[0].Monitored, nil
 }
 
+// GetCollections fetches every collection, returns whether each is monitored by collection TMDb ID
+func (r *RadarrClient) GetCollections() (map[int]bool, error) {
+	_, body, err := r.performReq(http.MethodGet, "/collection", nil)
+	if err != nil {
+		return nil, err
+	}
+	var gCR []GetCollectionResponse
+	err = util.ParseJson(body, &gCR)
+	if err != nil {
+		return nil, err
+	}
+	monitored := make(map[int]bool, len(gCR))
+	for _, collection := range gCR {
+		monitored[collection.TmdbID] = collection.Monitored
+	}
+	return monitored, nil
+}
+
+type NotificationField struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Radarr Connect (notification) resource
+type Notification struct {
+	ID                          int                 `json:"id,omitempty"`
+	Name                        string              `json:"name"`
+	Implementation              string              `json:"implementation"`
+	ConfigContract              string              `json:"configContract"`
+	Fields                      []NotificationField `json:"fields"`
+	Tags                        []int               `json:"tags"`
+	OnGrab                      bool                `json:"onGrab"`
+	OnDownload                  bool                `json:"onDownload"`
+	OnUpgrade                   bool                `json:"onUpgrade"`
+	OnRename                    bool                `json:"onRename"`
+	OnMovieAdded                bool                `json:"onMovieAdded"`
+	OnMovieDelete               bool                `json:"onMovieDelete"`
+	OnMovieFileDelete           bool                `json:"onMovieFileDelete"`
+	OnMovieFileDeleteForUpgrade bool                `json:"onMovieFileDeleteForUpgrade"`
+	OnHealthIssue               bool                `json:"onHealthIssue"`
+	OnHealthRestored            bool                `json:"onHealthRestored"`
+	OnApplicationUpdate         bool                `json:"onApplicationUpdate"`
+	OnManualInteractionRequired bool                `json:"onManualInteractionRequired"`
+	IncludeHealthWarnings       bool                `json:"includeHealthWarnings"`
+}
+
+// Field returns the value of the named field, nil if not present
+func (n Notification) Field(name string) interface{} {
+	for _, f := range n.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Fetch the Connect entries configured in Radarr
+func (r *RadarrClient) GetNotifications() ([]Notification, error) {
+	_, body, err := r.performReq(http.MethodGet, "/notification", nil)
+	if err != nil {
+		return nil, err
+	}
+	var gNR []Notification
+	err = util.ParseJson(body, &gNR)
+	if err != nil {
+		return nil, err
+	}
+	return gNR, nil
+}
+
+// Create a Connect entry, forceSave skips Radarr's test call as our server may not be listening yet
+func (r *RadarrClient) AddNotification(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, _, err = r.performReq(http.MethodPost, "/notification?forceSave=true", data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update an existing Connect entry
+func (r *RadarrClient) UpdateNotification(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, _, err = r.performReq(http.MethodPut, fmt.Sprintf("/notification/%d?forceSave=true", notification.ID), data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Sets the default profiles and fetches the quality, rootpath profiles from radarr
 func (r *RadarrClient) RadarrDefaults(radarrDefaultRootPath string, radarrDefaultQualityProfile string, radarrDefaultMonitorProfile string, rpid map[string]string, qpid map[string]int) error {
 	//set default monitor
@@ -479,6 +644,12 @@ func (r *RadarrClient) RadarrDefaults(radarrDefaultRootPath string, radarrDefaul
 	return nil
 }
 
+// Ping checks that Radarr is reachable and the api key is valid
+func (r *RadarrClient) Ping() error {
+	_, _, err := r.performReq(http.MethodGet, "/system/status", nil)
+	return err
+}
+
 func InitRadarrClient(apikey string, hostpath string) *RadarrClient {
 	r := &RadarrClient{hostpath: hostpath, DefaultRootPath: "", DefaultMonitorProfile: ""}
 	r.req, _ = http.NewRequest("", "", nil)
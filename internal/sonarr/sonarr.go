@@ -2,6 +2,7 @@ package sonarr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
 	"github.com/flxp49/notion-watchlistarr/internal/util"
 )
 
@@ -20,24 +22,33 @@ type SonarrClient struct {
 	DefaultRootPath       string
 	DefaultQualityProfile int
 	DefaultMonitorProfile string
+	// DryRun, when set, receives the writes (adds, updates, commands) instead of Sonarr
+	DryRun func(method string, endpoint string, payload []byte)
 }
 
-func (s *SonarrClient) performReq(method string, endpoint string, data []byte) (*http.Response, []byte, error) {
-	s.req.Method = method
-	s.req.URL, _ = url.Parse(s.hostpath + "/api/v3" + endpoint)
+func (s *SonarrClient) performReq(method string, endpoint string, data []byte) (resp *http.Response, body []byte, err error) {
+	if s.DryRun != nil && method != http.MethodGet {
+		s.DryRun(method, endpoint, data)
+		return nil, []byte("{}"), nil
+	}
+	defer func(start time.Time) { metrics.ObserveRequest("sonarr", start, err) }(time.Now())
+	// clone the template request, the client is shared by the poll, sync and webhook goroutines
+	req := s.req.Clone(context.Background())
+	req.Method = method
+	req.URL, _ = url.Parse(s.hostpath + "/api/v3" + endpoint)
 	if method == http.MethodGet {
-		s.req.Body = nil
-		s.req.ContentLength = 0
+		req.Body = nil
+		req.ContentLength = 0
 	} else {
-		s.req.Body = io.NopCloser(bytes.NewBuffer(data))
-		s.req.ContentLength = int64(len(data))
+		req.Body = io.NopCloser(bytes.NewBuffer(data))
+		req.ContentLength = int64(len(data))
 	}
-	resp, err := http.DefaultClient.Do(s.req)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil || (resp.StatusCode < 200 || resp.StatusCode >= 300) {
 		if err == nil {
 			err = errors.New(string(body))
@@ -337,6 +348,169 @@ func (s *SonarrClient) GetQueueDetails(seriesId int) (bool, error) {
 
 }
 
+// GetQueuedSeries fetches the whole download queue, returns the IDs of the series with queue items
+func (s *SonarrClient) GetQueuedSeries() (map[int]bool, error) {
+	type getQueueResponse struct {
+		TotalRecords int `json:"totalRecords"`
+		Records      []struct {
+			SeriesID int `json:"seriesId"`
+		} `json:"records"`
+	}
+	queued := make(map[int]bool)
+	for page, fetched := 1, 0; ; page++ {
+		_, body, err := s.performReq(http.MethodGet, fmt.Sprintf("/queue?page=%d&pageSize=500", page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var gQR getQueueResponse
+		err = util.ParseJson(body, &gQR)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range gQR.Records {
+			queued[record.SeriesID] = true
+		}
+		fetched += len(gQR.Records)
+		if len(gQR.Records) == 0 || fetched >= gQR.TotalRecords {
+			return queued, nil
+		}
+	}
+}
+
+// CalendarEpisode is an episode of the Sonarr calendar
+type CalendarEpisode struct {
+	ID            int       `json:"id"`
+	SeriesID      int       `json:"seriesId"`
+	SeasonNumber  int       `json:"seasonNumber"`
+	EpisodeNumber int       `json:"episodeNumber"`
+	Title         string    `json:"title"`
+	AirDateUtc    time.Time `json:"airDateUtc"`
+	HasFile       bool      `json:"hasFile"`
+	Monitored     bool      `json:"monitored"`
+	Series        struct {
+		Title  string `json:"title"`
+		Year   int    `json:"year"`
+		ImdbID string `json:"imdbId"`
+		TvdbID int    `json:"tvdbId"`
+	} `json:"series"`
+}
+
+// GetCalendar fetches the monitored episodes airing between start and end, with their series
+func (s *SonarrClient) GetCalendar(start time.Time, end time.Time) ([]CalendarEpisode, error) {
+	query := fmt.Sprintf("/calendar?start=%s&end=%s&unmonitored=false&includeSeries=true", url.QueryEscape(start.UTC().Format(time.RFC3339)), url.QueryEscape(end.UTC().Format(time.RFC3339)))
+	_, body, err := s.performReq(http.MethodGet, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []CalendarEpisode
+	err = util.ParseJson(body, &episodes)
+	if err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+// Episode is an episode of a Sonarr series
+type Episode struct {
+	ID            int `json:"id"`
+	SeriesID      int `json:"seriesId"`
+	TvdbID        int `json:"tvdbId"`
+	SeasonNumber  int `json:"seasonNumber"`
+	EpisodeNumber int `json:"episodeNumber"`
+}
+
+// GetEpisodes fetches the episodes of the series
+func (s *SonarrClient) GetEpisodes(seriesID int) ([]Episode, error) {
+	_, body, err := s.performReq(http.MethodGet, fmt.Sprintf("/episode?seriesId=%d", seriesID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []Episode
+	err = util.ParseJson(body, &episodes)
+	if err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+type NotificationField struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Sonarr Connect (notification) resource
+type Notification struct {
+	ID                            int                 `json:"id,omitempty"`
+	Name                          string              `json:"name"`
+	Implementation                string              `json:"implementation"`
+	ConfigContract                string              `json:"configContract"`
+	Fields                        []NotificationField `json:"fields"`
+	Tags                          []int               `json:"tags"`
+	OnGrab                        bool                `json:"onGrab"`
+	OnDownload                    bool                `json:"onDownload"`
+	OnUpgrade                     bool                `json:"onUpgrade"`
+	OnRename                      bool                `json:"onRename"`
+	OnSeriesAdd                   bool                `json:"onSeriesAdd"`
+	OnSeriesDelete                bool                `json:"onSeriesDelete"`
+	OnEpisodeFileDelete           bool                `json:"onEpisodeFileDelete"`
+	OnEpisodeFileDeleteForUpgrade bool                `json:"onEpisodeFileDeleteForUpgrade"`
+	OnHealthIssue                 bool                `json:"onHealthIssue"`
+	OnHealthRestored              bool                `json:"onHealthRestored"`
+	OnApplicationUpdate           bool                `json:"onApplicationUpdate"`
+	OnManualInteractionRequired   bool                `json:"onManualInteractionRequired"`
+	IncludeHealthWarnings         bool                `json:"includeHealthWarnings"`
+}
+
+// Field returns the value of the named field, nil if not present
+func (n Notification) Field(name string) interface{} {
+	for _, f := range n.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Fetch the Connect entries configured in Sonarr
+func (s *SonarrClient) GetNotifications() ([]Notification, error) {
+	_, body, err := s.performReq(http.MethodGet, "/notification", nil)
+	if err != nil {
+		return nil, err
+	}
+	var gNR []Notification
+	err = util.ParseJson(body, &gNR)
+	if err != nil {
+		return nil, err
+	}
+	return gNR, nil
+}
+
+// Create a Connect entry, forceSave skips Sonarr's test call as our server may not be listening yet
+func (s *SonarrClient) AddNotification(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.performReq(http.MethodPost, "/notification?forceSave=true", data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update an existing Connect entry
+func (s *SonarrClient) UpdateNotification(notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.performReq(http.MethodPut, fmt.Sprintf("/notification/%d?forceSave=true", notification.ID), data)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Sets the default profiles and fetches the quality, rootpath profiles from sonarr
 func (s *SonarrClient) SonarrDefaults(sonarrDefaultRootPath string, sonarrDefaultQualityProfile string, sonarrDefaultMonitorProfile string, rpid map[string]string, qpid map[string]int) error {
 	//set default monitor
@@ -396,6 +570,12 @@ func (s *SonarrClient) SonarrDefaults(sonarrDefaultRootPath string, sonarrDefaul
 	return nil
 }
 
+// Ping checks that Sonarr is reachable and the api key is valid
+func (s *SonarrClient) Ping() error {
+	_, _, err := s.performReq(http.MethodGet, "/system/status", nil)
+	return err
+}
+
 func InitSonarrClient(apikey string, hostpath string) *SonarrClient {
 	s := &SonarrClient{hostpath: hostpath, DefaultRootPath: "", DefaultMonitorProfile: ""}
 	s.req, _ = http.NewRequest("", "", nil)
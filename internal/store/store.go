@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	bolt "go.etcd.io/bbolt"
+)
+
+var titlesBucket = []byte("titles")
+
+// Store keeps the state of the watchlist pages in a BoltDB file, keyed by Notion page ID:
+//
+// titles - the activity.Title of the page (media IDs, last known status and timestamps)
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the store at path
+func Open(path string) (*Store, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	// the timeout fails fast when another instance holds the file lock
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to open store %s", path), err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(titlesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+func (s *Store) LoadTitles() ([]activity.Title, error) {
+	titles := []activity.Title{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(titlesBucket).ForEach(func(k, v []byte) error {
+			var title activity.Title
+			err := json.Unmarshal(v, &title)
+			if err != nil {
+				return errors.Join(fmt.Errorf("failed to parse title %s", k), err)
+			}
+			titles = append(titles, title)
+			return nil
+		})
+	})
+	return titles, err
+}
+
+func (s *Store) SaveTitle(title activity.Title) error {
+	return s.put(titlesBucket, title.PageID, title)
+}
+
+// DeleteTitle drops the page
+func (s *Store) DeleteTitle(pageID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(titlesBucket).Delete([]byte(pageID))
+	})
+}
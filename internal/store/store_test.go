@@ -0,0 +1,47 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+)
+
+func TestStorePersistsTitles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := activity.NewTracker(10)
+	err = tr.Persist(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.UpdateTitle(activity.Title{PageID: "p1", ImdbID: "tt1", Title: "Movie", Status: "Queued", RadarrID: 4})
+	tr.UpdateTitle(activity.Title{PageID: "p2", ImdbID: "tt2", Status: "Downloaded"})
+	tr.Forget("p2")
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tr = activity.NewTracker(10)
+	err = tr.Persist(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := tr.TitlesByImdb("tt1")
+	if len(titles) != 1 {
+		t.Fatalf("unexpected titles %+v", titles)
+	}
+	title := titles[0]
+	if title.PageID != "p1" || title.RadarrID != 4 || title.Status != "Queued" || title.LastTransition.IsZero() {
+		t.Fatalf("unexpected title %+v", title)
+	}
+	if _, ok := tr.Title("p2"); ok {
+		t.Fatal("forgotten title was loaded")
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/app"
+	"github.com/flxp49/notion-watchlistarr/internal/util"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// titlesHandler lists the titles known to the app
+//
+// Optional filters: ?status=Downloaded&type=movie
+func (s *Server) titlesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	mediaType := r.URL.Query().Get("type")
+	titles := make([]activity.Title, 0)
+	for _, t := range s.Tracker.Titles() {
+		if status != "" && !strings.EqualFold(t.Status, status) {
+			continue
+		}
+		if mediaType != "" && !strings.EqualFold(t.MediaType, mediaType) {
+			continue
+		}
+		titles = append(titles, t)
+	}
+	writeJSON(w, http.StatusOK, titles)
+}
+
+// eventsHandler returns the recent events, newest first
+//
+// Optional: ?limit=50
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	events := s.Tracker.Events()
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		if limit < len(events) {
+			events = events[:limit]
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// errorStatus maps the app errors to a response code, anything else is an upstream failure
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, app.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, app.ErrTitleNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// requestHandler adds a title to the watchlist and sends it to Radarr/Sonarr right away
+//
+// Body: {"imdbId": "tt0111161"} | {"tmdbId": 278} | {"tvdbId": 81189}, optional "type", "qualityProfile", "rootFolder", "monitor"
+func (s *Server) requestHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	var req app.TitleRequest
+	err := util.ParseJson(body, &req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+		return
+	}
+	result, err := s.Jobs.RequestTitle(req)
+	if err != nil {
+		s.Logger.Error("Server", "Failed to request title", req, "Error", err)
+		writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if result.Created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, result)
+}
+
+type syncResponse struct {
+	Results []app.SyncSummary `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// syncHandler runs the library sync now and waits for it to finish, a sync already running is waited for first
+//
+// Optional: ?service=radarr|sonarr
+func (s *Server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	summaries, err := s.Jobs.Sync(r.Context(), r.URL.Query().Get("service"))
+	resp := syncResponse{Results: summaries}
+	if resp.Results == nil {
+		resp.Results = make([]app.SyncSummary, 0)
+	}
+	if err != nil {
+		s.Logger.Error("Server", "Failed to sync", err)
+		resp.Error = err.Error()
+		writeJSON(w, errorStatus(err), resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// syncTitleHandler reconciles a single title with the Radarr/Sonarr library
+func (s *Server) syncTitleHandler(w http.ResponseWriter, r *http.Request) {
+	imdbID := r.PathValue("imdbId")
+	title, err := s.Jobs.SyncTitle(imdbID)
+	if err != nil {
+		s.Logger.Error("Server", "Failed to sync title", imdbID, "Error", err)
+		writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, title)
+}
+
+// reportHandler compares the watchlist with the Radarr/Sonarr libraries, see app.Report
+//
+// Optional: ?stuckDays=7&format=json|table
+func (s *Server) reportHandler(w http.ResponseWriter, r *http.Request) {
+	stuckDays := 0
+	if d := r.URL.Query().Get("stuckDays"); d != "" {
+		days, err := strconv.Atoi(d)
+		if err != nil || days <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "stuckDays must be a positive integer"})
+			return
+		}
+		stuckDays = days
+	}
+	report, err := s.Jobs.Report(stuckDays)
+	if err != nil {
+		s.Logger.Error("Server", "Failed to build report", err)
+		writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	if r.URL.Query().Get("format") == "table" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		app.WriteReport(w, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+type publishResponse struct {
+	URL   string `json:"url"`
+	Drift int    `json:"drift"`
+}
+
+// publishReportHandler builds the report and writes it to a new Notion page
+//
+// Optional: ?stuckDays=7
+func (s *Server) publishReportHandler(w http.ResponseWriter, r *http.Request) {
+	stuckDays, _ := strconv.Atoi(r.URL.Query().Get("stuckDays"))
+	report, err := s.Jobs.Report(stuckDays)
+	if err == nil {
+		var url string
+		url, err = s.Jobs.PublishReport(report)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, publishResponse{URL: url, Drift: len(report.Drift)})
+			return
+		}
+	}
+	s.Logger.Error("Server", "Failed to publish report", err)
+	writeJSON(w, errorStatus(err), map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WebhookAuth holds the shared secret(s) the Radarr/Sonarr webhooks have to present.
+//
+// Username/Password - checked against HTTP Basic auth (supported natively by the *arr webhook)
+//
+// Token - checked against the "token" query parameter, the "X-Webhook-Token" header or a Bearer Authorization header
+type WebhookAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (a WebhookAuth) basicEnabled() bool {
+	return a.Username != "" || a.Password != ""
+}
+
+func (a WebhookAuth) tokenEnabled() bool {
+	return a.Token != ""
+}
+
+// Enabled reports whether any secret is configured
+func (a WebhookAuth) Enabled() bool {
+	return a.basicEnabled() || a.tokenEnabled()
+}
+
+// validate checks the request against the configured secrets, either one is enough
+func (a WebhookAuth) validate(r *http.Request) bool {
+	if !a.Enabled() {
+		return true
+	}
+	if a.basicEnabled() {
+		username, password, ok := r.BasicAuth()
+		if ok && secureCompare(username, a.Username) && secureCompare(password, a.Password) {
+			return true
+		}
+	}
+	if a.tokenEnabled() {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			token = r.Header.Get("X-Webhook-Token")
+		}
+		if token == "" {
+			token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token != "" && secureCompare(token, a.Token) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureCompare(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// requireAuth wraps a webhook handler and rejects requests without valid credentials
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.validate(r) {
+			s.Logger.Warn("WebhookAuth", "Status", "Unauthorized request rejected", "path", r.URL.Path, "remote", r.RemoteAddr)
+			if s.auth.basicEnabled() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="notion-watchlistarr"`)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isLoopback reports whether the bind address only accepts local connections
+//
+// An empty address binds all interfaces and is treated as non-loopback
+func isLoopback(bindAddr string) bool {
+	if bindAddr == "" {
+		return false
+	}
+	if strings.EqualFold(bindAddr, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(bindAddr, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
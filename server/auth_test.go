@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookAuthValidate(t *testing.T) {
+	auth := WebhookAuth{Username: "arr", Password: "secret", Token: "t0ken"}
+	cases := []struct {
+		name string
+		fn   func() bool
+		want bool
+	}{
+		{"no credentials", func() bool {
+			return auth.validate(httptest.NewRequest("POST", "/radarr", nil))
+		}, false},
+		{"basic auth", func() bool {
+			r := httptest.NewRequest("POST", "/radarr", nil)
+			r.SetBasicAuth("arr", "secret")
+			return auth.validate(r)
+		}, true},
+		{"wrong password", func() bool {
+			r := httptest.NewRequest("POST", "/radarr", nil)
+			r.SetBasicAuth("arr", "nope")
+			return auth.validate(r)
+		}, false},
+		{"query token", func() bool {
+			return auth.validate(httptest.NewRequest("POST", "/radarr?token=t0ken", nil))
+		}, true},
+		{"header token", func() bool {
+			r := httptest.NewRequest("POST", "/sonarr", nil)
+			r.Header.Set("X-Webhook-Token", "t0ken")
+			return auth.validate(r)
+		}, true},
+		{"bearer token", func() bool {
+			r := httptest.NewRequest("POST", "/sonarr", nil)
+			r.Header.Set("Authorization", "Bearer t0ken")
+			return auth.validate(r)
+		}, true},
+		{"wrong token", func() bool {
+			return auth.validate(httptest.NewRequest("POST", "/radarr?token=other", nil))
+		}, false},
+		{"disabled", func() bool {
+			return WebhookAuth{}.validate(httptest.NewRequest("POST", "/radarr", nil))
+		}, true},
+	}
+	for _, c := range cases {
+		if got := c.fn(); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	cases := map[string]bool{
+		"":          false,
+		"0.0.0.0":   false,
+		"127.0.0.1": true,
+		"::1":       true,
+		"[::1]":     true,
+		"localhost": true,
+		"10.0.0.5":  false,
+	}
+	for addr, want := range cases {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
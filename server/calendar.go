@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+// calendarHandler returns the releases of the watchlist titles as an iCalendar feed
+//
+// Optional: ?days=30 - days ahead (and back) the feed covers, up to 365
+func (s *Server) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days < 1 || days > 365 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 365"})
+			return
+		}
+	}
+	now := time.Now()
+	releases, err := s.Jobs.Releases(now.AddDate(0, 0, -days), now.AddDate(0, 0, days))
+	if err != nil {
+		s.Logger.Error("Calendar", "Error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(icsFeed(releases, now)))
+}
+
+// icsEscape escapes a TEXT value (RFC 5545 3.3.11)
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// icsLine folds the content line at 75 octets (RFC 5545 3.1), without splitting a UTF-8 character
+func icsLine(b *strings.Builder, line string) {
+	// the continuation lines start with a space
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// icsFeed writes the releases as a VCALENDAR, movie releases are all day events and episodes start at their air time
+func icsFeed(releases []notion.CalendarEntry, now time.Time) string {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	for _, line := range []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//notion-watchlistarr//Releases//EN", "CALSCALE:GREGORIAN", "METHOD:PUBLISH", "X-WR-CALNAME:Watchlist releases"} {
+		icsLine(&b, line)
+	}
+	for _, release := range releases {
+		summary := release.Title
+		if release.Type == constant.MediaTypeTV && release.Episode != "" {
+			summary = fmt.Sprintf("%s (%s)", release.Title, release.Episode)
+		}
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, "UID:"+release.Key+"@notion-watchlistarr")
+		icsLine(&b, "DTSTAMP:"+now.UTC().Format(stamp))
+		if release.AllDay {
+			icsLine(&b, "DTSTART;VALUE=DATE:"+release.Date.Format("20060102"))
+			icsLine(&b, "DTEND;VALUE=DATE:"+release.Date.AddDate(0, 0, 1).Format("20060102"))
+		} else {
+			icsLine(&b, "DTSTART:"+release.Date.UTC().Format(stamp))
+		}
+		icsLine(&b, "SUMMARY:"+icsEscape(summary))
+		icsLine(&b, "CATEGORIES:"+icsEscape(release.Type))
+		if release.PageID != "" {
+			icsLine(&b, "URL:"+notion.PageURL(release.PageID))
+		}
+		icsLine(&b, "TRANSP:TRANSPARENT")
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCalendarHandler(t *testing.T) {
+	s := &Server{Jobs: &fakeJobs{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	rec := httptest.NewRecorder()
+	s.calendarHandler(rec, httptest.NewRequest(http.MethodGet, "/calendar.ics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	feed := rec.Body.String()
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:movie:438631:digital@notion-watchlistarr\r\n",
+		"DTSTART;VALUE=DATE:20240510\r\nDTEND;VALUE=DATE:20240511\r\n",
+		"URL:https://www.notion.so/1a2b3c\r\n",
+		"DTSTART:20240511T010000Z\r\n",
+		`SUMMARY:Severance: Hello\, Ms. Cobel (S02E01)` + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(feed, line) {
+			t.Errorf("expected %q in the feed:\n%s", line, feed)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.calendarHandler(rec, httptest.NewRequest(http.MethodGet, "/calendar.ics?days=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for days=0, got %d", rec.Code)
+	}
+}
+
+func TestICSLineFolding(t *testing.T) {
+	var b strings.Builder
+	icsLine(&b, "SUMMARY:"+strings.Repeat("a", 100)+strings.Repeat("é", 40))
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line longer than 75 octets: %q", line)
+		}
+	}
+	if unfolded := strings.ReplaceAll(b.String(), "\r\n ", ""); unfolded != "SUMMARY:"+strings.Repeat("a", 100)+strings.Repeat("é", 40)+"\r\n" {
+		t.Fatalf("unexpected unfolded line %q", unfolded)
+	}
+}
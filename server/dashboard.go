@@ -0,0 +1,89 @@
+package server
+
+import (
+	"embed"
+	"net/http"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+)
+
+//go:embed web
+var web embed.FS
+
+// dashboardHandler serves the embedded web UI
+func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := web.ReadFile("web/index.html")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+type statusResponse struct {
+	Ready       bool                 `json:"ready"`
+	Checks      map[string]string    `json:"checks"`
+	QueueLength int                  `json:"queueLength"`
+	NextRuns    map[string]time.Time `json:"nextRuns"`
+	Active      []activity.Title     `json:"active"`
+	Failed      []activity.Title     `json:"failed"`
+	Errors      []activity.Event     `json:"errors"`
+}
+
+// statusHandler returns everything the dashboard shows in a single call
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	ready := s.readiness()
+	resp := statusResponse{
+		Ready:       ready.Ready,
+		Checks:      ready.Checks,
+		QueueLength: s.Q.Len(),
+		NextRuns:    s.Jobs.NextRuns(),
+		Active:      make([]activity.Title, 0),
+		Failed:      make([]activity.Title, 0),
+		Errors:      make([]activity.Event, 0),
+	}
+	for _, t := range s.Tracker.Titles() {
+		switch t.Status {
+		case constant.MediaStatusQueued, constant.MediaStatusDownloading, constant.MediaStatusManualImport:
+			resp.Active = append(resp.Active, t)
+		case constant.MediaStatusError:
+			resp.Failed = append(resp.Failed, t)
+		}
+	}
+	for _, e := range s.Tracker.Events() {
+		if e.Error == "" {
+			continue
+		}
+		resp.Errors = append(resp.Errors, e)
+		if len(resp.Errors) == 20 {
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// triggerHandler runs a background job (radarr-poll, radarr-sync, sonarr-poll, sonarr-sync, calendar) now
+func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	job := r.PathValue("job")
+	if !s.Jobs.Trigger(job) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown or disabled job " + job})
+		return
+	}
+	s.Logger.Info("Server", "Status", "Job triggered", "job", job)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+}
+
+// retryHandler ticks Download again on a failed title
+func (s *Server) retryHandler(w http.ResponseWriter, r *http.Request) {
+	pageID := r.PathValue("pageId")
+	err := s.Jobs.RetryTitle(pageID)
+	if err != nil {
+		s.Logger.Error("Server", "Failed to retry title", pageID, "Error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "retrying"})
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/app"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+)
+
+type fakeJobs struct {
+	triggered []string
+}
+
+func (f *fakeJobs) Trigger(job string) bool {
+	if job != "radarr-sync" {
+		return false
+	}
+	f.triggered = append(f.triggered, job)
+	return true
+}
+
+func (f *fakeJobs) NextRuns() map[string]time.Time {
+	return map[string]time.Time{}
+}
+
+func (f *fakeJobs) RetryTitle(pageID string) error {
+	return errors.New("title not tracked")
+}
+
+func (f *fakeJobs) RequestTitle(req app.TitleRequest) (app.TitleResult, error) {
+	if req.ImdbID == "" {
+		return app.TitleResult{}, errors.Join(app.ErrInvalidRequest, errors.New("imdbId is required"))
+	}
+	if req.ImdbID == "tt0000000" {
+		return app.TitleResult{}, app.ErrTitleNotFound
+	}
+	return app.TitleResult{Created: true}, nil
+}
+
+func (f *fakeJobs) Sync(ctx context.Context, service string) ([]app.SyncSummary, error) {
+	if service == "lidarr" {
+		return nil, errors.Join(app.ErrInvalidRequest, errors.New("unknown service"))
+	}
+	return []app.SyncSummary{{Service: "radarr", Synced: 2}}, nil
+}
+
+func (f *fakeJobs) SyncTitle(imdbID string) (activity.Title, error) {
+	if imdbID != "tt0111161" {
+		return activity.Title{}, app.ErrTitleNotFound
+	}
+	return activity.Title{ImdbID: imdbID, Status: "Downloaded"}, nil
+}
+
+func (f *fakeJobs) Report(stuckDays int) (app.Report, error) {
+	return app.Report{StuckDays: stuckDays, Drift: []app.Drift{{Kind: app.DriftStuck, Service: "radarr", ImdbID: "tt0111161", Detail: "Queued since 2024-01-01"}}}, nil
+}
+
+func (f *fakeJobs) PublishReport(report app.Report) (string, error) {
+	return "", errors.Join(app.ErrInvalidRequest, errors.New("NOTION_REPORT_PAGE_ID is not set"))
+}
+
+func (f *fakeJobs) Releases(from time.Time, to time.Time) ([]notion.CalendarEntry, error) {
+	return []notion.CalendarEntry{
+		{Key: "movie:438631:digital", Title: "Dune (Digital)", Date: time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), AllDay: true, Type: "Movie", PageID: "1a2b-3c"},
+		{Key: "episode:7", Title: "Severance: Hello, Ms. Cobel", Date: time.Date(2024, 5, 11, 1, 0, 0, 0, time.UTC), Episode: "S02E01", Type: "TV Series"},
+	}, nil
+}
+
+func TestJobHandlers(t *testing.T) {
+	jobs := &fakeJobs{}
+	s := &Server{Jobs: jobs, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/trigger/{job}", s.triggerHandler)
+	mux.HandleFunc("POST /api/retry/{pageId}", s.retryHandler)
+	mux.HandleFunc("POST /api/request", s.requestHandler)
+	mux.HandleFunc("POST /api/sync", s.syncHandler)
+	mux.HandleFunc("POST /api/sync/{imdbId}", s.syncTitleHandler)
+	mux.HandleFunc("POST /api/report", s.publishReportHandler)
+
+	for _, tc := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/api/trigger/radarr-sync", "", http.StatusAccepted},
+		{"/api/trigger/sonarr-sync", "", http.StatusNotFound},
+		{"/api/retry/abc", "", http.StatusBadRequest},
+		{"/api/request", `{"imdbId":"tt0111161"}`, http.StatusCreated},
+		{"/api/request", `{"tmdbId":278}`, http.StatusBadRequest},
+		{"/api/request", `{"imdbId":"tt0000000"}`, http.StatusNotFound},
+		{"/api/request", `not json`, http.StatusBadRequest},
+		{"/api/sync", "", http.StatusOK},
+		{"/api/sync?service=lidarr", "", http.StatusBadRequest},
+		{"/api/sync/tt0111161", "", http.StatusOK},
+		{"/api/sync/tt0000000", "", http.StatusNotFound},
+		{"/api/report", "", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.path, rec.Code, tc.code)
+		}
+	}
+	if len(jobs.triggered) != 1 {
+		t.Fatalf("expected one trigger, got %v", jobs.triggered)
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	s := &Server{Jobs: &fakeJobs{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	for _, tc := range []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"", http.StatusOK, `"kind":"stuck"`},
+		{"?format=table&stuckDays=3", http.StatusOK, "Stuck in Queued / Downloading"},
+		{"?stuckDays=-1", http.StatusBadRequest, "stuckDays"},
+	} {
+		rec := httptest.NewRecorder()
+		s.reportHandler(rec, httptest.NewRequest(http.MethodGet, "/api/report"+tc.query, nil))
+		if rec.Code != tc.code || !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("%q: got %d %s", tc.query, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDashboardEmbedded(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&Server{}).dashboardHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -1,180 +1,584 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/app"
 	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notify"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
 	"github.com/flxp49/notion-watchlistarr/internal/util"
 )
 
-type MovieInfo struct {
-	Movie struct {
-		Id     int    `json:"id"`
-		ImdbId string `json:"imdbId"`
-		TmdbId int    `json:"tmdbId"`
-	} `json:"movie"`
-	EventType    string `json:"eventType"`    //allowed values MovieAdded|Grab|Download|MovieDelete
-	DeletedFiles bool   `json:"deletedFiles"` //only present when EventType: "MovieDelete"
+// queue job kinds
+const (
+	radarrJob = "radarr"
+	sonarrJob = "sonarr"
+)
+
+// maxLoggedBody is how much of an invalid payload is logged
+const maxLoggedBody = 300
+
+// loggedBody returns the payload as text for the logs, cut to maxLoggedBody bytes
+func loggedBody(body []byte) string {
+	if len(body) <= maxLoggedBody {
+		return string(body)
+	}
+	return strings.ToValidUTF8(string(body[:maxLoggedBody]), "") + "..."
+}
+
+// trackFailures records failed attempts of a queued job in the activity log,
+// the last attempt before the queue drops the job also sends an Error notification
+func (s *Server) trackFailures(service string, h queue.Handler) queue.Handler {
+	return func(job queue.Job) error {
+		err := h(job)
+		if err != nil {
+			s.Tracker.Record(activity.Event{Source: activity.SourceWebhook, Service: service, Type: "error", Detail: job.Key, Error: err.Error()})
+			if job.Attempts+1 >= s.Q.MaxAttempts {
+				s.Notifier.Notify(droppedJob(job, err))
+			}
+		}
+		return err
+	}
+}
+
+// droppedJob is the Error notification of a job dropped after its last attempt, titled from its payload
+func droppedJob(job queue.Job, err error) notify.Message {
+	msg := notify.Message{Event: notify.EventError, Title: job.Key, Error: err.Error()}
+	switch job.Kind {
+	case radarrJob:
+		var event RadarrEvent
+		if json.Unmarshal(job.Payload, &event) == nil && event.Movie.Title != "" {
+			msg.Title, msg.Year, msg.MediaType, msg.ImdbID = event.Movie.Title, event.Movie.Year, constant.MediaTypeMovie, event.Movie.ImdbId
+		}
+	case sonarrJob:
+		var event SonarrEvent
+		if json.Unmarshal(job.Payload, &event) == nil && event.Series.Title != "" {
+			msg.Title, msg.Year, msg.MediaType, msg.ImdbID = event.Series.Title, event.Series.Year, constant.MediaTypeTV, event.Series.ImdbId
+		}
+	case playbackJob:
+		var playback Playback
+		if json.Unmarshal(job.Payload, &playback) == nil && playback.Title != "" {
+			msg.Title, msg.Year, msg.MediaType, msg.ImdbID = playback.Title, playback.Year, playback.MediaType, playback.ImdbID
+		}
+	}
+	return msg
 }
 
+// radarrHandler validates the payload and queues it, processing happens in processRadarrEvent
 func (s *Server) radarrHandler(w http.ResponseWriter, r *http.Request) {
 	body, _ := io.ReadAll(r.Body)
 	r.Body.Close()
-	var movieData MovieInfo
-	err := util.ParseJson(body, &movieData)
-	if err != nil {
+	var event RadarrEvent
+	err := util.ParseJson(body, &event)
+	if err != nil || event.EventType == "" {
+		if err == nil {
+			err = errors.New("eventType missing in payload")
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		s.Logger.Error("RadarrWebhook", "body", body, "error", err)
+		s.Logger.Error("RadarrWebhook", "body", loggedBody(body), "error", err)
 		return
 	}
-	s.Logger.Info("RadarrWebhook", "data", movieData)
-	// Check if title exists in the watchlist
-	page, err := s.N.QueryDBImdb(movieData.Movie.ImdbId)
+	s.Logger.Info("RadarrWebhook", "data", event)
+	metrics.WebhookEvents.Inc("radarr", event.EventType)
+	switch event.EventType {
+	case constant.EventTypeTest:
+		w.WriteHeader(http.StatusOK)
+		return
+	case constant.EventTypeHealth, constant.EventTypeHealthRestored, constant.EventTypeApplicationUpdate:
+		s.logServiceEvent("RadarrWebhook", event.EventType, body)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	err = s.Q.Enqueue(radarrJob, "radarr:"+event.Movie.ImdbId, body)
 	if err != nil {
-		s.Logger.Error("RadarrWebhook", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		s.Logger.Error("RadarrWebhook", "Failed to queue event", err)
 		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	if len(page.Results) == 0 {
-		return
+// watchlistPages finds the pages of the title to update under s.DuplicatePolicy, from the tracked titles when known so Notion isn't queried.
+// No pages means the title isn't in the watchlist
+func (s *Server) watchlistPages(imdbID string) (pageIDs []string, cached bool, err error) {
+	for _, title := range s.Tracker.TitlesByImdb(imdbID) {
+		// flagged by the sync, the canonical page is tracked too
+		if s.DuplicatePolicy == app.DuplicatesCanonical && title.Status == constant.MediaStatusDuplicate {
+			continue
+		}
+		pageIDs = append(pageIDs, title.PageID)
 	}
-	if movieData.EventType == constant.EventTypeTest {
-		return
+	// several pages the sync hasn't flagged yet, the canonical one is picked from the watchlist
+	if len(pageIDs) == 1 || (len(pageIDs) > 1 && s.DuplicatePolicy != app.DuplicatesCanonical) {
+		return pageIDs, true, nil
 	}
-	if movieData.EventType == constant.EventTypeMovieDelete || movieData.EventType == constant.EventTypeMovieFileDelete {
-		s.N.UpdateDownloadStatus(constant.MediaTypeMovie, page.Results[0].Pgid, false, constant.MediaStatusNotDownloaded, "", "", "")
-		return
+	pageIDs = nil
+	page, err := s.N.QueryDBImdb(imdbID)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, result := range app.PagesToUpdate(s.DuplicatePolicy, page.Results) {
+		pageIDs = append(pageIDs, result.Pgid)
 	}
+	return pageIDs, false, nil
+}
 
-	//get movie file details
-	movie, err := s.R.GetMovie(movieData.Movie.TmdbId)
-	if err != nil {
-		s.Logger.Error("RadarrWebhook", "error", err)
-		return
+// forgetStalePage drops a tracked page the write failed on, it may have been deleted from Notion so the retry queries it again
+func (s *Server) forgetStalePage(pageID string, cached bool) {
+	if cached {
+		s.Tracker.Forget(pageID)
 	}
-	//get rootpath and qualityprofile properties for notion db
-	movieQualityProp, rootPathProp, err := s.N.GetNotionQualityAndRootProps(movie[0].QualityProfileID, movie[0].RootFolderPath, constant.MediaTypeMovie)
+}
+
+func (s *Server) processRadarrEvent(job queue.Job) error {
+	var event RadarrEvent
+	err := util.ParseJson(job.Payload, &event)
 	if err != nil {
-		s.Logger.Error("RadarrWebhook", "Failed to fetch notion DB property", err)
-		return
+		return err
 	}
-	monitoredProfile := constant.MovieOnly
-	if movie[0].Collection.TmdbID != 0 {
-		collectionMonitored, _ := s.R.GetCollection(movie[0].Collection.TmdbID)
-		if collectionMonitored {
-			monitoredProfile = constant.MovieAndCollection
-		}
+	// Check if title exists in the watchlist
+	pageIDs, cached, err := s.watchlistPages(event.Movie.ImdbId)
+	if err != nil || len(pageIDs) == 0 {
+		return err
 	}
-	monitoredProfileNotionProp, _ := s.N.GetNotionMonitorProp(monitoredProfile, constant.MediaTypeMovie)
-	switch movieData.EventType {
+
+	// status to set and whether the movie has to be fetched from Radarr
+	var status string
+	var release notion.ReleaseInfo
+	withProps := false
+	switch event.EventType {
 	case constant.EventTypeMovieAdded:
+		var payload RadarrMovieAddedPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status, withProps = constant.MediaStatusQueued, true
+	case constant.EventTypeMovieGrabbed:
+		var payload RadarrGrabPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status = s.radarrGrabStatus(event.Movie.ImdbId)
+		release = releaseInfo(payload.Release)
+	case constant.EventTypeMovieDownloaded:
+		var payload RadarrDownloadPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status = constant.MediaStatusDownloaded
+		if payload.IsUpgrade {
+			status = constant.MediaStatusUpgraded
+		}
+		release = releaseInfo(payload.Release)
+		if release.Release == "" {
+			release.Release = payload.MovieFile.SceneName
+		}
+		// the imported file is what ends up in the library
+		if payload.MovieFile.Quality != "" {
+			release.Quality = payload.MovieFile.Quality
+		}
+		if payload.MovieFile.Size != 0 {
+			release.Size = payload.MovieFile.Size
+		}
+		// older Radarr versions don't send the file details
+		withProps = release.Quality == "" || release.Size == 0
+	case constant.EventTypeRename:
+		var payload RadarrRenamePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status, withProps = constant.MediaStatusDownloaded, true
+	case constant.EventTypeMovieDelete:
+		var payload RadarrMovieDeletePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status = constant.MediaStatusRemovedKept
+		if payload.DeletedFiles {
+			status = constant.MediaStatusNotDownloaded
+		}
+	case constant.EventTypeMovieFileDelete:
+		var payload RadarrMovieFileDeletePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		// the Download event (isUpgrade) that follows sets the status
+		if payload.DeleteReason == constant.DeleteReasonUpgrade {
+			return nil
+		}
+		status = constant.MediaStatusNotDownloaded
+	case constant.EventTypeManualInteractionRequired:
+		var payload RadarrManualInteractionRequiredPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		s.Logger.Warn("RadarrWebhook", "Status", "Manual interaction required", "title", payload.Movie.Title, "downloadStatus", payload.DownloadStatus, "messages", payload.DownloadStatusMessages)
+		status = constant.MediaStatusManualImport
+	default:
+		s.Logger.Error("RadarrWebhook", "error", "EventType not valid in payload", "eventType", event.EventType)
+		return nil
+	}
+
+	qualityProp, rootPathProp, monitorProp, availableFrom := "", "", "", ""
+	if withProps {
+		movie, err := s.R.GetMovie(event.Movie.TmdbId)
+		if err != nil {
+			return err
+		}
+		if len(movie) == 0 {
+			return errors.New("movie not found in radarr")
+		}
+		qualityProp, rootPathProp, monitorProp, err = s.radarrMovieProps(movie[0])
+		if err != nil {
+			return err
+		}
 		//check if movie was imported manually (file already exists)
-		if movie[0].HasFile {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeMovie, page.Results[0].Pgid, false, constant.MediaStatusNotDownloaded, movieQualityProp, rootPathProp, monitoredProfileNotionProp)
-		} else {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeMovie, page.Results[0].Pgid, false, constant.MediaStatusQueued, movieQualityProp, rootPathProp, monitoredProfileNotionProp)
+		if event.EventType == constant.EventTypeMovieAdded && movie[0].HasFile {
+			status = constant.MediaStatusDownloaded
+		}
+		// added without a search, see RadarrMedia.AddTitle
+		if event.EventType == constant.EventTypeMovieAdded && !movie[0].HasFile && !movie[0].IsAvailable {
+			state := app.AwaitingReleaseState(movie[0].ExpectedAvailability())
+			status, availableFrom = state.Status, state.AvailableFrom
 		}
+		if event.EventType == constant.EventTypeMovieDownloaded && movie[0].HasFile {
+			if release.Quality == "" {
+				release.Quality = movie[0].MovieFile.Quality.Quality.Name
+			}
+			if release.Size == 0 {
+				release.Size = int64(movie[0].MovieFile.Size)
+			}
+		}
+	}
+	// every page of the title under the duplicate policy
+	for _, pageID := range pageIDs {
+		err = s.N.UpdateDownloadState(constant.MediaTypeMovie, pageID, false, notion.DownloadState{Status: status, QualityProfile: qualityProp, RootFolder: rootPathProp, MonitorProfile: monitorProp, AvailableFrom: availableFrom})
 		if err != nil {
-			s.Logger.Error("RadarrWebhook", "Failed to update download status in watchlist", err)
+			s.forgetStalePage(pageID, cached)
+			return errors.Join(errors.New("failed to update download status in watchlist"), err)
 		}
+		if release != (notion.ReleaseInfo{}) {
+			err = s.N.UpdateReleaseInfo(pageID, release)
+			if err != nil {
+				s.forgetStalePage(pageID, cached)
+				return errors.Join(errors.New("failed to update release info in watchlist"), err)
+			}
+		}
+		s.Tracker.UpdateTitle(activity.Title{PageID: pageID, ImdbID: event.Movie.ImdbId, Title: event.Movie.Title, MediaType: constant.MediaTypeMovie, Status: status, RadarrID: event.Movie.Id, TmdbID: event.Movie.TmdbId})
+	}
+	s.Tracker.Record(activity.Event{Source: activity.SourceWebhook, Service: "radarr", Type: event.EventType, ImdbID: event.Movie.ImdbId, Title: event.Movie.Title, Status: status, Detail: release.Release})
+	if notification := webhookNotification(event.EventType); notification != "" {
+		quality := release.Quality
+		if quality == "" {
+			quality = qualityProp
+		}
+		s.Notifier.Notify(notify.Message{Event: notification, Title: event.Movie.Title, Year: event.Movie.Year, MediaType: constant.MediaTypeMovie, Quality: quality, ImdbID: event.Movie.ImdbId, URL: notion.PageURL(pageIDs[0])})
+	}
+	return nil
+}
 
+// webhookNotification is the notification sent for the webhook event, empty when none.
+// The Sonarr Grab and Download events share the Radarr names
+func webhookNotification(eventType string) string {
+	switch eventType {
+	case constant.EventTypeMovieAdded, constant.EventTypeTVAdded:
+		return notify.EventAdded
 	case constant.EventTypeMovieGrabbed:
-		err = s.N.UpdateDownloadStatus("movie", page.Results[0].Pgid, false, "Downloading", movieQualityProp, rootPathProp, monitoredProfileNotionProp)
-		if err != nil {
-			s.Logger.Error("RadarrWebhook", "Failed to update download status in watchlist", err)
-		}
+		return notify.EventGrabbed
 	case constant.EventTypeMovieDownloaded:
-		err = s.N.UpdateDownloadStatus("movie", page.Results[0].Pgid, false, "Downloaded", movieQualityProp, rootPathProp, monitoredProfileNotionProp)
-		if err != nil {
-			s.Logger.Error("RadarrWebhook", "Failed to update download status in watchlist", err)
+		return notify.EventDownloaded
+	case constant.EventTypeManualInteractionRequired:
+		return notify.EventStalled
+	}
+	return ""
+}
+
+// radarrGrabStatus is Upgrading (when enabled) for a movie that was already downloaded, Downloading otherwise
+func (s *Server) radarrGrabStatus(imdbID string) string {
+	if notion.StatusEnabled(constant.MediaStatusUpgrading) {
+		for _, title := range s.Tracker.TitlesByImdb(imdbID) {
+			switch title.Status {
+			case constant.MediaStatusDownloaded, constant.MediaStatusUpgraded, constant.MediaStatusUpgrading:
+				return constant.MediaStatusUpgrading
+			}
 		}
-	default:
-		s.Logger.Error("RadarrWebhook", "error", "EventType not valid in payload")
 	}
+	return constant.MediaStatusDownloading
+}
 
+// releaseInfo maps the webhook release to the notion props
+func releaseInfo(r Release) notion.ReleaseInfo {
+	return notion.ReleaseInfo{Release: r.ReleaseTitle, Indexer: r.Indexer, Quality: r.Quality, Size: r.Size}
 }
 
-type SeriesInfo struct {
-	Series struct {
-		Id     int    `json:"id"`
-		ImdbId string `json:"imdbId"`
-		TvdbId int    `json:"tvdbId"`
-	} `json:"series"`
-	EventType    string `json:"eventType"`    //allowed values SeriesAdd|Grab|Download|SeriesDelete
-	DeletedFiles bool   `json:"deletedFiles"` //only present when EventType: "SeriesDelete"
+// episodeList formats the episodes as "S01E01, S01E02"
+func episodeList(episodes []SonarrEpisode) string {
+	list := make([]string, 0, len(episodes))
+	for _, e := range episodes {
+		list = append(list, fmt.Sprintf("S%02dE%02d", e.SeasonNumber, e.EpisodeNumber))
+	}
+	return strings.Join(list, ", ")
 }
 
+// radarrMovieProps maps the movie's profiles to the notion options
+func (s *Server) radarrMovieProps(movie radarr.GetMovieResponse) (string, string, string, error) {
+	//get rootpath and qualityprofile properties for notion db
+	qualityProp, rootPathProp, err := s.N.GetNotionQualityAndRootProps(movie.QualityProfileID, movie.RootFolderPath, constant.MediaTypeMovie)
+	if err != nil {
+		return "", "", "", errors.Join(errors.New("failed to fetch notion DB property"), err)
+	}
+	monitoredProfile := constant.MovieOnly
+	if movie.Collection.TmdbID != 0 {
+		collectionMonitored, _ := s.R.GetCollection(movie.Collection.TmdbID)
+		if collectionMonitored {
+			monitoredProfile = constant.MovieAndCollection
+		}
+	}
+	monitorProp, _ := s.N.GetNotionMonitorProp(monitoredProfile, constant.MediaTypeMovie)
+	return qualityProp, rootPathProp, monitorProp, nil
+}
+
+// sonarrHandler validates the payload and queues it, processing happens in processSonarrEvent
 func (s *Server) sonarrHandler(w http.ResponseWriter, r *http.Request) {
 	body, _ := io.ReadAll(r.Body)
 	r.Body.Close()
-	var seriesData SeriesInfo
-	err := util.ParseJson(body, &seriesData)
-	if err != nil {
+	var event SonarrEvent
+	err := util.ParseJson(body, &event)
+	if err != nil || event.EventType == "" {
+		if err == nil {
+			err = errors.New("eventType missing in payload")
+		}
 		w.WriteHeader(http.StatusBadRequest)
-		s.Logger.Error("SonarrWebhook", "body", body, "error", err)
-		return
-	}
-	s.Logger.Info("SonarrWebhook", "data", seriesData)
-	// check if title exists in watchlist db
-	page, err := s.N.QueryDBImdb(seriesData.Series.ImdbId)
-	if err != nil {
-		s.Logger.Error("SonarrWebhook", "error", err)
+		s.Logger.Error("SonarrWebhook", "body", loggedBody(body), "error", err)
 		return
 	}
-	if len(page.Results) == 0 {
+	s.Logger.Info("SonarrWebhook", "data", event)
+	metrics.WebhookEvents.Inc("sonarr", event.EventType)
+	switch event.EventType {
+	case constant.EventTypeTest:
+		w.WriteHeader(http.StatusOK)
 		return
-	}
-
-	if seriesData.EventType == constant.EventTypeTest {
+	case constant.EventTypeHealth, constant.EventTypeHealthRestored, constant.EventTypeApplicationUpdate:
+		s.logServiceEvent("SonarrWebhook", event.EventType, body)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if seriesData.EventType == constant.EventTypeTVDelete {
-		s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusNotDownloaded, "", "", "")
+	err = s.Q.Enqueue(sonarrJob, "sonarr:"+event.Series.ImdbId, body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		s.Logger.Error("SonarrWebhook", "Failed to queue event", err)
 		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	series, err := s.S.GetSeries(seriesData.Series.TvdbId)
+func (s *Server) processSonarrEvent(job queue.Job) error {
+	var event SonarrEvent
+	err := util.ParseJson(job.Payload, &event)
 	if err != nil {
-		s.Logger.Error("SonarrWebhook", "body", body, "error", err)
-		return
+		return err
 	}
-	//get rootpath and qualityprofile properties for notion db
-	qualityProp, rootPathProp, err := s.N.GetNotionQualityAndRootProps(series[0].QualityProfileID, series[0].RootFolderPath, constant.MediaTypeTV)
-	if err != nil {
-		s.Logger.Error("SonarrWebhook", "Failed to fetch notion DB property", err)
-		return
+	// the episodes of the series may have changed, the playbacks fetch them again
+	s.forgetEpisodes(event.Series.Id)
+	// Check if title exists in the watchlist
+	pageIDs, cached, err := s.watchlistPages(event.Series.ImdbId)
+	if err != nil || len(pageIDs) == 0 {
+		return err
 	}
-	switch seriesData.EventType {
+
+	// status to set and whether the series has to be fetched from Sonarr
+	var status string
+	var release notion.ReleaseInfo
+	withSeries := false
+	switch event.EventType {
 	case constant.EventTypeTVAdded:
-		//check if series was imported manually (file already exists)
-		if series[0].Statistics.PercentOfEpisodes == 100 {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, "")
-		} else {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusQueued, qualityProp, rootPathProp, "")
+		var payload SonarrSeriesAddPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status, withSeries = constant.MediaStatusQueued, true
+	case constant.EventTypeTVGrabbed:
+		var payload SonarrGrabPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status = constant.MediaStatusDownloading
+		release = releaseInfo(payload.Release)
+		release.Episodes = episodeList(payload.Episodes)
+	case constant.EventTypeTVDownloaded:
+		var payload SonarrDownloadPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		// completeness of the series is only known by Sonarr
+		status, withSeries = constant.MediaStatusDownloading, true
+		if payload.IsUpgrade {
+			status = constant.MediaStatusUpgraded
+		}
+		release = releaseInfo(payload.Release)
+		release.Episodes = episodeList(payload.Episodes)
+		files := payload.EpisodeFiles
+		if len(files) == 0 && payload.EpisodeFile.Id != 0 {
+			files = []SonarrEpisodeFile{payload.EpisodeFile}
 		}
+		if len(files) != 0 {
+			if release.Release == "" {
+				release.Release = files[0].SceneName
+			}
+			release.Quality = files[0].Quality
+			release.Size = 0
+			for _, f := range files {
+				release.Size += f.Size
+			}
+		}
+	case constant.EventTypeRename:
+		var payload SonarrRenamePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		withSeries = true
+	case constant.EventTypeTVEpisodeFileDelete:
+		var payload SonarrEpisodeFileDeletePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		// the Download event (isUpgrade) that follows sets the status
+		if payload.DeleteReason == constant.DeleteReasonUpgrade {
+			return nil
+		}
+		withSeries = true
+	case constant.EventTypeTVDelete:
+		var payload SonarrSeriesDeletePayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		status = constant.MediaStatusRemovedKept
+		if payload.DeletedFiles {
+			status = constant.MediaStatusNotDownloaded
+		}
+	case constant.EventTypeManualInteractionRequired:
+		var payload SonarrManualInteractionRequiredPayload
+		if err = util.ParseJson(job.Payload, &payload); err != nil {
+			return err
+		}
+		s.Logger.Warn("SonarrWebhook", "Status", "Manual interaction required", "title", payload.Series.Title, "downloadStatus", payload.DownloadStatus, "messages", payload.DownloadStatusMessages)
+		status = constant.MediaStatusManualImport
+	default:
+		s.Logger.Error("SonarrWebhook", "error", "EventType not valid in payload", "eventType", event.EventType)
+		return nil
+	}
+
+	qualityProp, rootPathProp := "", ""
+	if withSeries {
+		series, err := s.S.GetSeries(event.Series.TvdbId)
 		if err != nil {
-			s.Logger.Error("SonarrWebhook", "Failed to update download status in watchlist", err)
+			return err
 		}
-	case constant.EventTypeTVGrabbed:
-		err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, "")
+		if len(series) == 0 {
+			return errors.New("series not found in sonarr")
+		}
+		//get rootpath and qualityprofile properties for notion db
+		qualityProp, rootPathProp, err = s.N.GetNotionQualityAndRootProps(series[0].QualityProfileID, series[0].RootFolderPath, constant.MediaTypeTV)
 		if err != nil {
-			s.Logger.Error("SonarrWebhook", "Failed to update download status in watchlist", err)
+			return errors.Join(errors.New("failed to fetch notion DB property"), err)
+		}
+		status, err = s.sonarrSeriesStatus(event.EventType, status, series[0])
+		if err != nil {
+			return err
+		}
+	}
+	// every page of the title under the duplicate policy
+	for _, pageID := range pageIDs {
+		err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, pageID, false, status, qualityProp, rootPathProp, "")
+		if err != nil {
+			s.forgetStalePage(pageID, cached)
+			return errors.Join(errors.New("failed to update download status in watchlist"), err)
+		}
+		if release != (notion.ReleaseInfo{}) {
+			err = s.N.UpdateReleaseInfo(pageID, release)
+			if err != nil {
+				s.forgetStalePage(pageID, cached)
+				return errors.Join(errors.New("failed to update release info in watchlist"), err)
+			}
+		}
+		s.Tracker.UpdateTitle(activity.Title{PageID: pageID, ImdbID: event.Series.ImdbId, Title: event.Series.Title, MediaType: constant.MediaTypeTV, Status: status, SonarrID: event.Series.Id, TvdbID: event.Series.TvdbId})
+	}
+	s.Tracker.Record(activity.Event{Source: activity.SourceWebhook, Service: "sonarr", Type: event.EventType, ImdbID: event.Series.ImdbId, Title: event.Series.Title, Status: status, Detail: release.Release})
+	if notification := webhookNotification(event.EventType); notification != "" {
+		quality := release.Quality
+		if quality == "" {
+			quality = qualityProp
+		}
+		s.Notifier.Notify(notify.Message{Event: notification, Title: event.Series.Title, Year: event.Series.Year, MediaType: constant.MediaTypeTV, Quality: quality, Episodes: release.Episodes, ImdbID: event.Series.ImdbId, URL: notion.PageURL(pageIDs[0])})
+	}
+	return nil
+}
+
+// sonarrSeriesStatus refines the status using the episode statistics of the series
+func (s *Server) sonarrSeriesStatus(eventType string, status string, series sonarr.GetSeriesResponse) (string, error) {
+	complete := series.Statistics.PercentOfEpisodes == 100
+	switch eventType {
+	case constant.EventTypeTVAdded:
+		//check if series was imported manually (file already exists)
+		if complete {
+			return constant.MediaStatusDownloaded, nil
 		}
 	case constant.EventTypeTVDownloaded:
 		// check if all episodes were downloaded or not
-		if series[0].Statistics.PercentOfEpisodes == 100 {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusDownloaded, qualityProp, rootPathProp, "")
-		} else {
-			err = s.N.UpdateDownloadStatus(constant.MediaTypeTV, page.Results[0].Pgid, false, constant.MediaStatusDownloading, qualityProp, rootPathProp, "")
+		if complete && status != constant.MediaStatusUpgraded {
+			return constant.MediaStatusDownloaded, nil
 		}
+	case constant.EventTypeRename, constant.EventTypeTVEpisodeFileDelete:
+		if complete {
+			return constant.MediaStatusDownloaded, nil
+		}
+		queueStatus, err := s.S.GetQueueDetails(series.ID)
 		if err != nil {
-			s.Logger.Error("SonarrWebhook", "Failed to update download status in watchlist", err)
+			return "", errors.Join(errors.New("failed to get queue details in sonarr"), err)
 		}
-	default:
-		s.Logger.Error("SonarrWebhook", "error", "EventType not valid in payload")
+		if queueStatus {
+			return constant.MediaStatusDownloading, nil
+		}
+		return constant.MediaStatusNotDownloaded, nil
 	}
+	return status, nil
+}
 
+// logServiceEvent logs events that aren't tied to a title, the watchlist is left untouched
+func (s *Server) logServiceEvent(source string, eventType string, body []byte) {
+	switch eventType {
+	case constant.EventTypeHealth, constant.EventTypeHealthRestored:
+		var payload HealthPayload
+		err := util.ParseJson(body, &payload)
+		if err != nil {
+			s.Logger.Error(source, "body", loggedBody(body), "error", err)
+			return
+		}
+		if eventType == constant.EventTypeHealth {
+			s.Logger.Warn(source, "Status", "Health issue", "level", payload.Level, "type", payload.Type, "message", payload.Message, "wiki", payload.WikiUrl)
+		} else {
+			s.Logger.Info(source, "Status", "Health restored", "type", payload.Type, "message", payload.Message)
+		}
+	case constant.EventTypeApplicationUpdate:
+		var payload ApplicationUpdatePayload
+		err := util.ParseJson(body, &payload)
+		if err != nil {
+			s.Logger.Error(source, "body", loggedBody(body), "error", err)
+			return
+		}
+		s.Logger.Info(source, "Status", "Application updated", "previousVersion", payload.PreviousVersion, "newVersion", payload.NewVersion)
+	}
 }
 
 func (s *Server) incorrectReqHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/app"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notify"
+	"github.com/flxp49/notion-watchlistarr/internal/notion/notiontest"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
+	"github.com/flxp49/notion-watchlistarr/internal/radarr"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+// fakeArr serves the Radarr/Sonarr API answers the webhook processing needs, by path and query
+type fakeArr struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  map[string]int
+}
+
+func newFakeArr(t *testing.T) (*fakeArr, string) {
+	f := &fakeArr{responses: make(map[string]string), requests: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		path := strings.TrimPrefix(r.URL.RequestURI(), "/api/v3")
+		f.requests[path]++
+		response, exists := f.responses[path]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeArr) set(path string, response string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[path] = response
+}
+
+// requested returns how many times the path was requested
+func (f *fakeArr) requested(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// webhookServer is a Server writing to a fake Notion, with fake Radarr and Sonarr
+type webhookServer struct {
+	*Server
+	notion *notiontest.Server
+	radarr *fakeArr
+	sonarr *fakeArr
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	fakeNotion := notiontest.NewServer(t)
+	N := fakeNotion.Client("db")
+	N.Qpid = map[string]int{"Movie: HD-1080p": 4, "TV Series: HD-1080p": 6}
+	N.Rpid = map[string]string{"Movie: /movies": "/movies", "TV Series: /tv": "/tv"}
+	fakeRadarr, radarrURL := newFakeArr(t)
+	fakeSonarr, sonarrURL := newFakeArr(t)
+	Logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	q, err := queue.NewQueue(filepath.Join(t.TempDir(), "queue.json"), 3, Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("127.0.0.1", "0", WebhookAuth{}, false, N, radarr.InitRadarrClient("key", radarrURL), sonarr.InitSonarrClient("key", sonarrURL), q, activity.NewTracker(50), nil, Logger, true, true)
+	return &webhookServer{Server: s, notion: fakeNotion, radarr: fakeRadarr, sonarr: fakeSonarr}
+}
+
+// deliver posts the payload to the webhook endpoint and processes the queued event, like the queue does
+func (w *webhookServer) deliver(t *testing.T, service string, payload string) {
+	t.Helper()
+	handler, process := w.radarrHandler, w.processRadarrEvent
+	if service == "sonarr" {
+		handler, process = w.sonarrHandler, w.processSonarrEvent
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/"+service, strings.NewReader(payload)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the event to be queued, got %d", rec.Code)
+	}
+	err := process(queue.Job{Kind: service, Payload: json.RawMessage(payload)})
+	if err != nil {
+		t.Fatalf("failed to process %s: %s", payload, err)
+	}
+}
+
+// statuses maps the Download Status options to their status
+var statuses = map[string]string{
+	"🔵 Downloaded":           constant.MediaStatusDownloaded,
+	"🟢 Downloading":          constant.MediaStatusDownloading,
+	"⚫ Not Downloaded":       constant.MediaStatusNotDownloaded,
+	"🟡 Queued":               constant.MediaStatusQueued,
+	"🟣 Upgraded":             constant.MediaStatusUpgraded,
+	"🟠 Needs Manual Import":  constant.MediaStatusManualImport,
+	"🟤 Removed (files kept)": constant.MediaStatusRemovedKept,
+}
+
+// status returns the Download Status of the page
+func (w *webhookServer) status(t *testing.T, pageID string) string {
+	t.Helper()
+	return statuses[w.notion.Option(pageID, "Download Status")]
+}
+
+type webhookCase struct {
+	name    string
+	payload string
+	status  string
+	// expected options (Quality Profile, Root Folder, Monitor, Quality) and texts (Release, Indexer, Episodes), "" is unset
+	options map[string]string
+	texts   map[string]string
+	size    string
+}
+
+func (w *webhookServer) check(t *testing.T, pageID string, tc webhookCase) {
+	t.Helper()
+	if got := w.status(t, pageID); got != tc.status {
+		t.Errorf("%s: status is %q, want %q", tc.name, got, tc.status)
+	}
+	for name, want := range tc.options {
+		if got := w.notion.Option(pageID, name); got != want {
+			t.Errorf("%s: %s is %q, want %q", tc.name, name, got, want)
+		}
+	}
+	for name, want := range tc.texts {
+		if got := w.notion.Text(pageID, name); got != want {
+			t.Errorf("%s: %s is %q, want %q", tc.name, name, got, want)
+		}
+	}
+	if tc.size != "" {
+		if got := w.notion.Property(pageID, "Size"); got != tc.size {
+			t.Errorf("%s: Size is %s, want %s", tc.name, got, tc.size)
+		}
+	}
+}
+
+const radarrMovieJSON = `"movie": {"id": 10, "title": "Dune", "year": 2021, "releaseDate": "2021-10-22", "folderPath": "/movies/Dune (2021)", "imdbId": "tt1160419", "tmdbId": 438631}`
+
+func TestRadarrWebhookEvents(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("p1", "tt1160419", nil)
+	w.radarr.set("/movie?tmdbId=438631", `[{"id": 10, "title": "Dune", "imdbId": "tt1160419", "tmdbId": 438631, "qualityProfileId": 4, "rootFolderPath": "/movies", "hasFile": false, "isAvailable": true, "monitored": true}]`)
+
+	movie := map[string]string{"Quality Profile": "Movie: HD-1080p", "Root Folder": "Movie: /movies", "Monitor": "Movie: Movie Only"}
+	for _, tc := range []webhookCase{
+		{name: "MovieAdded", status: constant.MediaStatusQueued, options: movie,
+			payload: `{"eventType": "MovieAdded", "instanceName": "Radarr", ` + radarrMovieJSON + `, "addMethod": "manual"}`},
+		{name: "Grab", status: constant.MediaStatusDownloading, size: `{"number":2}`,
+			options: map[string]string{"Quality": "Bluray-1080p", "Quality Profile": "Movie: HD-1080p"},
+			texts:   map[string]string{"Release": "Dune.2021.1080p.BluRay.x264-GRP", "Indexer": "IndexerA"},
+			payload: `{"eventType": "Grab", ` + radarrMovieJSON + `, "release": {"quality": "Bluray-1080p", "qualityVersion": 1, "releaseGroup": "GRP", "releaseTitle": "Dune.2021.1080p.BluRay.x264-GRP", "indexer": "IndexerA", "size": 2147483648, "customFormatScore": 0}, "downloadClient": "qBittorrent", "downloadId": "ABC"}`},
+		{name: "Download", status: constant.MediaStatusDownloaded, size: `{"number":3}`,
+			options: map[string]string{"Quality": "Remux-1080p"},
+			texts:   map[string]string{"Release": "Dune.2021.1080p.Remux-GRP"},
+			payload: `{"eventType": "Download", ` + radarrMovieJSON + `, "movieFile": {"id": 5, "relativePath": "Dune.mkv", "quality": "Remux-1080p", "qualityVersion": 1, "sceneName": "Dune.2021.1080p.Remux-GRP", "size": 3221225472}, "isUpgrade": false, "downloadClient": "qBittorrent", "downloadId": "ABC"}`},
+		{name: "Download upgrade", status: constant.MediaStatusUpgraded,
+			payload: `{"eventType": "Download", ` + radarrMovieJSON + `, "movieFile": {"id": 6, "quality": "Remux-2160p", "size": 3221225472}, "release": {"releaseTitle": "Dune.2021.2160p.Remux-GRP", "indexer": "IndexerB"}, "isUpgrade": true}`},
+		{name: "Rename", status: constant.MediaStatusDownloaded, options: movie,
+			payload: `{"eventType": "Rename", ` + radarrMovieJSON + `}`},
+		// the upgrade Download that follows sets the status
+		{name: "MovieFileDelete upgrade", status: constant.MediaStatusDownloaded,
+			payload: `{"eventType": "MovieFileDelete", ` + radarrMovieJSON + `, "deleteReason": "upgrade"}`},
+		{name: "ManualInteractionRequired", status: constant.MediaStatusManualImport,
+			payload: `{"eventType": "ManualInteractionRequired", ` + radarrMovieJSON + `, "downloadClient": "qBittorrent", "downloadStatus": "warning", "downloadStatusMessages": [{"title": "Dune.mkv", "messages": ["No files found are eligible for import"]}]}`},
+		{name: "MovieFileDelete", status: constant.MediaStatusNotDownloaded, options: map[string]string{"Quality Profile": "", "Root Folder": "", "Monitor": ""},
+			payload: `{"eventType": "MovieFileDelete", ` + radarrMovieJSON + `, "deleteReason": "manual"}`},
+		{name: "MovieDelete files kept", status: constant.MediaStatusRemovedKept,
+			payload: `{"eventType": "MovieDelete", ` + radarrMovieJSON + `, "deletedFiles": false, "movieFolderSize": 100}`},
+		{name: "MovieDelete", status: constant.MediaStatusNotDownloaded,
+			payload: `{"eventType": "MovieDelete", ` + radarrMovieJSON + `, "deletedFiles": true}`},
+	} {
+		w.deliver(t, "radarr", tc.payload)
+		w.check(t, "p1", tc)
+	}
+	if title, _ := w.Tracker.Title("p1"); title.Status != constant.MediaStatusNotDownloaded || title.RadarrID != 10 {
+		t.Errorf("unexpected tracked title %+v", title)
+	}
+
+	// a movie already imported when added is Downloaded
+	w.notion.AddPage("p2", "tt0816692", nil)
+	w.radarr.set("/movie?tmdbId=157336", `[{"id": 11, "title": "Interstellar", "imdbId": "tt0816692", "tmdbId": 157336, "qualityProfileId": 4, "rootFolderPath": "/movies", "hasFile": true, "isAvailable": true}]`)
+	w.deliver(t, "radarr", `{"eventType": "MovieAdded", "movie": {"id": 11, "title": "Interstellar", "imdbId": "tt0816692", "tmdbId": 157336}}`)
+	w.check(t, "p2", webhookCase{name: "MovieAdded with file", status: constant.MediaStatusDownloaded, options: movie})
+}
+
+func TestReleaseInfoWriteFailure(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("p1", "tt1160419", nil)
+	w.radarr.set("/movie?tmdbId=438631", `[{"id": 10, "title": "Dune", "imdbId": "tt1160419", "tmdbId": 438631, "qualityProfileId": 4, "rootFolderPath": "/movies", "isAvailable": true, "monitored": true}]`)
+	grab := func(release string) string {
+		return `{"eventType": "Grab", ` + radarrMovieJSON + `, "release": {"quality": "Bluray-1080p", "releaseTitle": "` + release + `", "indexer": "IndexerA", "size": 2147483648}}`
+	}
+
+	// Notion limits rich text to 2000 characters, not bytes
+	long := strings.Repeat("Dune.Deuxième.Partie.", 100)
+	w.deliver(t, "radarr", grab(long))
+	if got := w.notion.Text("p1", "Release"); got != string([]rune(long)[:2000]) {
+		t.Fatalf("expected the release to be cut at 2000 characters, got %d characters", utf8.RuneCountInString(got))
+	}
+	if _, tracked := w.Tracker.Title("p1"); !tracked {
+		t.Fatal("expected the page to be tracked")
+	}
+
+	// removed from the watchlist between the status and the release writes
+	w.notion.ArchiveOnWrite("p1", "Release")
+	err := w.processRadarrEvent(queue.Job{Kind: "radarr", Payload: json.RawMessage(grab("Dune.2021.2160p-GRP"))})
+	if err == nil {
+		t.Fatal("expected the release write to fail")
+	}
+	if _, tracked := w.Tracker.Title("p1"); tracked {
+		t.Fatal("expected the stale page to be forgotten")
+	}
+	// the retry queries the watchlist again and drops the event
+	writes := len(w.notion.Writes("p1"))
+	err = w.processRadarrEvent(queue.Job{Kind: "radarr", Payload: json.RawMessage(grab("Dune.2021.2160p-GRP"))})
+	if err != nil || len(w.notion.Writes("p1")) != writes {
+		t.Fatalf("expected the retry to be dropped, got %v", err)
+	}
+}
+
+func TestDownloadStatusWrites(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("p1", "tt1160419", map[string]interface{}{
+		"Download Status": map[string]interface{}{"select": map[string]interface{}{"name": "🟡 Queued"}},
+		"Quality Profile": map[string]interface{}{"select": map[string]interface{}{"name": "Movie: HD-1080p"}},
+		"Root Folder":     map[string]interface{}{"select": map[string]interface{}{"name": "Movie: /movies"}},
+		"Monitor":         map[string]interface{}{"select": map[string]interface{}{"name": "Movie: Movie Only"}},
+	})
+	w.radarr.set("/movie?tmdbId=438631", `[{"id": 10, "title": "Dune", "imdbId": "tt1160419", "tmdbId": 438631, "qualityProfileId": 4, "rootFolderPath": "/movies", "hasFile": true, "isAvailable": true, "monitored": true}]`)
+	rename := `{"eventType": "Rename", ` + radarrMovieJSON + `}`
+	// written returns the properties of the last PATCH of the page
+	written := func(count int) []string {
+		t.Helper()
+		writes := w.notion.Writes("p1")
+		if len(writes) != count {
+			t.Fatalf("expected %d writes, got %d", count, len(writes))
+		}
+		var payload struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		err := json.Unmarshal([]byte(writes[count-1].Body), &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range payload.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// only the status differs
+	w.deliver(t, "radarr", rename)
+	if props := written(1); strings.Join(props, ", ") != "Download Status" {
+		t.Fatalf("expected only the status to be sent, got %v", props)
+	}
+	skipped := metrics.SkippedWrites.Value()
+	w.deliver(t, "radarr", rename)
+	written(1)
+	if metrics.SkippedWrites.Value() != skipped+1 {
+		t.Fatal("expected the unchanged write to be counted as skipped")
+	}
+
+	// edited by hand in Notion, the next event puts the page back
+	w.notion.SetProperty("p1", "Download Status", map[string]interface{}{"select": map[string]interface{}{"name": "🟡 Queued"}})
+	w.notion.SetProperty("p1", "Root Folder", map[string]interface{}{"select": map[string]interface{}{"name": "Movie: /other"}})
+	w.deliver(t, "radarr", rename)
+	if props := written(2); strings.Join(props, ", ") != "Download Status, Root Folder" {
+		t.Fatalf("expected the edited properties to be sent, got %v", props)
+	}
+	w.check(t, "p1", webhookCase{name: "edited", status: constant.MediaStatusDownloaded, options: map[string]string{"Root Folder": "Movie: /movies", "Quality Profile": "Movie: HD-1080p"}})
+}
+
+func TestWebhookDuplicatePolicy(t *testing.T) {
+	grab := `{"eventType": "Grab", ` + radarrMovieJSON + `, "release": {"releaseTitle": "Dune.2021.1080p-GRP"}}`
+	for _, tc := range []struct {
+		name    string
+		policy  string
+		tracked bool
+		written []string
+	}{
+		{"all", app.DuplicatesUpdateAll, true, []string{"old", "new"}},
+		// both pages tracked, the sync hasn't flagged the newer one yet
+		{"canonical tracked", app.DuplicatesCanonical, true, []string{"old"}},
+		{"canonical queried", app.DuplicatesCanonical, false, []string{"old"}},
+	} {
+		w := newWebhookServer(t)
+		w.DuplicatePolicy = tc.policy
+		w.radarr.set("/movie?tmdbId=438631", `[{"id": 10, "title": "Dune", "imdbId": "tt1160419", "tmdbId": 438631, "qualityProfileId": 4, "rootFolderPath": "/movies", "isAvailable": true, "monitored": true}]`)
+		// "old" is created a minute before "new"
+		w.notion.AddPage("old", "tt1160419", nil)
+		w.notion.AddPage("new", "tt1160419", nil)
+		if tc.tracked {
+			for _, pageID := range []string{"new", "old"} {
+				w.Tracker.UpdateTitle(activity.Title{PageID: pageID, ImdbID: "tt1160419", MediaType: constant.MediaTypeMovie, Status: constant.MediaStatusQueued})
+			}
+		}
+		w.deliver(t, "radarr", grab)
+		var written []string
+		for _, pageID := range []string{"old", "new"} {
+			if len(w.notion.Writes(pageID)) != 0 {
+				written = append(written, pageID)
+			}
+		}
+		if strings.Join(written, ", ") != strings.Join(tc.written, ", ") {
+			t.Errorf("%s: wrote %v, want %v", tc.name, written, tc.written)
+		}
+	}
+}
+
+const sonarrSeriesJSON = `"series": {"id": 20, "title": "Severance", "year": 2022, "path": "/tv/Severance", "imdbId": "tt11280740", "tvdbId": 371980, "tmdbId": 95396, "type": "standard"}`
+
+func TestSonarrWebhookEvents(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("p1", "tt11280740", nil)
+	series := func(percent int) {
+		w.sonarr.set("/series?tvdbId=371980", `[{"id": 20, "title": "Severance", "imdbId": "tt11280740", "tvdbId": 371980, "qualityProfileId": 6, "rootFolderPath": "/tv", "statistics": {"percentOfEpisodes": `+strconv.Itoa(percent)+`}}]`)
+	}
+	w.sonarr.set("/queue?seriesId=20", `{"totalRecords": 0}`)
+	profiles := map[string]string{"Quality Profile": "TV Series: HD-1080p", "Root Folder": "TV Series: /tv"}
+
+	series(0)
+	for _, tc := range []struct {
+		webhookCase
+		percent int
+	}{
+		{webhookCase{name: "SeriesAdd", status: constant.MediaStatusQueued, options: profiles,
+			payload: `{"eventType": "SeriesAdd", ` + sonarrSeriesJSON + `}`}, 0},
+		{webhookCase{name: "Grab", status: constant.MediaStatusDownloading,
+			texts:   map[string]string{"Release": "Severance.S01E01-E02.1080p.WEB-GRP", "Episodes": "S01E01, S01E02"},
+			payload: `{"eventType": "Grab", ` + sonarrSeriesJSON + `, "episodes": [{"id": 1, "episodeNumber": 1, "seasonNumber": 1, "title": "Good News About Hell"}, {"id": 2, "episodeNumber": 2, "seasonNumber": 1, "title": "Half Loop"}], "release": {"quality": "WEBDL-1080p", "releaseTitle": "Severance.S01E01-E02.1080p.WEB-GRP", "indexer": "IndexerA", "size": 1073741824}}`}, 0},
+		// season pack, the size of the files is summed
+		{webhookCase{name: "Download partial", status: constant.MediaStatusDownloading, size: `{"number":2}`,
+			options: map[string]string{"Quality": "WEBDL-1080p"},
+			payload: `{"eventType": "Download", ` + sonarrSeriesJSON + `, "episodes": [{"episodeNumber": 1, "seasonNumber": 1}, {"episodeNumber": 2, "seasonNumber": 1}], "episodeFiles": [{"id": 1, "quality": "WEBDL-1080p", "sceneName": "Severance.S01E01.1080p.WEB-GRP", "size": 1073741824}, {"id": 2, "quality": "WEBDL-1080p", "size": 1073741824}], "isUpgrade": false}`}, 50},
+		{webhookCase{name: "Download complete", status: constant.MediaStatusDownloaded, options: profiles,
+			payload: `{"eventType": "Download", ` + sonarrSeriesJSON + `, "episodes": [{"episodeNumber": 9, "seasonNumber": 1}], "episodeFile": {"id": 9, "quality": "WEBDL-1080p", "size": 1073741824}, "isUpgrade": false}`}, 100},
+		{webhookCase{name: "EpisodeFileDelete upgrade", status: constant.MediaStatusDownloaded,
+			payload: `{"eventType": "EpisodeFileDelete", ` + sonarrSeriesJSON + `, "deleteReason": "upgrade"}`}, 90},
+		{webhookCase{name: "EpisodeFileDelete", status: constant.MediaStatusNotDownloaded,
+			payload: `{"eventType": "EpisodeFileDelete", ` + sonarrSeriesJSON + `, "deleteReason": "manual"}`}, 90},
+		{webhookCase{name: "Rename", status: constant.MediaStatusDownloaded, options: profiles,
+			payload: `{"eventType": "Rename", ` + sonarrSeriesJSON + `}`}, 100},
+		{webhookCase{name: "ManualInteractionRequired", status: constant.MediaStatusManualImport,
+			payload: `{"eventType": "ManualInteractionRequired", ` + sonarrSeriesJSON + `, "downloadStatus": "warning", "downloadStatusMessages": [{"title": "Severance.S02E01.mkv", "messages": ["Unknown episode"]}]}`}, 100},
+		{webhookCase{name: "SeriesDelete", status: constant.MediaStatusNotDownloaded, options: map[string]string{"Quality Profile": "", "Root Folder": ""},
+			payload: `{"eventType": "SeriesDelete", ` + sonarrSeriesJSON + `, "deletedFiles": true}`}, 100},
+	} {
+		series(tc.percent)
+		w.deliver(t, "sonarr", tc.payload)
+		w.check(t, "p1", tc.webhookCase)
+	}
+}
+
+func TestWebhookEventsNotQueued(t *testing.T) {
+	w := newWebhookServer(t)
+	for _, payload := range []string{
+		`{"eventType": "Test", "movie": {"id": 1, "title": "Test Title"}}`,
+		`{"eventType": "Health", "level": "warning", "message": "Indexers unavailable", "type": "IndexerStatusCheck", "wikiUrl": "https://wiki.servarr.com"}`,
+		`{"eventType": "HealthRestored", "level": "warning", "message": "Indexers available", "type": "IndexerStatusCheck"}`,
+		`{"eventType": "ApplicationUpdate", "message": "Radarr updated", "previousVersion": "5.0.0", "newVersion": "5.1.0"}`,
+	} {
+		rec := httptest.NewRecorder()
+		w.radarrHandler(rec, httptest.NewRequest(http.MethodPost, "/radarr", strings.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d", payload, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	w.sonarrHandler(rec, httptest.NewRequest(http.MethodPost, "/sonarr", strings.NewReader(`{"series": {}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing eventType to be rejected, got %d", rec.Code)
+	}
+	if w.Q.Len() != 0 || len(w.notion.Requests()) != 0 {
+		t.Fatalf("expected nothing queued nor written, got %d jobs and %v", w.Q.Len(), w.notion.Requests())
+	}
+
+	// a title that isn't in the watchlist is dropped
+	w.deliver(t, "radarr", `{"eventType": "Grab", "movie": {"id": 3, "title": "Unknown", "imdbId": "tt000", "tmdbId": 3}, "release": {"releaseTitle": "x"}}`)
+	for _, r := range w.notion.Requests() {
+		if r.Method == http.MethodPatch {
+			t.Fatalf("unexpected write %+v", r)
+		}
+	}
+}
+
+func TestInvalidPayloadLogged(t *testing.T) {
+	w := newWebhookServer(t)
+	var logs bytes.Buffer
+	w.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	payload := `{"movie": {"overview": "` + strings.Repeat("é", 1000) + `"}}`
+	rec := httptest.NewRecorder()
+	w.radarrHandler(rec, httptest.NewRequest(http.MethodPost, "/radarr", strings.NewReader(payload)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the payload to be rejected, got %d", rec.Code)
+	}
+	// the payload is logged as text, cut to its start
+	if !strings.Contains(logs.String(), `body="{\"movie\": {\"overview\": \"éé`) || logs.Len() > 2*maxLoggedBody {
+		t.Fatalf("unexpected log %s", logs.String())
+	}
+}
+
+func TestDroppedJobNotified(t *testing.T) {
+	w := newWebhookServer(t)
+	var mu sync.Mutex
+	var sent []notify.Message
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var msg notify.Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		sent = append(sent, msg)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	notifier, err := notify.NewNotifier([]string{strings.Replace(srv.URL, "http://", "json://", 1) + "/hook"}, "", w.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Notifier = notifier
+	failing := w.trackFailures("radarr", func(job queue.Job) error { return errors.New("radarr unreachable") })
+	payload := json.RawMessage(`{"eventType": "Download", ` + radarrMovieJSON + `}`)
+
+	// retried attempts are only recorded, the last one before the drop is notified
+	for attempts := 0; attempts < w.Q.MaxAttempts; attempts++ {
+		failing(queue.Job{Kind: radarrJob, Key: "radarr:tt1160419", Payload: payload, Attempts: attempts})
+	}
+	notifier.Close(time.Second)
+	if len(sent) != 1 {
+		t.Fatalf("expected one notification, got %d", len(sent))
+	}
+	if got := sent[0]; got.Event != notify.EventError || got.Title != "Dune" || got.ImdbID != "tt1160419" || got.Error != "radarr unreachable" {
+		t.Fatalf("unexpected notification %+v", got)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+)
+
+// healthzHandler only reports that the process is alive
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+type readyzResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readiness checks Notion and each enabled *arr are reachable and the defaults are loaded
+func (s *Server) readiness() readyzResponse {
+	resp := readyzResponse{Ready: true, Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			resp.Ready = false
+			resp.Checks[name] = err.Error()
+			return
+		}
+		resp.Checks[name] = "ok"
+	}
+	check("notion", s.N.Ping())
+	if s.RadarrInit {
+		check("radarr", s.R.Ping())
+	}
+	if s.SonarrInit {
+		check("sonarr", s.S.Ping())
+	}
+	var defaultsErr error
+	if len(s.N.Qpid) == 0 || len(s.N.Rpid) == 0 {
+		defaultsErr = errors.New("quality profiles and root folders not loaded")
+	}
+	check("defaults", defaultsErr)
+	return resp
+}
+
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := s.readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) registerMetrics() {
+	metrics.NewGaugeFunc("notionwatchlistarr_webhook_queue_length", "Webhook events waiting to be processed.", func() float64 {
+		return float64(s.Q.Len())
+	})
+}
@@ -0,0 +1,508 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+	"github.com/flxp49/notion-watchlistarr/internal/util"
+)
+
+// queue job kind of the media server events
+const playbackJob = "playback"
+
+// share of the runtime after which a stopped playback counts as watched
+const watchedThreshold = 0.9
+
+// Playback is a title watched on Jellyfin or Plex, normalised before it is queued
+//
+// Title - the movie or series, ImdbID/TmdbID/TvdbID - provider IDs of the movie (of the episode for series, the TVDB ID tells apart
+// the series sharing a title), SeriesKey - ID of the series on the media server, Season/Episode - the watched episode
+type Playback struct {
+	Source    string    `json:"source"`
+	MediaType string    `json:"mediaType"`
+	Title     string    `json:"title"`
+	SeriesKey string    `json:"seriesKey,omitempty"`
+	Year      int       `json:"year,omitempty"`
+	ImdbID    string    `json:"imdbId,omitempty"`
+	TmdbID    int       `json:"tmdbId,omitempty"`
+	TvdbID    int       `json:"tvdbId,omitempty"`
+	Season    int       `json:"season,omitempty"`
+	Episode   int       `json:"episode,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// JellyfinEvent is the payload of the Jellyfin webhook plugin (Generic destination, default template)
+type JellyfinEvent struct {
+	NotificationType      string `json:"NotificationType"`
+	ItemType              string `json:"ItemType"`
+	Name                  string `json:"Name"`
+	Year                  int    `json:"Year"`
+	SeriesName            string `json:"SeriesName"`
+	SeriesID              string `json:"SeriesId"`
+	SeasonNumber          int    `json:"SeasonNumber"`
+	EpisodeNumber         int    `json:"EpisodeNumber"`
+	ProviderImdb          string `json:"Provider_imdb"`
+	ProviderTmdb          string `json:"Provider_tmdb"`
+	ProviderTvdb          string `json:"Provider_tvdb"`
+	PlayedToCompletion    bool   `json:"PlayedToCompletion"`
+	PlaybackPositionTicks int64  `json:"PlaybackPositionTicks"`
+	RunTimeTicks          int64  `json:"RunTimeTicks"`
+	// UserDataSaved, set when the item is marked played
+	Played bool `json:"Played"`
+}
+
+// PlexEvent is the "payload" part of a Plex webhook
+type PlexEvent struct {
+	Event    string `json:"event"`
+	Metadata struct {
+		Type             string `json:"type"`
+		Title            string `json:"title"`
+		Year             int    `json:"year"`
+		GrandparentTitle string `json:"grandparentTitle"`
+		GrandparentGuid  string `json:"grandparentGuid"`
+		ParentIndex      int    `json:"parentIndex"`
+		Index            int    `json:"index"`
+		ViewOffset       int64  `json:"viewOffset"`
+		Duration         int64  `json:"duration"`
+		Guid             []struct {
+			ID string `json:"id"`
+		} `json:"Guid"`
+	} `json:"Metadata"`
+}
+
+// watchedShare reports whether the position reached watchedThreshold of the runtime
+func watchedShare(position int64, runtime int64) bool {
+	return runtime > 0 && float64(position) >= watchedThreshold*float64(runtime)
+}
+
+// jellyfinPlayback returns the playback of a PlaybackStop event played to completion (or a title marked played), false for the other events
+func jellyfinPlayback(event JellyfinEvent, at time.Time) (Playback, bool) {
+	switch event.NotificationType {
+	case "PlaybackStop":
+		if !event.PlayedToCompletion && !watchedShare(event.PlaybackPositionTicks, event.RunTimeTicks) {
+			return Playback{}, false
+		}
+	case "UserDataSaved":
+		if !event.Played {
+			return Playback{}, false
+		}
+	default:
+		return Playback{}, false
+	}
+	playback := Playback{Source: "jellyfin", Title: event.Name, Year: event.Year, ImdbID: event.ProviderImdb, At: at}
+	playback.TmdbID, _ = strconv.Atoi(event.ProviderTmdb)
+	playback.TvdbID, _ = strconv.Atoi(event.ProviderTvdb)
+	switch event.ItemType {
+	case "Movie":
+		playback.MediaType = constant.MediaTypeMovie
+	case "Episode":
+		playback.MediaType, playback.Title, playback.Season, playback.Episode = constant.MediaTypeTV, event.SeriesName, event.SeasonNumber, event.EpisodeNumber
+		if event.SeriesID != "" {
+			playback.SeriesKey = "jellyfin:" + event.SeriesID
+		}
+	default:
+		return Playback{}, false
+	}
+	return playback, true
+}
+
+// plexPlayback returns the playback of a media.scrobble event (or a media.stop past watchedThreshold), false for the other events
+func plexPlayback(event PlexEvent, at time.Time) (Playback, bool) {
+	switch event.Event {
+	case "media.scrobble":
+	case "media.stop":
+		if !watchedShare(event.Metadata.ViewOffset, event.Metadata.Duration) {
+			return Playback{}, false
+		}
+	default:
+		return Playback{}, false
+	}
+	playback := Playback{Source: "plex", Title: event.Metadata.Title, Year: event.Metadata.Year, At: at}
+	for _, guid := range event.Metadata.Guid {
+		provider, id, _ := strings.Cut(guid.ID, "://")
+		switch provider {
+		case "imdb":
+			playback.ImdbID = id
+		case "tmdb":
+			playback.TmdbID, _ = strconv.Atoi(id)
+		case "tvdb":
+			playback.TvdbID, _ = strconv.Atoi(id)
+		}
+	}
+	switch event.Metadata.Type {
+	case "movie":
+		playback.MediaType = constant.MediaTypeMovie
+	case "episode":
+		playback.MediaType, playback.Title, playback.Season, playback.Episode = constant.MediaTypeTV, event.Metadata.GrandparentTitle, event.Metadata.ParentIndex, event.Metadata.Index
+		if event.Metadata.GrandparentGuid != "" {
+			playback.SeriesKey = "plex:" + event.Metadata.GrandparentGuid
+		}
+	default:
+		return Playback{}, false
+	}
+	return playback, true
+}
+
+// jellyfinHandler queues the titles watched on Jellyfin, the other events are acknowledged and dropped
+func (s *Server) jellyfinHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	var event JellyfinEvent
+	err := util.ParseJson(body, &event)
+	if err != nil || event.NotificationType == "" {
+		if err == nil {
+			err = errors.New("NotificationType missing in payload")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		s.Logger.Error("JellyfinWebhook", "body", loggedBody(body), "error", err)
+		return
+	}
+	metrics.WebhookEvents.Inc("jellyfin", event.NotificationType)
+	playback, watched := jellyfinPlayback(event, time.Now())
+	if !watched {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.queuePlayback(w, playback)
+}
+
+// plexHandler queues the titles watched on Plex, the payload is the "payload" part of the multipart body
+func (s *Server) plexHandler(w http.ResponseWriter, r *http.Request) {
+	// the thumbnail part of the media events is spilled to disk past 10MB
+	err := r.ParseMultipartForm(10 << 20)
+	var event PlexEvent
+	if err == nil {
+		err = util.ParseJson([]byte(r.FormValue("payload")), &event)
+	}
+	if err != nil || event.Event == "" {
+		if err == nil {
+			err = errors.New("event missing in payload")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		s.Logger.Error("PlexWebhook", "error", err)
+		return
+	}
+	metrics.WebhookEvents.Inc("plex", event.Event)
+	playback, watched := plexPlayback(event, time.Now())
+	if !watched {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.queuePlayback(w, playback)
+}
+
+// queuePlayback persists the playback, the playbacks of a title are processed in order
+func (s *Server) queuePlayback(w http.ResponseWriter, playback Playback) {
+	s.Logger.Info("MediaServerWebhook", "data", playback)
+	data, err := json.Marshal(playback)
+	if err == nil {
+		err = s.Q.Enqueue(playbackJob, playbackKey(playback), data)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		s.Logger.Error("MediaServerWebhook", "Failed to queue event", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// playbackKey orders the playbacks of a title in the queue: episodes by the series ID of the media server, movies by provider ID.
+// The title is the last resort
+func playbackKey(playback Playback) string {
+	switch {
+	case playback.MediaType == constant.MediaTypeTV && playback.SeriesKey != "":
+		return "playback:" + playback.SeriesKey
+	case playback.MediaType == constant.MediaTypeMovie && playback.ImdbID != "":
+		return "playback:imdb:" + playback.ImdbID
+	case playback.MediaType == constant.MediaTypeMovie && playback.TmdbID != 0:
+		return "playback:tmdb:" + strconv.Itoa(playback.TmdbID)
+	}
+	return "playback:title:" + strings.ToLower(strings.TrimSpace(playback.Title))
+}
+
+// playbackTitle matches the playback with a watchlist title by provider IDs, see movieImdbID and seriesIDs.
+// Returns the IMDb ID (empty when not matched) and the TVDB ID of a series
+func (s *Server) playbackTitle(playback Playback) (string, int, error) {
+	if playback.MediaType == constant.MediaTypeMovie {
+		imdbID, err := s.movieImdbID(playback)
+		return imdbID, 0, err
+	}
+	return s.seriesIDs(playback)
+}
+
+// movieImdbID returns the IMDb ID of the movie, found from the TMDB ID in the tracked titles or Radarr when the media server has no IMDb ID
+func (s *Server) movieImdbID(playback Playback) (string, error) {
+	if playback.ImdbID != "" || playback.TmdbID == 0 {
+		return playback.ImdbID, nil
+	}
+	for _, title := range s.Tracker.Titles() {
+		if title.MediaType == constant.MediaTypeMovie && title.TmdbID == playback.TmdbID && title.ImdbID != "" {
+			return title.ImdbID, nil
+		}
+	}
+	if !s.RadarrInit {
+		return "", nil
+	}
+	movie, err := s.R.GetMovie(playback.TmdbID)
+	if err != nil {
+		return "", errors.Join(errors.New("failed to fetch movie from radarr"), err)
+	}
+	if len(movie) == 0 {
+		return "", nil
+	}
+	return movie[0].ImdbID, nil
+}
+
+// seriesIDs matches the series of an episode with a tracked series by the TVDB ID of the episode, see seriesOfEpisode.
+// The title is only used when the media server has no TVDB ID for the episode or Sonarr isn't set up, an ambiguous title is skipped
+func (s *Server) seriesIDs(playback Playback) (string, int, error) {
+	var tracked []activity.Title
+	for _, title := range s.Tracker.Titles() {
+		if title.MediaType == constant.MediaTypeTV && title.ImdbID != "" {
+			tracked = append(tracked, title)
+		}
+	}
+	if playback.TvdbID != 0 && s.SonarrInit {
+		sonarrID, err := s.seriesOfEpisode(tracked, playback.TvdbID)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, title := range tracked {
+			if sonarrID != 0 && title.SonarrID == sonarrID {
+				return title.ImdbID, title.TvdbID, nil
+			}
+		}
+		return "", 0, nil
+	}
+	var imdbID string
+	var tvdbID int
+	for _, title := range tracked {
+		if !sameTitle(title.Title, playback.Title) {
+			continue
+		}
+		// the duplicate pages of a series share its IMDb ID
+		if imdbID != "" && title.ImdbID != imdbID {
+			s.Logger.Warn("MediaServerWebhook", "Status", "Several watchlist series share the title, playback skipped", "title", playback.Title, "source", playback.Source)
+			return "", 0, nil
+		}
+		imdbID, tvdbID = title.ImdbID, title.TvdbID
+	}
+	return imdbID, tvdbID, nil
+}
+
+// seriesOfEpisode returns the Sonarr ID of the tracked series having the episode, 0 when none has it.
+// The episodes of a tracked series are fetched from Sonarr once, until a Sonarr event of the series drops them, see forgetEpisodes
+func (s *Server) seriesOfEpisode(tracked []activity.Title, episodeTvdbID int) (int, error) {
+	s.episodesMu.Lock()
+	defer s.episodesMu.Unlock()
+	if sonarrID, exists := s.episodeSeries[episodeTvdbID]; exists {
+		return sonarrID, nil
+	}
+	if s.episodeSeries == nil {
+		s.episodeSeries, s.indexedSeries = make(map[int]int), make(map[int]bool)
+	}
+	for _, title := range tracked {
+		if title.SonarrID == 0 || s.indexedSeries[title.SonarrID] {
+			continue
+		}
+		episodes, err := s.S.GetEpisodes(title.SonarrID)
+		if err != nil {
+			return 0, errors.Join(errors.New("failed to fetch episodes from sonarr"), err)
+		}
+		for _, episode := range episodes {
+			if episode.TvdbID != 0 {
+				s.episodeSeries[episode.TvdbID] = title.SonarrID
+			}
+		}
+		s.indexedSeries[title.SonarrID] = true
+	}
+	return s.episodeSeries[episodeTvdbID], nil
+}
+
+// forgetEpisodes drops the fetched episodes of the series, they are fetched again by the next playback of an unknown episode
+func (s *Server) forgetEpisodes(sonarrID int) {
+	s.episodesMu.Lock()
+	defer s.episodesMu.Unlock()
+	if !s.indexedSeries[sonarrID] {
+		return
+	}
+	for episodeTvdbID, seriesID := range s.episodeSeries {
+		if seriesID == sonarrID {
+			delete(s.episodeSeries, episodeTvdbID)
+		}
+	}
+	delete(s.indexedSeries, sonarrID)
+}
+
+func sameTitle(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func (s *Server) processPlaybackEvent(job queue.Job) error {
+	var playback Playback
+	err := util.ParseJson(job.Payload, &playback)
+	if err != nil {
+		return err
+	}
+	imdbID, tvdbID, err := s.playbackTitle(playback)
+	if err != nil {
+		return err
+	}
+	if imdbID == "" {
+		s.Logger.Warn("MediaServerWebhook", "Status", "Watched title not found in the watchlist", "title", playback.Title, "source", playback.Source)
+		return nil
+	}
+	pageIDs, cached, err := s.watchlistPages(imdbID)
+	if err != nil || len(pageIDs) == 0 {
+		return err
+	}
+	state := notion.WatchState{Watched: playback.MediaType == constant.MediaTypeMovie, Date: playback.At}
+	detail := ""
+	for _, pageID := range pageIDs {
+		if playback.MediaType == constant.MediaTypeTV {
+			// the watched episodes are kept on the page
+			page, err := s.N.GetPage(pageID)
+			if err != nil {
+				s.forgetStalePage(pageID, cached)
+				return errors.Join(errors.New("failed to fetch watchlist page"), err)
+			}
+			episodes := parseEpisodes(page.EpisodesWatched())
+			episodes[episodeNumber{playback.Season, playback.Episode}] = true
+			state.Episodes = formatEpisodes(episodes)
+			detail = formatEpisodes(episodeSet{episodeNumber{playback.Season, playback.Episode}: true})
+			state.Watched = page.Properties.Watched.Checkbox
+			if !state.Watched {
+				state.Watched, err = s.seriesWatched(tvdbID, episodes)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = s.N.UpdateWatchState(pageID, state)
+		if err != nil {
+			s.forgetStalePage(pageID, cached)
+			return errors.Join(errors.New("failed to update watched state in watchlist"), err)
+		}
+		// the sync keeps it while the title is downloaded, see app.keepWatched
+		title, _ := s.Tracker.Title(pageID)
+		if state.Watched && notion.StatusEnabled(constant.MediaStatusWatched) && (title.Status == constant.MediaStatusDownloaded || title.Status == constant.MediaStatusUpgraded) {
+			err = s.N.UpdateDownloadState(playback.MediaType, pageID, false, notion.DownloadState{Status: constant.MediaStatusWatched})
+			if err != nil {
+				return errors.Join(errors.New("failed to update download status in watchlist"), err)
+			}
+			s.Tracker.UpdateTitle(activity.Title{PageID: pageID, Status: constant.MediaStatusWatched})
+		}
+	}
+	s.Tracker.Record(activity.Event{Source: activity.SourceWebhook, Service: playback.Source, Type: "watched", ImdbID: imdbID, Title: playback.Title, Detail: detail})
+	return nil
+}
+
+// seriesWatched reports whether every episode of the ended series is watched, false when the series isn't known to Sonarr
+func (s *Server) seriesWatched(tvdbID int, episodes episodeSet) (bool, error) {
+	if !s.SonarrInit || tvdbID == 0 {
+		return false, nil
+	}
+	series, err := s.S.GetSeries(tvdbID)
+	if err != nil {
+		return false, errors.Join(errors.New("failed to fetch series from sonarr"), err)
+	}
+	if len(series) == 0 {
+		return false, nil
+	}
+	return allEpisodesWatched(series[0], episodes), nil
+}
+
+// allEpisodesWatched reports whether the series ended and the episodes of its seasons (specials aside) are all watched
+func allEpisodesWatched(series sonarr.GetSeriesResponse, episodes episodeSet) bool {
+	if !series.Ended {
+		return false
+	}
+	seasons := 0
+	for _, season := range series.Seasons {
+		if season.SeasonNumber == 0 || season.Statistics.TotalEpisodeCount == 0 {
+			continue
+		}
+		seasons++
+		for episode := 1; episode <= season.Statistics.TotalEpisodeCount; episode++ {
+			if !episodes[episodeNumber{season.SeasonNumber, episode}] {
+				return false
+			}
+		}
+	}
+	return seasons != 0
+}
+
+type episodeNumber struct {
+	season  int
+	episode int
+}
+
+// episodeSet holds the watched episodes of a series
+type episodeSet map[episodeNumber]bool
+
+var episodeRange = regexp.MustCompile(`^S(\d+)E(\d+)(?:-E(\d+))?$`)
+
+// parseEpisodes reads the Episodes Watched prop, ex: "S01E01-E08, S02E01". Unknown parts are dropped
+func parseEpisodes(text string) episodeSet {
+	episodes := make(episodeSet)
+	for _, part := range strings.Split(text, ",") {
+		match := episodeRange.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			continue
+		}
+		season, _ := strconv.Atoi(match[1])
+		first, _ := strconv.Atoi(match[2])
+		last := first
+		if match[3] != "" {
+			last, _ = strconv.Atoi(match[3])
+		}
+		for episode := first; episode <= last; episode++ {
+			episodes[episodeNumber{season, episode}] = true
+		}
+	}
+	return episodes
+}
+
+// formatEpisodes writes the episodes in order, consecutive episodes of a season as a range
+func formatEpisodes(episodes episodeSet) string {
+	sorted := make([]episodeNumber, 0, len(episodes))
+	for episode, watched := range episodes {
+		if watched {
+			sorted = append(sorted, episode)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].season != sorted[j].season {
+			return sorted[i].season < sorted[j].season
+		}
+		return sorted[i].episode < sorted[j].episode
+	})
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1].season == sorted[i].season && sorted[j+1].episode == sorted[j].episode+1 {
+			j++
+		}
+		part := fmt.Sprintf("S%02dE%02d", sorted[i].season, sorted[i].episode)
+		if j > i {
+			part += fmt.Sprintf("-E%02d", sorted[j].episode)
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/constant"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
+	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
+)
+
+func TestJellyfinPlayback(t *testing.T) {
+	at := time.Date(2024, 5, 10, 21, 0, 0, 0, time.UTC)
+	movie := JellyfinEvent{NotificationType: "PlaybackStop", ItemType: "Movie", Name: "Dune", Year: 2021, ProviderImdb: "tt1160419", ProviderTmdb: "438631", PlaybackPositionTicks: 95, RunTimeTicks: 100}
+	playback, watched := jellyfinPlayback(movie, at)
+	if !watched || playback.MediaType != constant.MediaTypeMovie || playback.ImdbID != "tt1160419" || playback.TmdbID != 438631 || !playback.At.Equal(at) {
+		t.Fatalf("unexpected movie playback %+v", playback)
+	}
+	movie.PlaybackPositionTicks = 30
+	if _, watched := jellyfinPlayback(movie, at); watched {
+		t.Errorf("stopped at 30%%, not watched")
+	}
+
+	episode := JellyfinEvent{NotificationType: "UserDataSaved", ItemType: "Episode", Name: "Hello", SeriesName: "Severance", SeriesID: "4b1c", SeasonNumber: 2, EpisodeNumber: 1, Played: true}
+	playback, watched = jellyfinPlayback(episode, at)
+	if !watched || playback.MediaType != constant.MediaTypeTV || playback.Title != "Severance" || playback.SeriesKey != "jellyfin:4b1c" || playback.Season != 2 || playback.Episode != 1 {
+		t.Fatalf("unexpected episode playback %+v", playback)
+	}
+	if _, watched := jellyfinPlayback(JellyfinEvent{NotificationType: "PlaybackStart", ItemType: "Movie"}, at); watched {
+		t.Errorf("PlaybackStart is not a watched event")
+	}
+}
+
+func TestPlexPlayback(t *testing.T) {
+	var event PlexEvent
+	err := json.Unmarshal([]byte(`{"event":"media.scrobble","Metadata":{"type":"episode","title":"Hello","grandparentTitle":"Severance","grandparentGuid":"plex://show/5d9c","parentIndex":2,"index":1,"Guid":[{"id":"imdb://tt2"},{"id":"tvdb://99"}]}}`), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playback, watched := plexPlayback(event, time.Now())
+	if !watched || playback.MediaType != constant.MediaTypeTV || playback.Title != "Severance" || playback.SeriesKey != "plex:plex://show/5d9c" || playback.Season != 2 || playback.Episode != 1 || playback.TvdbID != 99 {
+		t.Fatalf("unexpected episode playback %+v", playback)
+	}
+	event.Event = "media.stop"
+	event.Metadata.ViewOffset, event.Metadata.Duration = 100, 1000
+	if _, watched := plexPlayback(event, time.Now()); watched {
+		t.Errorf("stopped at 10%%, not watched")
+	}
+}
+
+func TestPlexHandler(t *testing.T) {
+	q, err := queue.NewQueue(filepath.Join(t.TempDir(), "queue.json"), 3, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Q: q, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	post := func(payload string) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("payload", payload)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/plex", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.plexHandler(rec, req)
+		return rec.Code
+	}
+	if code := post(`{"event":"media.scrobble","Metadata":{"type":"movie","title":"Dune","Guid":[{"id":"imdb://tt1160419"}]}}`); code != http.StatusAccepted || q.Len() != 1 {
+		t.Fatalf("expected the scrobble to be queued, got %d with %d jobs", code, q.Len())
+	}
+	if code := post(`{"event":"media.play","Metadata":{"type":"movie","title":"Dune"}}`); code != http.StatusOK || q.Len() != 1 {
+		t.Fatalf("expected media.play to be dropped, got %d with %d jobs", code, q.Len())
+	}
+	if code := post(`{}`); code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d", code)
+	}
+}
+
+func TestPlaybackKey(t *testing.T) {
+	for _, tc := range []struct {
+		playback Playback
+		key      string
+	}{
+		{Playback{MediaType: constant.MediaTypeMovie, Title: "Dune", ImdbID: "tt1160419", TmdbID: 438631}, "playback:imdb:tt1160419"},
+		{Playback{MediaType: constant.MediaTypeMovie, Title: "Dune", TmdbID: 438631}, "playback:tmdb:438631"},
+		// the provider IDs of an episode aren't the series ones
+		{Playback{MediaType: constant.MediaTypeTV, Title: "The Office", SeriesKey: "plex:plex://show/5d9c", TvdbID: 99}, "playback:plex:plex://show/5d9c"},
+		{Playback{MediaType: constant.MediaTypeTV, Title: " The Office ", TvdbID: 99}, "playback:title:the office"},
+	} {
+		if key := playbackKey(tc.playback); key != tc.key {
+			t.Errorf("%+v: got %q, want %q", tc.playback, key, tc.key)
+		}
+	}
+}
+
+func TestSeriesPlayback(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("us", "tt0386676", nil)
+	w.notion.AddPage("uk", "tt0290978", nil)
+	w.notion.AddPage("sev", "tt11280740", nil)
+	w.Tracker.UpdateTitle(activity.Title{PageID: "us", ImdbID: "tt0386676", Title: "The Office", MediaType: constant.MediaTypeTV, SonarrID: 1, TvdbID: 73244})
+	w.Tracker.UpdateTitle(activity.Title{PageID: "uk", ImdbID: "tt0290978", Title: "The Office", MediaType: constant.MediaTypeTV, SonarrID: 2, TvdbID: 78107})
+	w.Tracker.UpdateTitle(activity.Title{PageID: "sev", ImdbID: "tt11280740", Title: "Severance", MediaType: constant.MediaTypeTV, SonarrID: 3, TvdbID: 371980})
+	w.sonarr.set("/episode?seriesId=1", `[{"id": 10, "seriesId": 1, "tvdbId": 111, "seasonNumber": 1, "episodeNumber": 1}]`)
+	w.sonarr.set("/episode?seriesId=2", `[{"id": 20, "seriesId": 2, "tvdbId": 222, "seasonNumber": 1, "episodeNumber": 1}]`)
+	w.sonarr.set("/episode?seriesId=3", `[{"id": 30, "seriesId": 3, "tvdbId": 333, "seasonNumber": 1, "episodeNumber": 1}]`)
+	for _, series := range []string{"73244", "78107", "371980"} {
+		w.sonarr.set("/series?tvdbId="+series, `[{"ended": false}]`)
+	}
+	process := func(playback Playback) {
+		t.Helper()
+		playback.MediaType, playback.Season, playback.Episode, playback.At = constant.MediaTypeTV, 1, 1, time.Now()
+		data, _ := json.Marshal(playback)
+		err := w.processPlaybackEvent(queue.Job{Kind: playbackJob, Payload: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	watched := func() string {
+		var pages []string
+		for _, pageID := range []string{"us", "uk", "sev"} {
+			if w.notion.Text(pageID, "Episodes Watched") != "" {
+				pages = append(pages, pageID)
+			}
+		}
+		return strings.Join(pages, ", ")
+	}
+
+	// the episode ID matches the series, whatever the title
+	process(Playback{Source: "plex", Title: "The Office (UK)", TvdbID: 222})
+	if got := watched(); got != "uk" {
+		t.Fatalf("expected the UK series to be marked, got %q", got)
+	}
+	// an episode of a series off the watchlist isn't matched by its title
+	process(Playback{Source: "plex", Title: "Severance", TvdbID: 999})
+	if got := watched(); got != "uk" {
+		t.Fatalf("expected the unknown episode to be skipped, got %q", got)
+	}
+	// the episodes are fetched once per series, never the whole library
+	if w.sonarr.requested("/episode?seriesId=2") != 1 || w.sonarr.requested("/series") != 0 {
+		t.Fatalf("expected the episodes to be fetched once, got %d", w.sonarr.requested("/episode?seriesId=2"))
+	}
+	// a Sonarr event of the series drops its episodes, a new episode is found
+	w.sonarr.set("/episode?seriesId=3", `[{"id": 30, "seriesId": 3, "tvdbId": 333, "seasonNumber": 1, "episodeNumber": 1}, {"id": 31, "seriesId": 3, "tvdbId": 334, "seasonNumber": 1, "episodeNumber": 2}]`)
+	w.forgetEpisodes(3)
+	process(Playback{Source: "jellyfin", Title: "Severance", TvdbID: 334})
+	if got := watched(); got != "uk, sev" {
+		t.Fatalf("expected the new episode to be matched, got %q", got)
+	}
+
+	// no episode ID, the title is ambiguous
+	process(Playback{Source: "jellyfin", Title: "The Office"})
+	if got := watched(); got != "uk, sev" {
+		t.Fatalf("expected the ambiguous playback to be skipped, got %q", got)
+	}
+	// without Sonarr the episode ID can't be matched, the title is used
+	w.SonarrInit = false
+	w.Tracker.Forget("uk")
+	process(Playback{Source: "plex", Title: "the office", TvdbID: 111})
+	if got := watched(); got != "us, uk, sev" {
+		t.Fatalf("expected the tracked series to be matched by title, got %q", got)
+	}
+}
+
+func TestMoviePlaybackTmdb(t *testing.T) {
+	w := newWebhookServer(t)
+	w.notion.AddPage("p1", "tt1160419", nil)
+	w.radarr.set("/movie?tmdbId=438631", `[{"id": 10, "title": "Dune", "imdbId": "tt1160419", "tmdbId": 438631}]`)
+	data, _ := json.Marshal(Playback{Source: "jellyfin", MediaType: constant.MediaTypeMovie, Title: "Dune", TmdbID: 438631, At: time.Now()})
+	err := w.processPlaybackEvent(queue.Job{Kind: playbackJob, Payload: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.notion.Property("p1", "Watched"); got != `{"checkbox":true}` {
+		t.Fatalf("expected the movie to be matched through Radarr, got %s", got)
+	}
+}
+
+func TestEpisodeSet(t *testing.T) {
+	episodes := parseEpisodes("S01E01-E03, S02E05, garbage")
+	episodes[episodeNumber{1, 4}] = true
+	episodes[episodeNumber{2, 7}] = true
+	if got := formatEpisodes(episodes); got != "S01E01-E04, S02E05, S02E07" {
+		t.Fatalf("unexpected episodes %q", got)
+	}
+
+	var series sonarr.GetSeriesResponse
+	err := json.Unmarshal([]byte(`{"ended":true,"seasons":[{"seasonNumber":0,"statistics":{"totalEpisodeCount":2}},{"seasonNumber":1,"statistics":{"totalEpisodeCount":4}},{"seasonNumber":2,"statistics":{"totalEpisodeCount":2}}]}`), &series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allEpisodesWatched(series, episodes) {
+		t.Errorf("S02E01 not watched")
+	}
+	if !allEpisodesWatched(series, parseEpisodes("S01E01-E04, S02E01-E02")) {
+		t.Errorf("expected the series to be watched, specials aside")
+	}
+	series.Ended = false
+	if allEpisodesWatched(series, parseEpisodes("S01E01-E04, S02E01-E02")) {
+		t.Errorf("continuing series are never fully watched")
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+// Radarr webhook payloads, one struct per eventType
+
+type RadarrMovie struct {
+	Id          int    `json:"id"`
+	Title       string `json:"title"`
+	Year        int    `json:"year"`
+	ReleaseDate string `json:"releaseDate"`
+	FolderPath  string `json:"folderPath"`
+	ImdbId      string `json:"imdbId"`
+	TmdbId      int    `json:"tmdbId"`
+}
+
+// Fields common to every Radarr event, used to route the payload
+type RadarrEvent struct {
+	EventType      string      `json:"eventType"`
+	InstanceName   string      `json:"instanceName"`
+	ApplicationUrl string      `json:"applicationUrl"`
+	Movie          RadarrMovie `json:"movie"`
+}
+
+type RadarrMovieFile struct {
+	Id             int    `json:"id"`
+	RelativePath   string `json:"relativePath"`
+	Path           string `json:"path"`
+	Quality        string `json:"quality"`
+	QualityVersion int    `json:"qualityVersion"`
+	ReleaseGroup   string `json:"releaseGroup"`
+	SceneName      string `json:"sceneName"`
+	Size           int64  `json:"size"`
+}
+
+type RadarrGrabPayload struct {
+	RadarrEvent
+	Release        Release `json:"release"`
+	DownloadClient string  `json:"downloadClient"`
+	DownloadId     string  `json:"downloadId"`
+}
+
+type RadarrDownloadPayload struct {
+	RadarrEvent
+	MovieFile      RadarrMovieFile `json:"movieFile"`
+	Release        Release         `json:"release"`
+	IsUpgrade      bool            `json:"isUpgrade"`
+	DownloadClient string          `json:"downloadClient"`
+	DownloadId     string          `json:"downloadId"`
+}
+
+type RadarrRenamePayload struct {
+	RadarrEvent
+}
+
+type RadarrMovieAddedPayload struct {
+	RadarrEvent
+	AddMethod string `json:"addMethod"`
+}
+
+type RadarrMovieDeletePayload struct {
+	RadarrEvent
+	DeletedFiles    bool  `json:"deletedFiles"`
+	MovieFolderSize int64 `json:"movieFolderSize"`
+}
+
+type RadarrMovieFileDeletePayload struct {
+	RadarrEvent
+	DeleteReason string `json:"deleteReason"` // "manual" | "missingFromDisk" | "upgrade" | "noLinkedEpisodes" | "manualOverride" | "unknown"
+}
+
+type RadarrManualInteractionRequiredPayload struct {
+	RadarrEvent
+	DownloadClient         string               `json:"downloadClient"`
+	DownloadId             string               `json:"downloadId"`
+	DownloadStatus         string               `json:"downloadStatus"`
+	DownloadStatusMessages []DownloadStatusInfo `json:"downloadStatusMessages"`
+}
+
+// Sonarr webhook payloads, one struct per eventType
+
+type SonarrSeries struct {
+	Id     int    `json:"id"`
+	Title  string `json:"title"`
+	Year   int    `json:"year"`
+	Path   string `json:"path"`
+	ImdbId string `json:"imdbId"`
+	TvdbId int    `json:"tvdbId"`
+	TmdbId int    `json:"tmdbId"`
+	Type   string `json:"type"`
+}
+
+// Fields common to every Sonarr event, used to route the payload
+type SonarrEvent struct {
+	EventType      string       `json:"eventType"`
+	InstanceName   string       `json:"instanceName"`
+	ApplicationUrl string       `json:"applicationUrl"`
+	Series         SonarrSeries `json:"series"`
+}
+
+type SonarrEpisode struct {
+	Id            int    `json:"id"`
+	EpisodeNumber int    `json:"episodeNumber"`
+	SeasonNumber  int    `json:"seasonNumber"`
+	Title         string `json:"title"`
+	AirDate       string `json:"airDate"`
+}
+
+type SonarrEpisodeFile struct {
+	Id             int    `json:"id"`
+	RelativePath   string `json:"relativePath"`
+	Path           string `json:"path"`
+	Quality        string `json:"quality"`
+	QualityVersion int    `json:"qualityVersion"`
+	ReleaseGroup   string `json:"releaseGroup"`
+	SceneName      string `json:"sceneName"`
+	Size           int64  `json:"size"`
+}
+
+type SonarrGrabPayload struct {
+	SonarrEvent
+	Episodes       []SonarrEpisode `json:"episodes"`
+	Release        Release         `json:"release"`
+	DownloadClient string          `json:"downloadClient"`
+	DownloadId     string          `json:"downloadId"`
+}
+
+type SonarrDownloadPayload struct {
+	SonarrEvent
+	Episodes       []SonarrEpisode     `json:"episodes"`
+	EpisodeFile    SonarrEpisodeFile   `json:"episodeFile"`
+	EpisodeFiles   []SonarrEpisodeFile `json:"episodeFiles"` // season packs
+	Release        Release             `json:"release"`
+	IsUpgrade      bool                `json:"isUpgrade"`
+	DownloadClient string              `json:"downloadClient"`
+	DownloadId     string              `json:"downloadId"`
+}
+
+type SonarrRenamePayload struct {
+	SonarrEvent
+}
+
+type SonarrSeriesAddPayload struct {
+	SonarrEvent
+}
+
+type SonarrSeriesDeletePayload struct {
+	SonarrEvent
+	DeletedFiles bool `json:"deletedFiles"`
+}
+
+type SonarrEpisodeFileDeletePayload struct {
+	SonarrEvent
+	DeleteReason string `json:"deleteReason"` // "manual" | "missingFromDisk" | "upgrade" | "noLinkedEpisodes" | "manualOverride" | "unknown"
+}
+
+type SonarrManualInteractionRequiredPayload struct {
+	SonarrEvent
+	DownloadClient         string               `json:"downloadClient"`
+	DownloadId             string               `json:"downloadId"`
+	DownloadStatus         string               `json:"downloadStatus"`
+	DownloadStatusMessages []DownloadStatusInfo `json:"downloadStatusMessages"`
+}
+
+// Payloads shared by Radarr and Sonarr
+
+// Release grabbed from the indexer
+type Release struct {
+	Quality           string `json:"quality"`
+	QualityVersion    int    `json:"qualityVersion"`
+	ReleaseGroup      string `json:"releaseGroup"`
+	ReleaseTitle      string `json:"releaseTitle"`
+	Indexer           string `json:"indexer"`
+	Size              int64  `json:"size"`
+	CustomFormatScore int    `json:"customFormatScore"`
+}
+
+type DownloadStatusInfo struct {
+	Title    string   `json:"title"`
+	Messages []string `json:"messages"`
+}
+
+// Health and HealthRestored
+type HealthPayload struct {
+	EventType string `json:"eventType"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	WikiUrl   string `json:"wikiUrl"`
+}
+
+type ApplicationUpdatePayload struct {
+	EventType       string `json:"eventType"`
+	Message         string `json:"message"`
+	PreviousVersion string `json:"previousVersion"`
+	NewVersion      string `json:"newVersion"`
+}
@@ -1,43 +1,159 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/flxp49/notion-watchlistarr/internal/activity"
+	"github.com/flxp49/notion-watchlistarr/internal/app"
+	"github.com/flxp49/notion-watchlistarr/internal/metrics"
+	"github.com/flxp49/notion-watchlistarr/internal/notify"
 	"github.com/flxp49/notion-watchlistarr/internal/notion"
+	"github.com/flxp49/notion-watchlistarr/internal/queue"
 	"github.com/flxp49/notion-watchlistarr/internal/radarr"
 	"github.com/flxp49/notion-watchlistarr/internal/sonarr"
 )
 
+// Jobs are the background jobs of the app the server can trigger
+type Jobs interface {
+	Trigger(job string) bool
+	NextRuns() map[string]time.Time
+	RetryTitle(pageID string) error
+	RequestTitle(req app.TitleRequest) (app.TitleResult, error)
+	Sync(ctx context.Context, service string) ([]app.SyncSummary, error)
+	SyncTitle(imdbID string) (activity.Title, error)
+	Report(stuckDays int) (app.Report, error)
+	PublishReport(report app.Report) (string, error)
+	Releases(from time.Time, to time.Time) ([]notion.CalendarEntry, error)
+}
+
 type Server struct {
-	listenAddr string
-	N          *notion.NotionClient
-	R          *radarr.RadarrClient
-	S          *sonarr.SonarrClient
-	Logger     *slog.Logger
-	RadarrInit bool
-	SonarrInit bool
+	bindAddr             string
+	listenAddr           string
+	auth                 WebhookAuth
+	allowUnauthenticated bool
+	N                    *notion.NotionClient
+	R                    *radarr.RadarrClient
+	S                    *sonarr.SonarrClient
+	Q                    *queue.Queue
+	Tracker              *activity.Tracker
+	Jobs                 Jobs
+	Logger               *slog.Logger
+	RadarrInit           bool
+	SonarrInit           bool
+	// time given to in-flight requests and webhook processing on shutdown
+	ShutdownTimeout time.Duration
+	// which pages of a title the webhook events are written to, see app.PagesToUpdate
+	DuplicatePolicy string
+	// receives the Added, Grabbed, Downloaded and Stalled transitions of the webhook events, nil sends nothing
+	Notifier *notify.Notifier
+	// the Sonarr series of the episodes by TVDB ID and the series fetched, see seriesOfEpisode
+	episodeSeries map[int]int
+	indexedSeries map[int]bool
+	episodesMu    sync.Mutex
 }
 
-func NewServer(listenAddr string, N *notion.NotionClient, R *radarr.RadarrClient, S *sonarr.SonarrClient, Logger *slog.Logger, RadarrInit bool, SonarrInit bool) *Server {
+func NewServer(bindAddr string, listenAddr string, auth WebhookAuth, allowUnauthenticated bool, N *notion.NotionClient, R *radarr.RadarrClient, S *sonarr.SonarrClient, Q *queue.Queue, Tracker *activity.Tracker, Jobs Jobs, Logger *slog.Logger, RadarrInit bool, SonarrInit bool) *Server {
 	return &Server{
-		listenAddr: listenAddr,
-		N:          N,
-		R:          R,
-		S:          S,
-		Logger:     Logger,
-		RadarrInit: RadarrInit,
-		SonarrInit: SonarrInit,
+		bindAddr:             bindAddr,
+		listenAddr:           listenAddr,
+		auth:                 auth,
+		allowUnauthenticated: allowUnauthenticated,
+		N:                    N,
+		R:                    R,
+		S:                    S,
+		Q:                    Q,
+		Tracker:              Tracker,
+		Jobs:                 Jobs,
+		Logger:               Logger,
+		RadarrInit:           RadarrInit,
+		SonarrInit:           SonarrInit,
+		ShutdownTimeout:      10 * time.Second,
+		DuplicatePolicy:      app.DuplicatesUpdateAll,
 	}
 }
 
-func (s *Server) Start() error {
-	http.HandleFunc("/", s.incorrectReqHandler)
+// routes registers the endpoints on the server's own mux
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.incorrectReqHandler)
+	mux.HandleFunc("GET /healthz", s.healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /api/titles", s.requireAuth(s.titlesHandler))
+	mux.HandleFunc("GET /api/events", s.requireAuth(s.eventsHandler))
+	mux.HandleFunc("GET /api/status", s.requireAuth(s.statusHandler))
+	mux.HandleFunc("POST /api/trigger/{job}", s.requireAuth(s.triggerHandler))
+	mux.HandleFunc("POST /api/retry/{pageId}", s.requireAuth(s.retryHandler))
+	mux.HandleFunc("POST /api/request", s.requireAuth(s.requestHandler))
+	mux.HandleFunc("POST /api/sync", s.requireAuth(s.syncHandler))
+	mux.HandleFunc("POST /api/sync/{imdbId}", s.requireAuth(s.syncTitleHandler))
+	mux.HandleFunc("GET /api/report", s.requireAuth(s.reportHandler))
+	mux.HandleFunc("POST /api/report", s.requireAuth(s.publishReportHandler))
+	mux.HandleFunc("GET /calendar.ics", s.requireAuth(s.calendarHandler))
+	mux.HandleFunc("POST /jellyfin", s.requireAuth(s.jellyfinHandler))
+	mux.HandleFunc("POST /plex", s.requireAuth(s.plexHandler))
+	mux.HandleFunc("GET /{$}", s.requireAuth(s.dashboardHandler))
 	if s.RadarrInit {
-		http.HandleFunc("POST /radarr", s.radarrHandler)
+		mux.HandleFunc("POST /radarr", s.requireAuth(s.radarrHandler))
 	}
 	if s.SonarrInit {
-		http.HandleFunc("POST /sonarr", s.sonarrHandler)
+		mux.HandleFunc("POST /sonarr", s.requireAuth(s.sonarrHandler))
+	}
+	return mux
+}
+
+// Start serves until ctx is cancelled, then stops accepting requests, lets the in-flight requests and the
+// webhook event being processed finish (within ShutdownTimeout) and returns
+func (s *Server) Start(ctx context.Context) error {
+	if !s.auth.Enabled() {
+		if !isLoopback(s.bindAddr) && !s.allowUnauthenticated {
+			return errors.New("refusing to expose webhooks on a non-loopback address without a secret, set WEBHOOK_TOKEN or WEBHOOK_USERNAME/WEBHOOK_PASSWORD (or ALLOW_UNAUTHENTICATED_WEBHOOK=true)")
+		}
+		s.Logger.Warn("Server", "Status", "Webhook authentication disabled")
+	}
+	s.Q.Handle(radarrJob, s.trackFailures("radarr", s.processRadarrEvent))
+	s.Q.Handle(sonarrJob, s.trackFailures("sonarr", s.processSonarrEvent))
+	s.Q.Handle(playbackJob, s.trackFailures("media-server", s.processPlaybackEvent))
+	queueDone := make(chan struct{})
+	go func() {
+		s.Q.Run(ctx)
+		close(queueDone)
+	}()
+	s.registerMetrics()
+
+	srv := &http.Server{
+		Addr:    net.JoinHostPort(s.bindAddr, s.listenAddr),
+		Handler: s.routes(),
+		// request contexts are cancelled on shutdown, long requests (ex: /api/sync) stop early
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-listenErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.Logger.Info("Server", "Status", "Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		return errors.Join(errors.New("failed to shut down http server"), err)
+	}
+	select {
+	case <-queueDone:
+	case <-shutdownCtx.Done():
+		return errors.New("timed out waiting for the webhook event being processed")
 	}
-	return http.ListenAndServe(":"+s.listenAddr, nil)
+	return nil
 }